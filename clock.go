@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time used by a Runner, by Sleep(), and by any
+// Runnable that retrieves it with ClockFromContext(). Replacing it with a
+// fake clock, such as servicetest.FakeClock, allows time-dependent behaviour
+// to be tested deterministically.
+//
+// SystemClock, which uses the time package, is used if no other Clock is
+// supplied.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the Clock equivalent of a *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the Clock equivalent of a *time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+type systemTicker struct{ *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// RunnerClock sets the Clock used by the Runner, and by the services it runs
+// via ClockFromContext(). The default is SystemClock.
+func RunnerClock(clock Clock) RunnerOption {
+	return func(rn *runner) { rn.clock = clock }
+}
+
+type clockKey struct{}
+
+// ContextWithClock returns a copy of ctx that carries clock, for use with
+// ClockFromContext().
+//
+// A service.Context passed to Run() already carries the Runner's Clock, so you
+// only need this if you are calling code that expects a Clock from outside a
+// Runnable, or to override the Runner's Clock for the services started by a
+// single call to Start(). The override lasts until those services end, not
+// just until they are Ready.
+func ContextWithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// ClockFromContext returns the Clock carried by ctx. If ctx is a
+// service.Context, this is the Clock of the Runner that started the service,
+// or the Clock carried by the context passed to Start(), if there was one.
+// If ctx does not carry a Clock, or is nil, SystemClock is returned.
+func ClockFromContext(ctx context.Context) Clock {
+	if ctx != nil {
+		if clock, ok := ctx.Value(clockKey{}).(Clock); ok && clock != nil {
+			return clock
+		}
+	}
+	return SystemClock
+}
+
+// WithClockTimeout is the same as context.WithTimeout(), except the timeout
+// is measured by clock. If clock is nil or SystemClock, it simply calls
+// context.WithTimeout().
+//
+// The returned context carries clock, as per ContextWithClock().
+func WithClockTimeout(parent context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if clock == nil || clock == SystemClock {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	cctx, cancel := context.WithCancel(ContextWithClock(parent, clock))
+	tctx := &timeoutContext{Context: cctx, deadline: clock.Now().Add(timeout)}
+	if d, ok := parent.Deadline(); ok && d.Before(tctx.deadline) {
+		tctx.deadline = d
+	}
+
+	tm := clock.NewTimer(timeout)
+	go func() {
+		select {
+		case <-tm.C():
+			if cctx.Err() == nil {
+				atomic.StoreInt32(&tctx.expired, 1)
+			}
+			cancel()
+		case <-cctx.Done():
+			tm.Stop()
+		}
+	}()
+	return tctx, cancel
+}
+
+// timeoutContext is a cancellable context that reports
+// context.DeadlineExceeded if it was cancelled by a Clock's timer.
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	expired  int32
+}
+
+func (t *timeoutContext) Deadline() (deadline time.Time, ok bool) { return t.deadline, true }
+
+// Err only reports context.DeadlineExceeded once Done() is closed, as expired
+// is set just before the context is cancelled.
+func (t *timeoutContext) Err() error {
+	err := t.Context.Err()
+	if err != nil && atomic.LoadInt32(&t.expired) == 1 {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// clockOf returns the Clock used by a Runner created by NewRunner(), or
+// SystemClock for any other Runner.
+func clockOf(r Runner) Clock {
+	if rn, ok := r.(*runner); ok {
+		return rn.clock
+	}
+	return SystemClock
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+// testClock is a Clock that can be told apart from SystemClock.
+type testClock struct{ Clock }
+
+func TestContextWithClockOverridesRunner(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	runnerClock := &testClock{SystemClock}
+	startClock := &testClock{SystemClock}
+
+	clocks := make(chan Clock, 2)
+	r := NewRunner(RunnerClock(runnerClock))
+	svc := New("", RunnableFunc(func(ctx Context) error {
+		clocks <- ClockFromContext(ctx)
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		clocks <- ClockFromContext(ctx)
+		<-ctx.Done()
+		return nil
+	}))
+
+	// The Clock from Start()'s context should still be used once the service
+	// is Ready, even though the context itself no longer is:
+	ctx, cancel := context.WithTimeout(ContextWithClock(context.Background(), startClock), dto)
+	defer cancel()
+	tt.MustOK(r.Start(ctx, svc))
+	tt.MustAssert(<-clocks == startClock)
+	tt.MustAssert(<-clocks == startClock)
+	tt.MustOK(HaltTimeout(dto, r, svc))
+
+	// Without an override, the Runner's Clock is used:
+	tt.MustOK(StartTimeout(dto, r, svc))
+	tt.MustAssert(<-clocks == runnerClock)
+	tt.MustAssert(<-clocks == runnerClock)
+	tt.MustOK(HaltTimeout(dto, r, svc))
+}
+
+func TestWithClockTimeoutErrFollowsDone(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	// The timer sets expired just before it cancels the context; Err() must
+	// not report the deadline in between, while Done() is still open:
+	cctx, cancel := context.WithCancel(context.Background())
+	tctx := &timeoutContext{Context: cctx, expired: 1}
+	tt.MustOK(tctx.Err())
+	cancel()
+	tt.MustEqual(context.DeadlineExceeded, tctx.Err())
+
+	clock := &testClock{SystemClock}
+	ctx, cancel := WithClockTimeout(context.Background(), clock, tscale)
+	defer cancel()
+	<-ctx.Done()
+	tt.MustEqual(context.DeadlineExceeded, ctx.Err())
+
+	// Cancelling first is not a timeout:
+	ctx, cancel = WithClockTimeout(context.Background(), clock, dto)
+	cancel()
+	<-ctx.Done()
+	tt.MustEqual(context.Canceled, ctx.Err())
+}
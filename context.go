@@ -8,7 +8,8 @@ import (
 // MinHaltableSleep specifies the minimum amount of time that you must
 // pass to service.Sleep() if you want the Sleep() to be cancellable
 // from a context. Calls to service.Sleep() with a duration smaller than
-// this will simply call time.Sleep().
+// this will simply call time.Sleep(), unless a Clock other than SystemClock
+// is in use.
 const MinHaltableSleep = 50 * time.Millisecond
 
 /*
@@ -47,6 +48,10 @@ be signalled when your service is halted:
 		return nil
 	}
 
+Err() returns nil while the service is running. Once Done() has yielded, it
+returns context.Canceled, or the error from the context passed to
+Runner.Start() if that context ended the service before it was Ready.
+
 */
 type Context interface {
 	context.Context
@@ -71,12 +76,47 @@ type Context interface {
 	Runner() Runner
 }
 
+// DrainingContext is implemented by a Context that can tell a service it is
+// being drained. The Context passed to Run() by the Runner returned by
+// NewRunner() implements it; use DrainingFromContext() rather than asserting it
+// directly, so that wrapped or custom Contexts keep working.
+//
+// It is separate from Context so that adding it did not break existing
+// implementations of Context.
+type DrainingContext interface {
+	Context
+
+	// Draining yields when the service has been asked to drain by
+	// Runner.Drain() or Runner.Shutdown(). A draining service should stop
+	// accepting new work (for example, by reporting itself as unhealthy to a
+	// load balancer) but should continue to run until <-Done() yields.
+	//
+	// If the service is halted without being drained first, Draining will
+	// never yield.
+	Draining() <-chan struct{}
+}
+
+// DrainingFromContext returns ctx.Draining() if ctx is a DrainingContext.
+// Otherwise it returns nil, which never yields, so it is always safe to
+// select on.
+func DrainingFromContext(ctx Context) <-chan struct{} {
+	if dc, ok := ctx.(DrainingContext); ok {
+		return dc.Draining()
+	}
+	return nil
+}
+
 // Sleep allows a Runnable to perform an interruptible sleep - it will return
 // early if the Service is halted.
+//
+// The sleep is measured by the Clock carried by ctx, as per
+// ClockFromContext(). For a service.Context, this is the Runner's Clock.
 func Sleep(ctx context.Context, d time.Duration) (halted bool) {
+	clock := ClockFromContext(ctx)
+
 	// MinHaltableSleep is a performance hack. It's probably not a
 	// one-size-fits all constant but it'll do for now.
-	if d < MinHaltableSleep {
+	if d < MinHaltableSleep && clock == SystemClock {
 		time.Sleep(d)
 		select {
 		case <-ctx.Done():
@@ -85,8 +125,11 @@ func Sleep(ctx context.Context, d time.Duration) (halted bool) {
 			return false
 		}
 	}
+
+	tm := clock.NewTimer(d)
+	defer tm.Stop()
 	select {
-	case <-time.After(d):
+	case <-tm.C():
 		return false
 	case <-ctx.Done():
 		return true
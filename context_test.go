@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestContextErr(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	errs := make(chan error, 3)
+	r := NewRunner()
+	svc := New("", RunnableFunc(func(ctx Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		child, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		errs <- ctx.Err()
+		<-ctx.Done()
+		errs <- ctx.Err()
+
+		// Child contexts rely on Err() being set once Done() has yielded:
+		<-child.Done()
+		errs <- child.Err()
+		return nil
+	}))
+
+	tt.MustOK(StartTimeout(dto, r, svc))
+	tt.MustOK(<-errs)
+	tt.MustOK(HaltTimeout(dto, r, svc))
+	tt.MustEqual(context.Canceled, <-errs)
+	tt.MustEqual(context.Canceled, <-errs)
+}
+
+func TestContextErrStartTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	errs := make(chan error, 1)
+	r := NewRunner()
+	svc := New("", RunnableFunc(func(ctx Context) error {
+		// Never becomes ready, so the context passed to Start() ends it:
+		<-ctx.Done()
+		errs <- ctx.Err()
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), tscale)
+	defer cancel()
+	err := r.Start(ctx, svc)
+	tt.MustAssert(IsTimeout(err), err)
+
+	// Callers that compared against the context's error directly before
+	// TimeoutError existed can use errors.Is() instead:
+	tt.MustAssert(errors.Is(err, context.DeadlineExceeded))
+	tt.MustAssert(!errors.Is(err, context.Canceled))
+	tt.MustEqual(context.DeadlineExceeded, <-errs)
+	tt.MustOK(HaltTimeout(dto, r, svc))
+}
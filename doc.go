@@ -136,6 +136,10 @@ The Run() method SHOULD do the following:
 	- service.Sleep(ctx) should be used instead of time.Sleep(); service.Sleep()
 	  is haltable.
 
+	- Timers and tickers should be created with service.ClockFromContext(ctx)
+	  rather than the time package, so that tests can control them by passing
+	  a fake Clock to service.RunnerClock().
+
 Here is an example of a Run() method which uses a select{} loop:
 
 	func (m *MyRunnable) Run(ctx service.Context) error {
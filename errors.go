@@ -18,6 +18,8 @@ type (
 // it from their own services.
 var ErrServiceEnded = errors.New("service ended")
 
+var errDrainCancelled = errors.New("service: drain cancelled")
+
 func (errRunnerNotEnabled) Error() string { return "service: runner not enabled" }
 func (errAlreadyRunning) Error() string   { return "service: already running" }
 
@@ -99,6 +101,58 @@ func (s *serviceError) Error() string {
 	return fmt.Sprintf("service %q error: %v", s.name, s.cause)
 }
 
+// TimeoutError is returned by Runner.Start(), Halt(), Drain() and Shutdown()
+// if the context is done before every service has finished starting or
+// halting. It wraps the context's error, so callers can still check for it
+// with errors.Is(err, context.DeadlineExceeded).
+type TimeoutError struct {
+	// Op is "start", "halt", "drain" or "shutdown".
+	Op string
+
+	// Err is the error returned by the context's Err() method.
+	Err error
+
+	// Pending lists the services that had not finished, with the State they
+	// were in when the context was done.
+	Pending []PendingService
+
+	// Completed lists the services that had finished, in the order they
+	// finished, with their errors.
+	Completed []CompletedService
+}
+
+type PendingService struct {
+	Service *Service
+	State   State
+}
+
+type CompletedService struct {
+	Service *Service
+	Err     error
+}
+
+func IsTimeout(err error) bool { _, ok := err.(*TimeoutError); return ok }
+
+func (e *TimeoutError) Cause() error  { return e.Err }
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+func (e *TimeoutError) Error() string {
+	var b strings.Builder
+	total := len(e.Pending) + len(e.Completed)
+	b.WriteString(fmt.Sprintf("service: %s timed out with %d of %d service(s) pending", e.Op, len(e.Pending), total))
+	for i, p := range e.Pending {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(fmt.Sprintf("%q (%s)", p.Service.Name, p.State))
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
 type errState struct {
 	Expected, To, Current State
 }
@@ -1,10 +1,11 @@
 package service
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"runtime/pprof"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,33 +16,90 @@ const (
 	tscale = 5 * time.Millisecond
 
 	dto = 100 * tscale
+
+	leakTimeout = 1 * time.Second
 )
 
 func TestMain(m *testing.M) {
-	beforeCount := pprof.Lookup("goroutine").Count()
+	before := goroutineStacks()
 	code := m.Run()
 
-	// This little hack gives things like "go OnServiceState" a chance to
-	// finish - it routinely shows up in the profile.
-	//
-	// Also, some calls to the listener that are called with "go" might
-	// not have had a chance to finish. This is brittle, true, but some
-	// of the tests are hopelessly complicated without it.
-	time.Sleep(20 * time.Millisecond)
-
 	if code == 0 {
-
-		after := pprof.Lookup("goroutine")
-		afterCount := after.Count()
-
-		diff := afterCount - beforeCount
-		if diff > 0 {
-			var buf bytes.Buffer
-			after.WriteTo(&buf, 1)
-			fmt.Fprintf(os.Stderr, "stray goroutines: %d\n%s\n", diff, buf.String())
+		if leaked := leakedGoroutines(before, leakTimeout); len(leaked) > 0 {
+			fmt.Fprintf(os.Stderr, "%d leaked goroutine(s):\n\n%s\n", len(leaked), strings.Join(leaked, "\n\n"))
 			os.Exit(2)
 		}
 	}
 
 	os.Exit(code)
 }
+
+// This is a cut-down copy of servicetest.VerifyNoLeaksMain, which can't be
+// used here as servicetest imports this package. Goroutines are compared by
+// ID rather than counted, so one that finishes can't hide one that leaked.
+// Things like "go OnServiceState" routinely take a moment to finish after
+// the tests do, so the check is retried until leakTimeout.
+
+// goroutineStacks returns the stack of every goroutine, keyed by its ID.
+func goroutineStacks() map[int64]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	stacks := make(map[int64]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		var id int64
+		if _, err := fmt.Sscanf(stack, "goroutine %d ", &id); err == nil {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
+
+func leakedGoroutines(before map[int64]string, timeout time.Duration) (leaked []string) {
+	deadline := time.Now().Add(timeout)
+	backoff := time.Millisecond
+	for {
+		leaked = leaked[:0]
+		for id, stack := range goroutineStacks() {
+			if _, ok := before[id]; !ok && !ignoredGoroutine(stack) {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || !time.Now().Before(deadline) {
+			sort.Strings(leaked)
+			return leaked
+		}
+
+		time.Sleep(backoff)
+		if backoff < 100*time.Millisecond {
+			backoff *= 2
+		}
+	}
+}
+
+// ignoredGoroutine reports whether a goroutine was started by the runtime or
+// the test framework rather than the code under test.
+func ignoredGoroutine(stack string) bool {
+	var entry string
+	for _, line := range strings.Split(stack, "\n")[1:] {
+		if strings.HasPrefix(line, "created by ") {
+			break
+		}
+		if !strings.HasPrefix(line, "\t") {
+			entry = line
+		}
+	}
+	for _, prefix := range []string{"runtime.", "runtime/", "testing.", "os/signal."} {
+		if strings.HasPrefix(entry, prefix) {
+			return true
+		}
+	}
+	return false
+}
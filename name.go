@@ -1,12 +1,28 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 )
 
 type Name string
 
+// PprofLabel is the pprof label the Runner sets to the Name of a service on
+// the goroutine that runs it. Goroutines started by the service inherit the
+// label, so it can be used to find them in a goroutine profile. See Runner
+// for what it costs.
+const PprofLabel = "service"
+
+// labelContext returns the context a service's pprof labels are added to, so
+// that any labels on the context passed to Start() are kept.
+func labelContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
 func (s Name) Append(name string) Name { return Name(string(s) + "/" + name) }
 
 func (s Name) AppendName(name Name) Name { return Name(string(s) + "/" + string(name)) }
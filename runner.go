@@ -3,12 +3,20 @@ package service
 import (
 	"context"
 	"fmt"
+	"runtime/pprof"
 	"sync"
+	"time"
 
 	"github.com/shabbyrobe/go-service/signal"
 )
 
 // Runner Starts, Halts and manages Services.
+//
+// The goroutine that runs each service is given a pprof label, PprofLabel,
+// set to the service's Name, so leaked or stuck goroutines can be traced back
+// to their service. This is always done, whether or not anything reads the
+// labels; it costs a few small allocations each time a service is started,
+// and every goroutine the service starts inherits the label.
 type Runner interface {
 	// Start one or more services in this runner and block until they are Ready.
 	//
@@ -48,9 +56,33 @@ type Runner interface {
 	// fix the issue.
 	Halt(ctx context.Context, services ...*Service) error
 
+	// Drain one or more services that have been started in this runner, then
+	// Halt them.
+	//
+	// Services that are Started are moved to the Draining state and their
+	// Context.Draining() channel yields, which gives them a chance to stop
+	// accepting new work (i.e. to report themselves as unhealthy so a load
+	// balancer stops sending traffic). Drain then waits for the "lame duck"
+	// period supplied by RunnerDrainPeriod before halting the services.
+	//
+	// Services in any other state are halted without draining.
+	//
+	// If ctx is cancelled during the lame duck period, Drain returns a
+	// *TimeoutError with every service pending, and the services are left
+	// Draining; you should Halt() them.
+	Drain(ctx context.Context, services ...*Service) error
+
 	// Shutdown halts all services started in this runner and prevents new ones
 	// from being started. It will block until all services have Halted.
 	//
+	// If the runner was created with RunnerDrainPeriod, every Started service
+	// is drained together before any of them are halted, so the lame duck
+	// period is only waited once. See Drain(). No services can be started
+	// during the lame duck period. If ctx is cancelled during it, Shutdown
+	// returns a *TimeoutError, the services are left Draining and the runner
+	// returns to the RunnerState it was in before; call Shutdown() or Halt()
+	// again to finish.
+	//
 	// If any service fails to halt, err will contain an error for each service
 	// that failed, accessible by calling service.Errors(err). n will contain
 	// the number of services successfully halted.
@@ -116,6 +148,12 @@ func RunnerOnEnd(cb OnEnd) RunnerOption { return func(rn *runner) { rn.onEnd = c
 func RunnerOnError(cb OnError) RunnerOption            { return func(rn *runner) { rn.onError = cb } }
 func RunnerOnState(ch chan<- StateChange) RunnerOption { return func(rn *runner) { rn.onState = ch } }
 
+// RunnerDrainPeriod sets the "lame duck" period that Drain() and Shutdown()
+// will wait for after services start Draining, but before they are Halted.
+func RunnerDrainPeriod(d time.Duration) RunnerOption {
+	return func(rn *runner) { rn.drainPeriod = d }
+}
+
 type runner struct {
 	// runner listeners MUST NOT be changed after runner is created, they are
 	// accessed without a lock.
@@ -123,6 +161,9 @@ type runner struct {
 	onError OnError
 	onState chan<- StateChange
 
+	drainPeriod time.Duration
+	clock       Clock
+
 	nextID   uint64
 	services map[*Service]*runnerService
 	state    RunnerState
@@ -135,10 +176,14 @@ var _ Runner = &runner{}
 func NewRunner(opts ...RunnerOption) Runner {
 	rn := &runner{
 		services: make(map[*Service]*runnerService),
+		clock:    SystemClock,
 	}
 	for _, o := range opts {
 		o(rn)
 	}
+	if rn.clock == nil {
+		rn.clock = SystemClock
+	}
 	return rn
 }
 
@@ -171,6 +216,40 @@ func (rn *runner) Suspend() error {
 func (rn *runner) Shutdown(ctx context.Context) (rerr error) {
 	var sg signal.Signal
 
+	var ctxDone <-chan struct{}
+	if ctx != nil {
+		ctxDone = ctx.Done()
+	}
+
+	if rn.drainPeriod > 0 {
+		// New services must not be started during the lame duck period, but
+		// if it is cancelled the runner is put back the way it was, as
+		// nothing has been halted:
+		rn.mu.Lock()
+		prevState := rn.state
+		rn.state = RunnerShutdown
+		drained := 0
+		pending := make([]*Service, 0, len(rn.services))
+		for svc, rs := range rn.services {
+			if rs.draining() {
+				drained++
+			}
+			pending = append(pending, svc)
+		}
+		rn.mu.Unlock()
+
+		if drained > 0 {
+			if err := rn.lameDuck(ctxDone); err != nil {
+				rn.mu.Lock()
+				if rn.state == RunnerShutdown {
+					rn.state = prevState
+				}
+				rn.mu.Unlock()
+				return rn.drainTimeoutError("shutdown", ctx.Err(), pending)
+			}
+		}
+	}
+
 	if err := func() error {
 		rn.mu.Lock()
 		defer rn.mu.Unlock()
@@ -180,7 +259,7 @@ func (rn *runner) Shutdown(ctx context.Context) (rerr error) {
 		rn.state = RunnerShutdown
 
 		for _, rs := range rn.services {
-			if err := rs.halting(sg); err != nil {
+			if err := rs.halting(signal.Named(sg, rs.service)); err != nil {
 				panic(err)
 			}
 		}
@@ -190,18 +269,13 @@ func (rn *runner) Shutdown(ctx context.Context) (rerr error) {
 		return err
 	}
 
-	var ctxDone <-chan struct{}
-	if ctx != nil {
-		ctxDone = ctx.Done()
-	}
-
-	select {
-	case err := <-sg.Waiter():
+	if err := signal.AwaitSignal(ctx, sg); err != nil {
+		if ctx != nil && err == ctx.Err() {
+			return rn.timeoutError("shutdown", err, sg)
+		}
 		return err
-
-	case <-ctxDone:
-		return ctx.Err()
 	}
+	return nil
 }
 
 func (rn *runner) Start(ctx context.Context, services ...*Service) error {
@@ -228,28 +302,33 @@ func (rn *runner) Start(ctx context.Context, services ...*Service) error {
 			continue
 		}
 
+		svcReady := signal.Named(ready, svc)
+
 		rs := rn.services[svc]
 		if rs != nil {
 			// FIXME: if Done() is false, is this is a problem we need to handle or the
 			// owner of the signal's problem?
-			ready.Done(errAlreadyRunning(1))
+			svcReady.Done(errAlreadyRunning(1))
 			continue
 		}
 
 		rn.nextID++
-		rs = newRunnerService(rn.nextID, rn, svc, ready)
+		rs = newRunnerService(rn.nextID, rn, svc, svcReady)
 		rn.services[svc] = rs
 
 		if err := rs.starting(ctx); err != nil {
 			// FIXME: if Done() is false, is this is a problem we need to handle or the
 			// owner of the signal's problem?
-			ready.Done(err)
+			svcReady.Done(err)
 			continue
 		}
 
 		go func(rs *runnerService, svc *Service) {
 			// rn.lock is not assumed to be acquired in here.
-			rerr := svc.Runnable.Run(rs)
+			var rerr error
+			pprof.Do(labelContext(ctx), pprof.Labels(PprofLabel, string(svc.Name)), func(context.Context) {
+				rerr = svc.Runnable.Run(rs)
+			})
 			if err := rn.ended(rs, rerr); err != nil {
 				panic(err)
 			}
@@ -257,24 +336,18 @@ func (rn *runner) Start(ctx context.Context, services ...*Service) error {
 	}
 	rn.mu.Unlock()
 
-	var ctxDone <-chan struct{}
-	if ctx != nil {
-		ctxDone = ctx.Done()
-	}
-
-	select {
-	case err := <-ready.Waiter():
-		errs = append(errs, Errors(err)...)
-		if len(errs) > 1 {
-			return &serviceErrors{errors: errs}
-		} else if len(errs) == 1 {
-			return errs[0]
+	if err := signal.AwaitSignal(ctx, ready); err != nil {
+		if ctx != nil && err == ctx.Err() {
+			return rn.timeoutError("start", err, ready)
 		}
-		return nil
-
-	case <-ctxDone:
-		return ctx.Err()
+		errs = append(errs, Errors(err)...)
+	}
+	if len(errs) > 1 {
+		return &serviceErrors{errors: errs}
+	} else if len(errs) == 1 {
+		return errs[0]
 	}
+	return nil
 }
 
 func (rn *runner) Halt(ctx context.Context, services ...*Service) (rerr error) {
@@ -289,39 +362,83 @@ func (rn *runner) Halt(ctx context.Context, services ...*Service) (rerr error) {
 
 	rn.mu.Lock()
 	for _, svc := range services {
+		svcDone := signal.Named(done, svc)
+
 		rs := rn.services[svc]
 		if rs == nil {
 			// FIXME: if Done() is false, is this is a problem we need to handle or the
 			// owner of the signal's problem?
-			done.Done(nil)
+			svcDone.Done(nil)
 			continue
 		}
 
 		// halting will always call done.Done()
-		if err := rs.halting(done); err != nil {
+		if err := rs.halting(svcDone); err != nil {
 			errs = append(errs, err)
 			continue
 		}
 	}
 	rn.mu.Unlock()
 
-	var ctxDone <-chan struct{}
-	if ctx != nil {
-		ctxDone = ctx.Done()
+	if err := signal.AwaitSignal(ctx, done); err != nil {
+		if ctx != nil && err == ctx.Err() {
+			return rn.timeoutError("halt", err, done)
+		}
+		errs = append(errs, Errors(err)...)
+	}
+	if len(errs) > 1 {
+		return &serviceErrors{errors: errs}
+	} else if len(errs) == 1 {
+		return errs[0]
 	}
+	return nil
+}
 
-	select {
-	case err := <-done.Waiter():
-		errs = append(errs, Errors(err)...)
-		if len(errs) > 1 {
-			return &serviceErrors{errors: errs}
-		} else if len(errs) == 1 {
-			return errs[0]
+func (rn *runner) Drain(ctx context.Context, services ...*Service) (rerr error) {
+	if len(services) == 0 {
+		return nil
+	}
+
+	drained := 0
+	pending := make([]*Service, 0, len(services))
+	rn.mu.Lock()
+	for _, svc := range services {
+		if rs := rn.services[svc]; rs != nil {
+			if rs.draining() {
+				drained++
+			}
+			pending = append(pending, svc)
+		}
+	}
+	rn.mu.Unlock()
+
+	if drained > 0 {
+		var ctxDone <-chan struct{}
+		if ctx != nil {
+			ctxDone = ctx.Done()
+		}
+		if err := rn.lameDuck(ctxDone); err != nil {
+			return rn.drainTimeoutError("drain", ctx.Err(), pending)
 		}
+	}
+
+	return rn.Halt(ctx, services...)
+}
+
+// lameDuck waits for the runner's drainPeriod to elapse. It returns
+// errDrainCancelled if ctxDone yields first.
+func (rn *runner) lameDuck(ctxDone <-chan struct{}) error {
+	if rn.drainPeriod <= 0 {
 		return nil
+	}
+	tm := rn.clock.NewTimer(rn.drainPeriod)
+	defer tm.Stop()
 
+	select {
+	case <-tm.C():
+		return nil
 	case <-ctxDone:
-		return ctx.Err()
+		return errDrainCancelled
 	}
 }
 
@@ -368,6 +485,32 @@ func (rn *runner) Services(query State, limit int, into []ServiceInfo) []Service
 	return into
 }
 
+// timeoutError describes the services that had and hadn't finished when op
+// was interrupted by a done context.
+func (rn *runner) timeoutError(op string, err error, sg signal.Signal) error {
+	progress := sg.Progress()
+	terr := &TimeoutError{Op: op, Err: err}
+	for _, key := range progress.Pending {
+		svc := key.(*Service)
+		terr.Pending = append(terr.Pending, PendingService{Service: svc, State: rn.State(svc)})
+	}
+	for _, result := range progress.Results {
+		terr.Completed = append(terr.Completed, CompletedService{Service: result.Key.(*Service), Err: result.Err})
+	}
+	return terr
+}
+
+// drainTimeoutError describes the services that were waiting for the lame
+// duck period when op was interrupted by a done context. Nothing has been
+// halted by then, so they are all pending.
+func (rn *runner) drainTimeoutError(op string, err error, services []*Service) error {
+	terr := &TimeoutError{Op: op, Err: err}
+	for _, svc := range services {
+		terr.Pending = append(terr.Pending, PendingService{Service: svc, State: rn.State(svc)})
+	}
+	return terr
+}
+
 func (rn *runner) State(svc *Service) (state State) {
 	rn.mu.RLock()
 	rs := rn.services[svc]
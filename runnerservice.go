@@ -16,11 +16,13 @@ type runnerService struct {
 
 	state      State
 	startCtx   context.Context
+	clock      Clock // The Runner's, unless Start() was passed a context with one
 	ready      signal.Signal
 	stage      Stage
 	waiters    []signal.Signal
 	done       <-chan struct{}
 	halt       chan struct{}
+	drain      chan struct{}
 	joinedDone *joinedDone
 
 	readyCalled bool
@@ -28,7 +30,7 @@ type runnerService struct {
 	mu sync.Mutex
 }
 
-var _ Context = &runnerService{}
+var _ DrainingContext = &runnerService{}
 
 func newRunnerService(id uint64, r *runner, svc *Service, ready signal.Signal) *runnerService {
 	rs := &runnerService{
@@ -39,6 +41,7 @@ func newRunnerService(id uint64, r *runner, svc *Service, ready signal.Signal) *
 		runner:  r,
 		service: svc,
 		halt:    make(chan struct{}),
+		drain:   make(chan struct{}),
 	}
 
 	rs.done = rs.halt
@@ -64,7 +67,14 @@ func (rs *runnerService) starting(ctx context.Context) error {
 	}
 
 	rs.startCtx = ctx
+	rs.clock = rs.runner.clock
 	if ctx != nil {
+		// The clock is kept separately as startCtx goes away once the service
+		// is ready, but the service should see the same clock the whole time:
+		if clock, ok := ctx.Value(clockKey{}).(Clock); ok && clock != nil {
+			rs.clock = clock
+		}
+
 		doneChan := ctx.Done()
 		if doneChan != nil {
 			rs.joinedDone = joinDone(rs.halt, doneChan)
@@ -78,6 +88,20 @@ func (rs *runnerService) starting(ctx context.Context) error {
 	return nil
 }
 
+// draining moves a Started service into the Draining state. It returns false
+// if the service was not in a state that can be drained.
+func (rs *runnerService) draining() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.state != Started {
+		return false
+	}
+	rs.setState(Draining)
+	close(rs.drain)
+	return true
+}
+
 func (rs *runnerService) halting(done signal.Signal) (rerr error) {
 	rs.mu.Lock()
 	if rs.state == NoState || rs.state == Halted || rs.state == Ended {
@@ -138,10 +162,12 @@ func (rs *runnerService) Ready() (rerr error) {
 		rerr = rs.startCtx.Err()
 	}
 
-	rs.setReady(rerr)
+	// The state must change before the ready signal is done, otherwise
+	// Start() may return while the service is still Starting.
 	if rs.state == Starting {
 		rs.setState(Started)
 	}
+	rs.setReady(rerr)
 
 	rs.mu.Unlock()
 	rs.runner.mu.Unlock()
@@ -159,6 +185,10 @@ func (rs *runnerService) OnError(err error) {
 	runner.raiseOnError(stage, service, err)
 }
 
+func (rs *runnerService) Draining() <-chan struct{} {
+	return rs.drain
+}
+
 func (rs *runnerService) ShouldHalt() (v bool) {
 	rs.mu.Lock()
 	v = rs.state == Halting || rs.state == Halted || rs.state == Ended
@@ -182,14 +212,25 @@ func (rs *runnerService) Deadline() (deadline time.Time, ok bool) {
 	return time.Time{}, false
 }
 
-// Err implements context.Context.Err().
+// Err implements context.Context.Err(). It returns a non-nil error once Done()
+// has yielded, as required by the context.Context contract; child contexts
+// created with context.WithCancel() and friends rely on this.
 func (rs *runnerService) Err() (rerr error) {
 	rs.mu.Lock()
-	if rs.state == Ended {
-		rerr = context.Canceled
-	}
+	done, startCtx := rs.done, rs.startCtx
 	rs.mu.Unlock()
-	return rerr
+
+	select {
+	case <-done:
+		if startCtx != nil {
+			if rerr = startCtx.Err(); rerr != nil {
+				return rerr
+			}
+		}
+		return context.Canceled
+	default:
+		return nil
+	}
 }
 
 // Value implements context.Context.Value, which you probably shouldn't use if
@@ -197,10 +238,16 @@ func (rs *runnerService) Err() (rerr error) {
 // https://medium.com/@cep21/how-to-correctly-use-context-context-in-go-1-7-8f2c0fafdf39
 func (rs *runnerService) Value(key interface{}) (out interface{}) {
 	rs.mu.Lock()
-	if rs.startCtx != nil {
+	if key == (clockKey{}) {
+		out = rs.clock
+	} else if rs.startCtx != nil {
 		out = rs.startCtx.Value(key)
 	}
 	rs.mu.Unlock()
+
+	if out == nil && key == (clockKey{}) {
+		out = rs.runner.clock
+	}
 	return out
 }
 
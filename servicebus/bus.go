@@ -0,0 +1,322 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const DefaultBuffer = 16
+
+var ErrBusClosed = errors.New("servicebus: bus closed")
+
+// Overflow determines what happens when a message is published to a
+// subscriber whose buffer is full.
+type Overflow int
+
+const (
+	// DropNewest discards the message being published. This is the default.
+	DropNewest Overflow = iota
+
+	// DropOldest discards the oldest message in the subscriber's buffer to
+	// make room for the message being published.
+	DropOldest
+
+	// Block waits for room in the subscriber's buffer, for up to the timeout
+	// supplied by SubscribeBlockTimeout, then discards the message being
+	// published. Without a timeout, it waits until there is room or the
+	// subscription is removed. Publish blocks while it waits.
+	Block
+)
+
+type Message struct {
+	Topic   string
+	Payload interface{}
+}
+
+// Bus fans messages published to a topic out to every subscriber of that
+// topic.
+//
+// Bus implements service.Runnable so it can be started before the services
+// that depend on it. A Bus can be used without being run; running it just
+// ties its lifetime to a service. When the service is halted, the Bus is
+// closed. Running it again reopens it.
+type Bus struct {
+	topics map[string]map[*Subscription]struct{}
+	closed bool
+	mu     sync.RWMutex
+}
+
+var _ service.Runnable = &Bus{}
+
+func New() *Bus {
+	return &Bus{topics: make(map[string]map[*Subscription]struct{})}
+}
+
+func (b *Bus) Run(ctx service.Context) error {
+	b.mu.Lock()
+	b.closed = false
+	b.mu.Unlock()
+
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	b.Close()
+	return nil
+}
+
+// Close removes every subscription and prevents new ones from being created.
+// Messages published to a closed Bus are discarded.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	var subs []*Subscription
+	for _, topic := range b.topics {
+		for sub := range topic {
+			subs = append(subs, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+	return nil
+}
+
+// Subscribers returns the number of subscribers to topic.
+func (b *Bus) Subscribers(topic string) int {
+	b.mu.RLock()
+	n := len(b.topics[topic])
+	b.mu.RUnlock()
+	return n
+}
+
+// Subscribe creates a subscription to one or more topics. The subscription is
+// removed when ctx is done, or when Unsubscribe is called.
+//
+// The Block overflow policy times out using the Clock carried by ctx; see
+// service.ClockFromContext().
+func (b *Bus) Subscribe(ctx context.Context, topics []string, options ...SubscribeOption) (*Subscription, error) {
+	if len(topics) == 0 {
+		panic("no topics")
+	}
+
+	sub := &Subscription{
+		bus:    b,
+		topics: topics,
+		buffer: DefaultBuffer,
+		clock:  service.ClockFromContext(ctx),
+		done:   make(chan struct{}),
+	}
+	for _, o := range options {
+		o(sub)
+	}
+	sub.ch = make(chan Message, sub.buffer)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrBusClosed
+	}
+	for _, topic := range topics {
+		subs := b.topics[topic]
+		if subs == nil {
+			subs = make(map[*Subscription]struct{})
+			b.topics[topic] = subs
+		}
+		subs[sub] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Unsubscribe()
+		case <-sub.done:
+		}
+	}()
+
+	return sub, nil
+}
+
+// Publish sends a message to every subscriber of topic, applying each
+// subscriber's Overflow policy if its buffer is full. It returns the number
+// of subscribers the message was delivered to.
+func (b *Bus) Publish(topic string, payload interface{}) (delivered int) {
+	msg := Message{Topic: topic, Payload: payload}
+
+	// A Block subscriber can hold us up for its whole timeout, so don't hold
+	// the lock while we send, or Subscribe() and Unsubscribe() would wait for
+	// it too, and every other Publish() would wait for them:
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.topics[topic]))
+	for sub := range b.topics[topic] {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		if sub.send(msg) {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	b.mu.Lock()
+	for _, topic := range sub.topics {
+		if subs := b.topics[topic]; subs != nil {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(b.topics, topic)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	sub.closeMu.Lock()
+	sub.closed = true
+	close(sub.ch)
+	sub.closeMu.Unlock()
+}
+
+type SubscribeOption func(s *Subscription)
+
+// SubscribeBuffer sets the number of messages that can be buffered for the
+// subscriber. The default is DefaultBuffer.
+func SubscribeBuffer(n int) SubscribeOption {
+	if n < 0 {
+		panic("buffer must be >= 0")
+	}
+	return func(s *Subscription) { s.buffer = n }
+}
+
+// SubscribeOverflow sets what happens when the subscriber's buffer is full.
+// The default is DropNewest.
+func SubscribeOverflow(overflow Overflow) SubscribeOption {
+	return func(s *Subscription) { s.overflow = overflow }
+}
+
+// SubscribeBlockTimeout uses the Block overflow policy, waiting up to timeout
+// for room in the buffer. If timeout is <= 0, it waits until there is room or
+// the subscription is removed, the same as SubscribeOverflow(Block).
+func SubscribeBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(s *Subscription) { s.overflow, s.timeout = Block, timeout }
+}
+
+type Subscription struct {
+	bus      *Bus
+	topics   []string
+	buffer   int
+	overflow Overflow
+	timeout  time.Duration
+	clock    service.Clock
+
+	ch      chan Message
+	dropped uint64
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+
+	// closeMu is held for reading while sending to ch, and for writing while
+	// closing it:
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+// C returns the channel messages are delivered to. It is closed when the
+// subscription is removed.
+func (s *Subscription) C() <-chan Message { return s.ch }
+
+// Done is closed when the subscription is removed.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Dropped returns the number of messages discarded by the Overflow policy.
+func (s *Subscription) Dropped() uint64 { return atomic.LoadUint64(&s.dropped) }
+
+// Unsubscribe removes the subscription and closes C(). It is safe to call
+// more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		// Closing done first releases any publishers blocked on us, so
+		// they don't hold up closing the channel:
+		close(s.done)
+		s.bus.remove(s)
+	})
+}
+
+// send delivers msg according to the Overflow policy. It may be called after
+// the subscription has been removed, in which case msg is discarded.
+func (s *Subscription) send(msg Message) bool {
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+	if s.closed {
+		return false
+	}
+
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	switch {
+	case s.overflow == DropOldest && cap(s.ch) > 0:
+		// Concurrent publishers could otherwise steal the space we make:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for {
+			select {
+			case s.ch <- msg:
+				return true
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+
+	case s.overflow == Block:
+		select {
+		case s.ch <- msg:
+			return true
+		default:
+		}
+
+		// A nil channel never fires, so without a timeout we wait for the
+		// subscriber:
+		var expired <-chan time.Time
+		if s.timeout > 0 {
+			timer := s.clock.NewTimer(s.timeout)
+			defer timer.Stop()
+			expired = timer.C()
+		}
+		select {
+		case s.ch <- msg:
+			return true
+		case <-s.done:
+			return false
+		case <-expired:
+			atomic.AddUint64(&s.dropped, 1)
+			return false
+		}
+
+	default:
+		select {
+		case s.ch <- msg:
+			return true
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			return false
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package servicebus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+	"github.com/shabbyrobe/go-service/servicetest"
+)
+
+func drain(sub *Subscription) (out []interface{}) {
+	for {
+		select {
+		case msg := <-sub.C():
+			out = append(out, msg.Payload)
+		default:
+			return out
+		}
+	}
+}
+
+func TestBusFanout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := New()
+	a, err := bus.Subscribe(ctx, []string{"foo"})
+	tt.MustOK(err)
+	b, err := bus.Subscribe(ctx, []string{"foo", "bar"})
+	tt.MustOK(err)
+
+	tt.MustEqual(2, bus.Publish("foo", 1))
+	tt.MustEqual(1, bus.Publish("bar", 2))
+	tt.MustEqual(0, bus.Publish("baz", 3))
+
+	tt.MustEqual([]interface{}{1}, drain(a))
+	tt.MustEqual([]interface{}{1, 2}, drain(b))
+
+	msg := Message{Topic: "bar", Payload: 4}
+	bus.Publish("bar", 4)
+	tt.MustEqual(msg, <-b.C())
+}
+
+func TestBusSubscriptionRemovedWhenContextDone(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	bus := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := bus.Subscribe(ctx, []string{"foo"}, SubscribeBlockTimeout(dto))
+	tt.MustOK(err)
+	tt.MustEqual(1, bus.Subscribers("foo"))
+
+	cancel()
+	<-sub.Done()
+	_, ok := <-sub.C()
+	tt.MustAssert(!ok, "channel not closed")
+	tt.MustEqual(0, bus.Subscribers("foo"))
+
+	// Publishing to a removed subscriber must not block:
+	tt.MustEqual(0, bus.Publish("foo", 1))
+}
+
+func TestBusSubscriptionTiedToService(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	bus := New()
+	subscribed := make(chan *Subscription, 1)
+	subscriber := service.RunnableFunc(func(ctx service.Context) error {
+		sub, err := bus.Subscribe(ctx, []string{"foo"}, SubscribeBuffer(0), SubscribeBlockTimeout(1*time.Hour))
+		if err != nil {
+			return err
+		}
+		subscribed <- sub
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(dto, runner)
+	tt.MustOK(service.StartTimeout(dto, runner, service.New("bus", bus)))
+
+	svc := service.New("sub", subscriber)
+	tt.MustOK(service.StartTimeout(dto, runner, svc))
+	sub := <-subscribed
+
+	// Nobody is receiving, so this blocks until the subscriber halts:
+	published := make(chan int)
+	go func() { published <- bus.Publish("foo", 1) }()
+
+	tt.MustOK(service.HaltTimeout(dto, runner, svc))
+	select {
+	case n := <-published:
+		tt.MustEqual(0, n)
+	case <-time.After(dto):
+		tt.Fatal("publisher still blocked on halted subscriber")
+	}
+	<-sub.Done()
+}
+
+func TestBusOverflowDropNewest(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := New()
+	sub, err := bus.Subscribe(ctx, []string{"foo"}, SubscribeBuffer(2))
+	tt.MustOK(err)
+	for i := 1; i <= 4; i++ {
+		bus.Publish("foo", i)
+	}
+	tt.MustEqual([]interface{}{1, 2}, drain(sub))
+	tt.MustEqual(uint64(2), sub.Dropped())
+}
+
+func TestBusOverflowDropOldest(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := New()
+	sub, err := bus.Subscribe(ctx, []string{"foo"}, SubscribeBuffer(2), SubscribeOverflow(DropOldest))
+	tt.MustOK(err)
+	for i := 1; i <= 4; i++ {
+		tt.MustEqual(1, bus.Publish("foo", i))
+	}
+	tt.MustEqual([]interface{}{3, 4}, drain(sub))
+	tt.MustEqual(uint64(2), sub.Dropped())
+}
+
+func TestBusOverflowBlock(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := servicetest.NewFakeClock(time.Time{})
+	bus := New()
+	sub, err := bus.Subscribe(service.ContextWithClock(ctx, clock), []string{"foo"}, SubscribeBuffer(1), SubscribeBlockTimeout(time.Minute))
+	tt.MustOK(err)
+
+	tt.MustEqual(1, bus.Publish("foo", 1))
+
+	// Times out:
+	published := make(chan int)
+	go func() { published <- bus.Publish("foo", 2) }()
+	tt.MustOK(clock.BlockUntilSleepers(ctx, 1))
+	clock.Advance(time.Minute - 1)
+	select {
+	case <-published:
+		tt.Fatal("publish timed out early")
+	default:
+	}
+	clock.Advance(1)
+	tt.MustEqual(0, <-published)
+	tt.MustEqual(uint64(1), sub.Dropped())
+
+	// Unblocked by a receiver:
+	go func() {
+		time.Sleep(tscale)
+		<-sub.C()
+	}()
+	tt.MustEqual(1, bus.Publish("foo", 3))
+	tt.MustEqual([]interface{}{3}, drain(sub))
+}
+
+func TestBusOverflowBlockWithoutTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bus := New()
+	sub, err := bus.Subscribe(ctx, []string{"foo"}, SubscribeBuffer(1), SubscribeOverflow(Block))
+	tt.MustOK(err)
+	tt.MustEqual(1, bus.Publish("foo", 1))
+
+	// Waits for a receiver, however long it takes:
+	published := make(chan int)
+	go func() { published <- bus.Publish("foo", 2) }()
+	select {
+	case <-published:
+		tt.Fatal("publish did not block")
+	case <-time.After(10 * tscale):
+	}
+	<-sub.C()
+	tt.MustEqual(1, <-published)
+	tt.MustEqual(uint64(0), sub.Dropped())
+
+	// Or for the subscription to be removed:
+	go func() { published <- bus.Publish("foo", 3) }()
+	select {
+	case <-published:
+		tt.Fatal("publish did not block")
+	case <-time.After(tscale):
+	}
+	sub.Unsubscribe()
+	tt.MustEqual(0, <-published)
+}
+
+func TestBusRunClosesOnHalt(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	bus := New()
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(dto, runner)
+	svc := service.New("bus", bus)
+	tt.MustOK(service.StartTimeout(dto, runner, svc))
+
+	sub, err := bus.Subscribe(context.Background(), []string{"foo"})
+	tt.MustOK(err)
+
+	tt.MustOK(service.HaltTimeout(dto, runner, svc))
+	<-sub.Done()
+	_, err = bus.Subscribe(context.Background(), []string{"foo"})
+	tt.MustEqual(ErrBusClosed, err)
+
+	// Restarting reopens it:
+	tt.MustOK(service.StartTimeout(dto, runner, svc))
+	sub, err = bus.Subscribe(context.Background(), []string{"foo"})
+	tt.MustOK(err)
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+}
+
+func TestBusBlockDoesNotStallBus(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	bus := New()
+	stuck, err := bus.Subscribe(context.Background(), []string{"slow"}, SubscribeBuffer(0), SubscribeBlockTimeout(dto))
+	tt.MustOK(err)
+
+	blocked := make(chan int)
+	go func() { blocked <- bus.Publish("slow", 1) }()
+	time.Sleep(tscale) // Give the publisher time to block
+
+	// Neither a subscriber nor a publisher to another topic should have to
+	// wait for the stuck subscriber, even if they arrive at the same time:
+	subbed := make(chan *Subscription)
+	go func() {
+		sub, err := bus.Subscribe(context.Background(), []string{"fast"})
+		tt.MustOK(err)
+		subbed <- sub
+	}()
+	published := make(chan int)
+	go func() { published <- bus.Publish("fast", 2) }()
+
+	var fast *Subscription
+	timeout := time.After(10 * tscale)
+	for i := 0; i < 2; i++ {
+		select {
+		case fast = <-subbed:
+		case <-published:
+		case <-timeout:
+			tt.Fatal("bus stalled by blocked subscriber")
+		}
+	}
+	drain(fast) // May or may not have received 2, depending on who won
+	tt.MustEqual(1, bus.Publish("fast", 3))
+	tt.MustEqual([]interface{}{3}, drain(fast))
+
+	// Unsubscribing releases the blocked publisher:
+	stuck.Unsubscribe()
+	select {
+	case n := <-blocked:
+		tt.MustEqual(0, n)
+	case <-time.After(10 * tscale):
+		tt.Fatal("publisher not released by Unsubscribe")
+	}
+	_, open := <-stuck.C()
+	tt.MustAssert(!open)
+	fast.Unsubscribe()
+}
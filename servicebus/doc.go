@@ -0,0 +1,26 @@
+/*
+Package servicebus provides an in-process publish/subscribe message bus for
+communicating between services.
+
+Subscriptions are tied to a context, usually the service.Context passed to a
+service's Run method, and are removed automatically when it is done. A
+publisher never blocks on a subscriber that has halted.
+
+	bus := servicebus.New()
+	runner.Start(ctx, service.New("bus", bus))
+
+	// In a subscriber's Run(ctx service.Context):
+	sub, err := bus.Subscribe(ctx, []string{"config"},
+		servicebus.SubscribeBuffer(16),
+		servicebus.SubscribeOverflow(servicebus.DropOldest))
+	if err != nil {
+		return err
+	}
+	for msg := range sub.C() {
+		...
+	}
+
+	// In a publisher:
+	bus.Publish("config", newConfig)
+*/
+package servicebus
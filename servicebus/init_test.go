@@ -0,0 +1,21 @@
+package servicebus
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/shabbyrobe/go-service/servicetest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(servicetest.VerifyNoLeaksMain(m))
+}
+
+const (
+	// HACK FEST: This needs to be high enough so that tests that rely on
+	// timing don't fail because your computer was too slow
+	tscale = 5 * time.Millisecond
+
+	dto = 100 * tscale
+)
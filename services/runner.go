@@ -16,6 +16,10 @@ func Halt(ctx context.Context, services ...*Service) error {
 	return Runner().Halt(ctx, services...)
 }
 
+func Drain(ctx context.Context, services ...*Service) error {
+	return Runner().Drain(ctx, services...)
+}
+
 func Shutdown(ctx context.Context) (err error) {
 	return Runner().Shutdown(ctx)
 }
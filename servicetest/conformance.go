@@ -0,0 +1,890 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	// DefaultConformanceSequences is the number of randomised sequences of
+	// operations Conformance() issues against new Runners.
+	DefaultConformanceSequences = 20
+
+	// DefaultConformanceSteps is the number of operations in each randomised
+	// sequence.
+	DefaultConformanceSteps = 100
+
+	// DefaultConformanceTimeout is how long Conformance() waits for any one
+	// operation before it fails the test.
+	DefaultConformanceTimeout = 5 * time.Second
+
+	conformanceServices     = 4
+	conformanceStartTimeout = 10 * time.Millisecond
+	conformancePoll         = time.Millisecond
+)
+
+var (
+	errConformanceReady = errors.New("conformance: service failed before it was ready")
+	errConformanceRun   = errors.New("conformance: service failed while running")
+)
+
+type ConformanceOption func(cf *conformance)
+
+// ConformanceSeed seeds the randomised sequences. Sequence i uses seed+i.
+// By default, the seed is derived from the current time.
+func ConformanceSeed(seed int64) ConformanceOption {
+	return func(cf *conformance) { cf.seed = seed }
+}
+
+// ConformanceSequences sets the number of randomised sequences. Pass 0 to
+// only run the table-driven tests.
+func ConformanceSequences(n int) ConformanceOption {
+	return func(cf *conformance) { cf.sequences = n }
+}
+
+// ConformanceSteps sets the number of operations in each randomised
+// sequence.
+func ConformanceSteps(n int) ConformanceOption {
+	return func(cf *conformance) { cf.steps = n }
+}
+
+// ConformanceTimeout sets how long to wait for any one operation before
+// failing the test.
+func ConformanceTimeout(d time.Duration) ConformanceOption {
+	return func(cf *conformance) { cf.timeout = d }
+}
+
+type conformance struct {
+	seed      int64
+	sequences int
+	steps     int
+	timeout   time.Duration
+}
+
+// Conformance checks that the service.Runner returned by newRunner behaves
+// the same way as the Runner returned by service.NewRunner(). Every test gets
+// a new Runner from newRunner, and shuts it down when it is done.
+//
+// The table-driven tests cover ready errors, halting services from every
+// State, Shutdown(), Suspend(), Enable() and Services() queries. The
+// randomised tests issue sequences of operations against both the Runner and
+// a reference model of one, and compare the two after every step. The seed
+// of each sequence is part of its test name, so a failure can be repeated by
+// passing it to ConformanceSeed along with ConformanceSequences(1).
+//
+// The service package's errors are not required; a Runner may return its own
+// error when a service is already running or the Runner is not enabled.
+//
+//	func TestMyRunner(t *testing.T) {
+//		servicetest.Conformance(t, func() service.Runner {
+//			return NewMyRunner()
+//		})
+//	}
+func Conformance(t *testing.T, newRunner func() service.Runner, options ...ConformanceOption) {
+	cf := &conformance{
+		seed:      time.Now().UnixNano(),
+		sequences: DefaultConformanceSequences,
+		steps:     DefaultConformanceSteps,
+		timeout:   DefaultConformanceTimeout,
+	}
+	for _, o := range options {
+		o(cf)
+	}
+
+	for _, tc := range conformanceCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ct := newConformanceTest(t, newRunner(), cf.timeout)
+			defer ct.cleanup()
+			tc.fn(ct)
+		})
+	}
+
+	for i := 0; i < cf.sequences; i++ {
+		seed := cf.seed + int64(i)
+		t.Run(fmt.Sprintf("random/seed=%d", seed), func(t *testing.T) {
+			ct := newConformanceTest(t, newRunner(), cf.timeout)
+			defer ct.cleanup()
+			ct.random(rand.New(rand.NewSource(seed)), cf.steps)
+		})
+	}
+}
+
+// conformanceService is a Runnable that moves through Run() when the test
+// tells it to. The per-run fields must only be changed while the service is
+// not running.
+type conformanceService struct {
+	svc *service.Service
+
+	readyErr error         // Returned by Run() instead of calling Ready()
+	hold     chan struct{} // If not nil, Run() waits for this before it is ready
+	holdOnly bool          // If true, a held Run() ignores ctx.Done()
+	haltHold chan struct{} // If not nil, Run() waits for this after it is halted
+
+	entered chan struct{}
+	fail    chan error
+	ends    chan error
+}
+
+func newConformanceService(name string) *conformanceService {
+	cs := &conformanceService{
+		entered: make(chan struct{}, 1),
+		fail:    make(chan error, 1),
+		ends:    make(chan error, 8),
+	}
+	cs.svc = service.New(service.Name(name), cs)
+	cs.svc.OnEnd = func(stage service.Stage, svc *service.Service, err error) {
+		select {
+		case cs.ends <- err:
+		default:
+		}
+	}
+	return cs
+}
+
+func (cs *conformanceService) reset() {
+	cs.readyErr, cs.hold, cs.holdOnly, cs.haltHold = nil, nil, false, nil
+	select {
+	case <-cs.entered:
+	default:
+	}
+}
+
+func (cs *conformanceService) Run(ctx service.Context) error {
+	select {
+	case cs.entered <- struct{}{}:
+	default:
+	}
+
+	if cs.hold != nil {
+		if cs.holdOnly {
+			<-cs.hold
+		} else {
+			select {
+			case <-cs.hold:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	if cs.readyErr != nil {
+		return cs.readyErr
+	}
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-cs.fail:
+		return err
+	case <-ctx.Done():
+	}
+	if cs.haltHold != nil {
+		<-cs.haltHold
+	}
+	return nil
+}
+
+type conformanceTest struct {
+	*testing.T
+	runner  service.Runner
+	timeout time.Duration
+
+	gates   map[chan struct{}]bool
+	history []string
+}
+
+func newConformanceTest(t *testing.T, runner service.Runner, timeout time.Duration) *conformanceTest {
+	return &conformanceTest{
+		T:       t,
+		runner:  runner,
+		timeout: timeout,
+		gates:   make(map[chan struct{}]bool),
+	}
+}
+
+func (ct *conformanceTest) cleanup() {
+	for gate := range ct.gates {
+		ct.open(gate)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ct.timeout)
+	defer cancel()
+	if err := ct.runner.Shutdown(ctx); err != nil {
+		ct.Errorf("runner did not shut down after the test: %v", err)
+	}
+}
+
+func (ct *conformanceTest) fatalf(format string, args ...interface{}) {
+	ct.Helper()
+	msg := fmt.Sprintf(format, args...)
+	if len(ct.history) > 0 {
+		msg += "\nsteps:\n\t" + strings.Join(ct.history, "\n\t")
+	}
+	ct.Fatal(msg)
+}
+
+func (ct *conformanceTest) gate() chan struct{} {
+	gate := make(chan struct{})
+	ct.gates[gate] = false
+	return gate
+}
+
+func (ct *conformanceTest) open(gate chan struct{}) {
+	if !ct.gates[gate] {
+		ct.gates[gate] = true
+		close(gate)
+	}
+}
+
+func (ct *conformanceTest) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), ct.timeout)
+}
+
+func (ct *conformanceTest) start(css ...*conformanceService) error {
+	ctx, cancel := ct.context()
+	defer cancel()
+	return ct.runner.Start(ctx, conformanceSvcs(css)...)
+}
+
+func (ct *conformanceTest) halt(css ...*conformanceService) error {
+	ctx, cancel := ct.context()
+	defer cancel()
+	return ct.runner.Halt(ctx, conformanceSvcs(css)...)
+}
+
+func (ct *conformanceTest) drain(css ...*conformanceService) error {
+	ctx, cancel := ct.context()
+	defer cancel()
+	return ct.runner.Drain(ctx, conformanceSvcs(css)...)
+}
+
+func (ct *conformanceTest) shutdown() error {
+	ctx, cancel := ct.context()
+	defer cancel()
+	return ct.runner.Shutdown(ctx)
+}
+
+// startHeld starts cs, which must be held, in the background and waits for
+// its Run() to be entered. The result of Start() is sent to the returned
+// channel.
+func (ct *conformanceTest) startHeld(cs *conformanceService) <-chan error {
+	ct.Helper()
+	result := make(chan error, 1)
+	go func() { result <- ct.start(cs) }()
+
+	select {
+	case <-cs.entered:
+	case err := <-result:
+		ct.fatalf("Start(%s) returned %v before the service was ready", cs.svc.Name, err)
+	case <-time.After(ct.timeout):
+		ct.fatalf("Start(%s) did not call Run()", cs.svc.Name)
+	}
+	return result
+}
+
+// inBackground calls fn in a goroutine and sends its result to the returned
+// channel.
+func (ct *conformanceTest) inBackground(fn func() error) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- fn() }()
+	return result
+}
+
+func (ct *conformanceTest) await(result <-chan error, what string) error {
+	ct.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(ct.timeout):
+		ct.fatalf("%s did not return", what)
+		return nil
+	}
+}
+
+func (ct *conformanceTest) mustOK(err error, what string) {
+	ct.Helper()
+	if err != nil {
+		ct.fatalf("%s failed: %v", what, err)
+	}
+}
+
+func (ct *conformanceTest) mustFail(err error, what string) {
+	ct.Helper()
+	if err == nil {
+		ct.fatalf("%s succeeded, expected an error", what)
+	}
+}
+
+func (ct *conformanceTest) mustCause(want error, err error, what string) {
+	ct.Helper()
+	errs := service.Errors(err)
+	if len(errs) != 1 || cause(errs[0]) != want {
+		ct.fatalf("%s returned %v, expected %v", what, err, want)
+	}
+}
+
+// expectEnd waits for OnEnd to be called for cs, and checks the error it was
+// called with.
+func (ct *conformanceTest) expectEnd(cs *conformanceService, want error) {
+	ct.Helper()
+	select {
+	case err := <-cs.ends:
+		if cause(err) != want {
+			ct.fatalf("OnEnd for %s called with %v, expected %v", cs.svc.Name, err, want)
+		}
+	case <-time.After(ct.timeout):
+		ct.fatalf("OnEnd not called for %s", cs.svc.Name)
+	}
+}
+
+func (ct *conformanceTest) expectNoEnd(css ...*conformanceService) {
+	ct.Helper()
+	for _, cs := range css {
+		select {
+		case err := <-cs.ends:
+			ct.fatalf("unexpected OnEnd for %s: %v", cs.svc.Name, err)
+		default:
+		}
+	}
+}
+
+func (ct *conformanceTest) expectState(cs *conformanceService, want service.State) {
+	ct.Helper()
+	if st := ct.runner.State(cs.svc); st != want {
+		ct.fatalf("%s is %s, expected %s", cs.svc.Name, st, want)
+	}
+}
+
+// waitState polls until cs is in the wanted State.
+func (ct *conformanceTest) waitState(cs *conformanceService, want service.State) {
+	ct.Helper()
+	deadline := time.Now().Add(ct.timeout)
+	for ct.runner.State(cs.svc) != want {
+		if time.Now().After(deadline) {
+			ct.expectState(cs, want)
+		}
+		time.Sleep(conformancePoll)
+	}
+}
+
+func (ct *conformanceTest) expectRunnerState(want service.RunnerState) {
+	ct.Helper()
+	if st := ct.runner.RunnerState(); st != want {
+		ct.fatalf("runner state is %d, expected %d", st, want)
+	}
+}
+
+// expectServices checks that Services(query) returns exactly the services in
+// want, in the States in want.
+func (ct *conformanceTest) expectServices(query service.State, want map[*conformanceService]service.State) {
+	ct.Helper()
+
+	got := ct.runner.Services(query, 0, nil)
+	seen := make(map[*service.Service]bool, len(got))
+	for _, info := range got {
+		if seen[info.Service] {
+			ct.fatalf("Services(%s) returned %s more than once", query, info.Service.Name)
+		}
+		seen[info.Service] = true
+	}
+
+	for cs, st := range want {
+		if !st.Match(query) {
+			continue
+		}
+		found := false
+		for _, info := range got {
+			if info.Service == cs.svc {
+				found = true
+				if info.State != st {
+					ct.fatalf("Services(%s) returned %s as %s, expected %s", query, cs.svc.Name, info.State, st)
+				}
+			}
+		}
+		if !found {
+			ct.fatalf("Services(%s) did not return %s", query, cs.svc.Name)
+		}
+		delete(seen, cs.svc)
+	}
+	for svc := range seen {
+		ct.fatalf("Services(%s) unexpectedly returned %s", query, svc.Name)
+	}
+}
+
+func conformanceSvcs(css []*conformanceService) []*service.Service {
+	out := make([]*service.Service, len(css))
+	for i, cs := range css {
+		out[i] = cs.svc
+	}
+	return out
+}
+
+var conformanceCases = []struct {
+	name string
+	fn   func(ct *conformanceTest)
+}{
+	{"start-halt", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		ct.expectRunnerState(service.RunnerEnabled)
+		ct.expectState(a, service.Halted)
+
+		// A service can be started again once it has been halted:
+		for i := 0; i < 3; i++ {
+			ct.mustOK(ct.start(a), "Start()")
+			ct.expectState(a, service.Started)
+			ct.expectNoEnd(a)
+			ct.mustOK(ct.halt(a), "Halt()")
+			ct.expectEnd(a, nil)
+			ct.expectState(a, service.Halted)
+		}
+		ct.expectServices(service.AnyState, nil)
+	}},
+
+	{"no-services", func(ct *conformanceTest) {
+		ct.mustOK(ct.start(), "Start() with no services")
+		ct.mustOK(ct.halt(), "Halt() with no services")
+		ct.mustOK(ct.drain(), "Drain() with no services")
+	}},
+
+	{"nil-context", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		ct.mustOK(ct.runner.Start(nil, a.svc), "Start(nil)")
+		ct.expectState(a, service.Started)
+		ct.mustOK(ct.runner.Halt(nil, a.svc), "Halt(nil)")
+		ct.expectEnd(a, nil)
+	}},
+
+	{"already-running", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		ct.mustOK(ct.start(a), "Start()")
+		ct.mustFail(ct.start(a), "Start() of a running service")
+		ct.expectState(a, service.Started)
+		ct.expectNoEnd(a)
+		ct.mustOK(ct.halt(a), "Halt()")
+		ct.expectEnd(a, nil)
+	}},
+
+	{"ready-error", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		a.readyErr = errConformanceReady
+		ct.mustCause(errConformanceReady, ct.start(a), "Start()")
+		ct.expectEnd(a, errConformanceReady)
+		ct.waitState(a, service.Halted)
+
+		// The ready error belongs to Start(), not Halt():
+		ct.mustOK(ct.halt(a), "Halt() after a ready error")
+		ct.expectNoEnd(a)
+
+		a.reset()
+		ct.mustOK(ct.start(a), "Start() after a ready error")
+		ct.expectState(a, service.Started)
+	}},
+
+	{"ready-error-partial", func(ct *conformanceTest) {
+		a, b := newConformanceService("a"), newConformanceService("b")
+		b.readyErr = errConformanceReady
+		ct.mustCause(errConformanceReady, ct.start(a, b), "Start()")
+		ct.expectEnd(b, errConformanceReady)
+		ct.waitState(b, service.Halted)
+		ct.expectState(a, service.Started)
+		ct.expectNoEnd(a)
+		ct.expectServices(service.AnyState, map[*conformanceService]service.State{a: service.Started})
+	}},
+
+	{"ready-error-after-halt", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		a.readyErr, a.hold, a.holdOnly = errConformanceReady, ct.gate(), true
+
+		ctx, cancel := context.WithTimeout(context.Background(), conformanceStartTimeout)
+		defer cancel()
+		ct.mustFail(ct.runner.Start(ctx, a.svc), "Start() of a service that is never ready")
+
+		// Halt() must wait for the service to end, but the service's ready
+		// error is not Halt()'s to return:
+		halted := ct.inBackground(func() error { return ct.halt(a) })
+		ct.open(a.hold)
+		ct.mustOK(ct.await(halted, "Halt()"), "Halt() of a service that failed before it was ready")
+		ct.expectEnd(a, errConformanceReady)
+		ct.waitState(a, service.Halted)
+	}},
+
+	{"start-timeout", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		a.hold = ct.gate()
+
+		ctx, cancel := context.WithTimeout(context.Background(), conformanceStartTimeout)
+		defer cancel()
+		ct.mustFail(ct.runner.Start(ctx, a.svc), "Start() of a service that is never ready")
+
+		ct.mustOK(ct.halt(a), "Halt() after Start() timed out")
+		ct.expectEnd(a, nil)
+		ct.expectState(a, service.Halted)
+	}},
+
+	{"halt-halted", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		ct.mustOK(ct.halt(a), "Halt() of a service that was never started")
+		ct.expectState(a, service.Halted)
+		ct.expectNoEnd(a)
+
+		ct.mustOK(ct.start(a), "Start()")
+		ct.mustOK(ct.halt(a), "Halt()")
+		ct.expectEnd(a, nil)
+		ct.mustOK(ct.halt(a), "Halt() of a halted service")
+		ct.expectNoEnd(a)
+	}},
+
+	{"halt-starting", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		a.hold = ct.gate()
+		started := ct.startHeld(a)
+		ct.expectState(a, service.Starting)
+
+		ct.mustOK(ct.halt(a), "Halt() of a Starting service")
+		ct.await(started, "Start()")
+		ct.expectEnd(a, nil)
+		ct.expectState(a, service.Halted)
+	}},
+
+	{"halt-started", func(ct *conformanceTest) {
+		a, b := newConformanceService("a"), newConformanceService("b")
+		ct.mustOK(ct.start(a, b), "Start()")
+		ct.mustOK(ct.halt(a, b), "Halt()")
+		ct.expectEnd(a, nil)
+		ct.expectEnd(b, nil)
+		ct.expectState(a, service.Halted)
+		ct.expectState(b, service.Halted)
+	}},
+
+	{"halt-halting", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		a.haltHold = ct.gate()
+		ct.mustOK(ct.start(a), "Start()")
+
+		first := ct.inBackground(func() error { return ct.halt(a) })
+		ct.waitState(a, service.Halting)
+		ct.expectServices(service.Halting, map[*conformanceService]service.State{a: service.Halting})
+
+		// Both calls to Halt() must wait for the service to end:
+		second := ct.inBackground(func() error { return ct.halt(a) })
+		select {
+		case err := <-second:
+			ct.fatalf("Halt() of a Halting service returned %v before it ended", err)
+		case <-time.After(conformanceStartTimeout):
+		}
+
+		ct.open(a.haltHold)
+		ct.mustOK(ct.await(first, "Halt()"), "Halt()")
+		ct.mustOK(ct.await(second, "Halt()"), "Halt() of a Halting service")
+		ct.expectEnd(a, nil)
+		ct.expectNoEnd(a)
+		ct.expectState(a, service.Halted)
+	}},
+
+	{"halt-ended", func(ct *conformanceTest) {
+		a := newConformanceService("a")
+		ct.mustOK(ct.start(a), "Start()")
+		a.fail <- errConformanceRun
+		ct.expectEnd(a, errConformanceRun)
+		ct.waitState(a, service.Halted)
+
+		ct.mustOK(ct.halt(a), "Halt() of an Ended service")
+		ct.expectNoEnd(a)
+	}},
+
+	{"drain", func(ct *conformanceTest) {
+		a, b := newConformanceService("a"), newConformanceService("b")
+		ct.mustOK(ct.start(a), "Start()")
+		ct.mustOK(ct.drain(a, b), "Drain()")
+		ct.expectEnd(a, nil)
+		ct.expectNoEnd(b)
+		ct.expectState(a, service.Halted)
+	}},
+
+	{"shutdown", func(ct *conformanceTest) {
+		a, b, c := newConformanceService("a"), newConformanceService("b"), newConformanceService("c")
+		ct.mustOK(ct.start(a), "Start()")
+		b.hold = ct.gate()
+		started := ct.startHeld(b)
+
+		ct.mustOK(ct.shutdown(), "Shutdown()")
+		ct.expectRunnerState(service.RunnerShutdown)
+		ct.await(started, "Start()")
+		ct.expectEnd(a, nil)
+		ct.expectEnd(b, nil)
+		ct.expectServices(service.AnyState, nil)
+
+		ct.mustFail(ct.start(c), "Start() after Shutdown()")
+		ct.expectState(c, service.Halted)
+		ct.expectNoEnd(c)
+
+		// Shutdown() is safe to call more than once:
+		ct.mustOK(ct.shutdown(), "second Shutdown()")
+		ct.expectRunnerState(service.RunnerShutdown)
+
+		ct.mustOK(ct.runner.Enable(), "Enable() after Shutdown()")
+		ct.expectRunnerState(service.RunnerEnabled)
+		ct.mustOK(ct.start(c), "Start() after Enable()")
+		ct.expectState(c, service.Started)
+	}},
+
+	{"shutdown-empty", func(ct *conformanceTest) {
+		ct.mustOK(ct.shutdown(), "Shutdown()")
+		ct.mustOK(ct.shutdown(), "second Shutdown()")
+		ct.expectRunnerState(service.RunnerShutdown)
+		ct.mustFail(ct.runner.Suspend(), "Suspend() after Shutdown()")
+		ct.expectRunnerState(service.RunnerShutdown)
+	}},
+
+	{"suspend", func(ct *conformanceTest) {
+		a, b := newConformanceService("a"), newConformanceService("b")
+		ct.mustOK(ct.start(a), "Start()")
+
+		ct.mustOK(ct.runner.Suspend(), "Suspend()")
+		ct.expectRunnerState(service.RunnerSuspended)
+		ct.mustFail(ct.runner.Suspend(), "second Suspend()")
+		ct.expectRunnerState(service.RunnerSuspended)
+
+		// Existing services are left alone:
+		ct.expectState(a, service.Started)
+		ct.expectNoEnd(a)
+		ct.mustFail(ct.start(b), "Start() while suspended")
+		ct.expectState(b, service.Halted)
+
+		ct.mustOK(ct.runner.Enable(), "Enable()")
+		ct.expectRunnerState(service.RunnerEnabled)
+		ct.mustOK(ct.start(b), "Start() after Enable()")
+
+		ct.mustOK(ct.runner.Suspend(), "Suspend()")
+		ct.mustOK(ct.halt(a), "Halt() while suspended")
+		ct.expectEnd(a, nil)
+		ct.mustOK(ct.shutdown(), "Shutdown() while suspended")
+		ct.expectRunnerState(service.RunnerShutdown)
+		ct.expectEnd(b, nil)
+	}},
+
+	{"services", func(ct *conformanceTest) {
+		a, b, c := newConformanceService("a"), newConformanceService("b"), newConformanceService("c")
+		ct.expectServices(service.AnyState, nil)
+
+		ct.mustOK(ct.start(a, b), "Start()")
+		c.hold = ct.gate()
+		started := ct.startHeld(c)
+
+		want := map[*conformanceService]service.State{
+			a: service.Started,
+			b: service.Started,
+			c: service.Starting,
+		}
+		for _, query := range []service.State{service.AnyState, service.Starting, service.Started, service.Halting, service.Halted} {
+			ct.expectServices(query, want)
+		}
+
+		if n := len(ct.runner.Services(service.AnyState, 2, nil)); n != 2 {
+			ct.fatalf("Services() with a limit of 2 returned %d services", n)
+		}
+		if n := len(ct.runner.Services(service.Started, 1, nil)); n != 1 {
+			ct.fatalf("Services(started) with a limit of 1 returned %d services", n)
+		}
+
+		// Services() may allocate into an existing slice:
+		into := make([]service.ServiceInfo, 5, 10)
+		if n := len(ct.runner.Services(service.AnyState, 0, into)); n != 3 {
+			ct.fatalf("Services() into an existing slice returned %d services", n)
+		}
+
+		ct.open(c.hold)
+		ct.mustOK(ct.await(started, "Start()"), "Start()")
+		want[c] = service.Started
+		ct.expectServices(service.Started, want)
+
+		ct.mustOK(ct.halt(b), "Halt()")
+		ct.expectEnd(b, nil)
+		delete(want, b)
+		ct.expectServices(service.AnyState, want)
+	}},
+}
+
+// conformanceModel is the reference model the randomised sequences are
+// checked against. Services missing from services are Halted.
+type conformanceModel struct {
+	state    service.RunnerState
+	all      []*conformanceService
+	services map[*conformanceService]service.State
+	pending  map[*conformanceService]<-chan error
+}
+
+func (ct *conformanceTest) random(rng *rand.Rand, steps int) {
+	m := &conformanceModel{
+		services: make(map[*conformanceService]service.State),
+		pending:  make(map[*conformanceService]<-chan error),
+	}
+	for i := 0; i < conformanceServices; i++ {
+		m.all = append(m.all, newConformanceService(fmt.Sprintf("s%d", i)))
+	}
+
+	for i := 0; i < steps; i++ {
+		cs := m.all[rng.Intn(len(m.all))]
+
+		switch op := rng.Intn(11); op {
+		case 0, 1, 2:
+			ct.modelStart(m, cs, rng.Intn(4))
+
+		case 3:
+			ct.step("release %s", cs.svc.Name)
+			if m.services[cs] != service.Starting {
+				continue
+			}
+			ct.open(cs.hold)
+			ct.mustOK(ct.await(m.pending[cs], "Start()"), "Start() of a released service")
+			delete(m.pending, cs)
+			m.services[cs] = service.Started
+
+		case 4:
+			ct.step("fail %s", cs.svc.Name)
+			if m.services[cs] != service.Started {
+				continue
+			}
+			cs.fail <- errConformanceRun
+			ct.expectEnd(cs, errConformanceRun)
+			ct.waitState(cs, service.Halted)
+			delete(m.services, cs)
+
+		case 5, 6:
+			ct.step("halt %s", cs.svc.Name)
+			ct.mustOK(ct.halt(cs), "Halt()")
+			ct.modelHalted(m, cs)
+
+		case 7:
+			ct.step("drain %s", cs.svc.Name)
+			ct.mustOK(ct.drain(cs), "Drain()")
+			ct.modelHalted(m, cs)
+
+		case 8:
+			ct.step("suspend")
+			if m.state == service.RunnerEnabled {
+				ct.mustOK(ct.runner.Suspend(), "Suspend()")
+				m.state = service.RunnerSuspended
+			} else {
+				ct.mustFail(ct.runner.Suspend(), "Suspend() when not enabled")
+			}
+
+		case 9:
+			ct.step("enable")
+			ct.mustOK(ct.runner.Enable(), "Enable()")
+			m.state = service.RunnerEnabled
+
+		case 10:
+			if rng.Intn(2) == 0 {
+				ct.step("halt all")
+				ct.mustOK(ct.halt(m.all...), "Halt()")
+			} else {
+				ct.step("shutdown")
+				ct.mustOK(ct.shutdown(), "Shutdown()")
+				m.state = service.RunnerShutdown
+			}
+			for _, cs := range m.all {
+				ct.modelHalted(m, cs)
+			}
+		}
+
+		ct.modelCheck(m)
+	}
+
+	ct.step("shutdown")
+	ct.mustOK(ct.shutdown(), "Shutdown()")
+	m.state = service.RunnerShutdown
+	for _, cs := range m.all {
+		ct.modelHalted(m, cs)
+	}
+	ct.modelCheck(m)
+}
+
+func (ct *conformanceTest) step(format string, args ...interface{}) {
+	ct.history = append(ct.history, fmt.Sprintf(format, args...))
+}
+
+// modelStart starts cs, which succeeds, fails before it is ready, or is held
+// in the Starting state depending on kind.
+func (ct *conformanceTest) modelStart(m *conformanceModel, cs *conformanceService, kind int) {
+	ct.Helper()
+
+	if m.state != service.RunnerEnabled {
+		ct.step("start %s while not enabled", cs.svc.Name)
+		ct.mustFail(ct.start(cs), "Start() when not enabled")
+		return
+	}
+	if _, ok := m.services[cs]; ok {
+		ct.step("start %s while running", cs.svc.Name)
+		ct.mustFail(ct.start(cs), "Start() of a running service")
+		return
+	}
+
+	cs.reset()
+	switch kind {
+	case 0:
+		ct.step("start %s with a ready error", cs.svc.Name)
+		cs.readyErr = errConformanceReady
+		ct.mustCause(errConformanceReady, ct.start(cs), "Start()")
+		ct.expectEnd(cs, errConformanceReady)
+		ct.waitState(cs, service.Halted)
+
+	case 1:
+		ct.step("start %s held", cs.svc.Name)
+		cs.hold = ct.gate()
+		m.pending[cs] = ct.startHeld(cs)
+		m.services[cs] = service.Starting
+
+	default:
+		ct.step("start %s", cs.svc.Name)
+		ct.mustOK(ct.start(cs), "Start()")
+		m.services[cs] = service.Started
+	}
+}
+
+// modelHalted updates m after cs has been halted, and checks that it ended
+// if it was running.
+func (ct *conformanceTest) modelHalted(m *conformanceModel, cs *conformanceService) {
+	ct.Helper()
+	if _, ok := m.services[cs]; !ok {
+		return
+	}
+	if pending := m.pending[cs]; pending != nil {
+		// Whether Start() succeeds when a service is halted before it is
+		// ready is up to the Runner, but it must return:
+		ct.await(pending, "Start()")
+		delete(m.pending, cs)
+	}
+	ct.expectEnd(cs, nil)
+	delete(m.services, cs)
+}
+
+func (ct *conformanceTest) modelCheck(m *conformanceModel) {
+	ct.Helper()
+
+	ct.expectRunnerState(m.state)
+	for _, cs := range m.all {
+		want, ok := m.services[cs]
+		if !ok {
+			want = service.Halted
+		}
+		ct.expectState(cs, want)
+		ct.expectNoEnd(cs)
+	}
+
+	for _, query := range []service.State{service.AnyState, service.Starting, service.Started, service.Halted} {
+		ct.expectServices(query, m.services)
+	}
+	if len(m.services) > 1 {
+		if n := len(ct.runner.Services(service.AnyState, 1, nil)); n != 1 {
+			ct.fatalf("Services() with a limit of 1 returned %d services", n)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package servicetest
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DurationStats collects a distribution of durations, such as the time taken
+// for services to start.
+type DurationStats struct {
+	samples []time.Duration
+	sorted  bool
+	lock    sync.Mutex
+}
+
+// DurationSummary describes a DurationStats at a point in time. Percentiles
+// use the nearest-rank method.
+type DurationSummary struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P99   time.Duration
+}
+
+func (d *DurationStats) Add(v time.Duration) {
+	d.lock.Lock()
+	d.samples = append(d.samples, v)
+	d.sorted = false
+	d.lock.Unlock()
+}
+
+func (d *DurationStats) Count() (out int) {
+	d.lock.Lock()
+	out = len(d.samples)
+	d.lock.Unlock()
+	return out
+}
+
+func (d *DurationStats) Summary() (out DurationSummary) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	n := len(d.samples)
+	if n == 0 {
+		return out
+	}
+	if !d.sorted {
+		sort.Slice(d.samples, func(i, j int) bool { return d.samples[i] < d.samples[j] })
+		d.sorted = true
+	}
+
+	var total float64
+	for _, v := range d.samples {
+		total += float64(v)
+	}
+
+	out.Count = n
+	out.Min = d.samples[0]
+	out.Max = d.samples[n-1]
+	out.Mean = time.Duration(total / float64(n))
+	out.P50 = d.percentile(50)
+	out.P99 = d.percentile(99)
+	return out
+}
+
+// percentile expects d.lock to be held and d.samples to be sorted and
+// non-empty.
+func (d *DurationStats) percentile(p int) time.Duration {
+	n := len(d.samples)
+	rank := (p*n + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return d.samples[rank-1]
+}
+
+func (d *DurationStats) Clone() *DurationStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return &DurationStats{
+		samples: append([]time.Duration(nil), d.samples...),
+		sorted:  d.sorted,
+	}
+}
+
+func (d *DurationStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Summary())
+}
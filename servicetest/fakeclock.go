@@ -0,0 +1,208 @@
+package servicetest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+// FakeClock is a service.Clock that only moves when it is told to, so that
+// time-dependent behaviour can be tested deterministically. Pass it to a
+// Runner with service.RunnerClock().
+//
+// Timers and tickers created by the clock fire during a call to Advance(), in
+// the order they are due, with Now() set to the time each one is due. Like a
+// time.Timer, a timer created (or Reset) with a duration <= 0 fires straight
+// away, without waiting for Advance().
+//
+// Advance() holds the clock's lock while it fires timers, so a goroutine woken
+// by one of them that calls Now() or creates a new timer waits until Advance()
+// returns, then sees the time Advance() moved the clock to. A timer created
+// that way is due relative to that time, so it does not fire during the same
+// Advance(), even if it would have been due before the end of it. Use
+// BlockUntilSleepers() to wait for it to be created, then Advance() again.
+//
+// A timer or ticker counts as a "sleeper" from the moment it is created until
+// it fires (for a timer) or is stopped. BlockUntilSleepers() lets a test wait
+// until the code under test has created all of the timers it is expected to
+// wait on before advancing the clock. The timer may be created slightly before
+// the goroutine that owns it is actually blocked on its channel, but the
+// channel is buffered just like a time.Timer's, so no ticks are lost.
+type FakeClock struct {
+	now      time.Time
+	sleepers []*fakeTimer
+	changed  chan struct{}
+	mu       sync.Mutex
+}
+
+var _ service.Clock = &FakeClock{}
+
+// NewFakeClock creates a FakeClock set to now. If now is the zero time, an
+// arbitrary fixed time is used instead.
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) NewTimer(d time.Duration) service.Timer {
+	return fc.newTimer(d, 0)
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) service.Ticker {
+	if d <= 0 {
+		panic("servicetest: non-positive interval for NewTicker")
+	}
+	return fakeTicker{fc.newTimer(d, d)}
+}
+
+func (fc *FakeClock) newTimer(d, period time.Duration) *fakeTimer {
+	t := &fakeTimer{clock: fc, c: make(chan time.Time, 1), period: period}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.schedule(t, d)
+	return t
+}
+
+// Sleepers returns the number of timers and tickers that are waiting to
+// fire.
+func (fc *FakeClock) Sleepers() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return len(fc.sleepers)
+}
+
+// BlockUntilSleepers blocks until at least n timers and tickers are waiting
+// to fire, or until ctx is done. ctx may be nil, in which case it waits
+// forever.
+func (fc *FakeClock) BlockUntilSleepers(ctx context.Context, n int) error {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	for {
+		fc.mu.Lock()
+		if len(fc.sleepers) >= n {
+			fc.mu.Unlock()
+			return nil
+		}
+		changed := fc.changed
+		fc.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-done:
+			return ctx.Err()
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker that
+// comes due along the way. Timers created while Advance is running do not
+// fire until the next call; see FakeClock.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	end := fc.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range fc.sleepers {
+			if !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		fc.now = next.at
+		select {
+		case next.c <- fc.now:
+		default:
+			// Like a time.Ticker, drop ticks for slow receivers.
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			fc.unschedule(next)
+		}
+	}
+	fc.now = end
+}
+
+// schedule adds t to the sleepers, due after d. A timer that is already due
+// fires immediately instead. fc.mu must be held.
+func (fc *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.at = fc.now.Add(d)
+	if d <= 0 && t.period == 0 {
+		select {
+		case t.c <- fc.now:
+		default:
+		}
+		return
+	}
+	t.active = true
+	fc.sleepers = append(fc.sleepers, t)
+	fc.notify()
+}
+
+// unschedule removes t from the sleepers. It returns false if t was not
+// waiting to fire. fc.mu must be held.
+func (fc *FakeClock) unschedule(t *fakeTimer) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, s := range fc.sleepers {
+		if s == t {
+			fc.sleepers = append(fc.sleepers[:i], fc.sleepers[i+1:]...)
+			break
+		}
+	}
+	fc.notify()
+	return true
+}
+
+// notify wakes anything blocked in BlockUntilSleepers. fc.mu must be held.
+func (fc *FakeClock) notify() {
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
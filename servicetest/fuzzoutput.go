@@ -7,6 +7,8 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	service "github.com/shabbyrobe/go-service"
 )
@@ -36,6 +38,7 @@ func fuzzOutputCLI(name string, stats *FuzzStats, w io.Writer) error {
 		headingw = 18
 		rowheadw = 18
 		okerrw   = 8
+		durw     = 11
 
 		heading    = func(v interface{}) string { return colorwr(lightBlue, headingw, ' ', v) }
 		rowhead    = func(v interface{}) string { return colorwr(lightBlue, rowheadw, ' ', v) }
@@ -43,6 +46,8 @@ func fuzzOutputCLI(name string, stats *FuzzStats, w io.Writer) error {
 		value      = func(v interface{}) string { return color(white, v) }
 		colhead    = func(v interface{}) string { return colorwr(lightCyan, okerrw, ' ', v) }
 		pctcol     = func(v interface{}) string { return colorwr(lightGray, okerrw, ' ', v) }
+		durhead    = func(v interface{}) string { return colorwr(lightCyan, durw, ' ', v) }
+		durcol     = func(v interface{}) string { return colorwr(white, durw, ' ', v) }
 
 		okcol = func(v interface{}) string {
 			col := lightGreen
@@ -101,6 +106,23 @@ func fuzzOutputCLI(name string, stats *FuzzStats, w io.Writer) error {
 	counterRow("start", stats.Service.ServiceStart)
 	counterRow("halt", stats.Service.ServiceHalt)
 	counterRow("restart", stats.Service.ServiceRestart)
+	counterRow("bounds", stats.Service.TimeBounds)
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "%s %s %s %s %s %s %s\n", rowhead(""),
+		durhead("count"), durhead("min"), durhead("mean"),
+		durhead("p50"), durhead("p99"), durhead("max"))
+
+	durationRow := func(head string, ds *DurationStats) {
+		sm := ds.Summary()
+		fmt.Fprintf(w, "%s %s %s %s %s %s %s\n", rowhead(head),
+			durcol(sm.Count), durcol(roundDuration(sm.Min)), durcol(roundDuration(sm.Mean)),
+			durcol(roundDuration(sm.P50)), durcol(roundDuration(sm.P99)), durcol(roundDuration(sm.Max)))
+	}
+
+	durationRow("start latency", stats.Service.StartLatency)
+	durationRow("run duration", stats.Service.RunDuration)
+	durationRow("halt latency", stats.Service.HaltLatency)
 
 	fmt.Fprintf(w, "\n")
 
@@ -135,6 +157,18 @@ func fuzzOutputCLI(name string, stats *FuzzStats, w io.Writer) error {
 	return nil
 }
 
+// roundDuration keeps durations short enough to fit in a column.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	default:
+		return d
+	}
+}
+
 const (
 	black        = 30
 	red          = 31
@@ -160,15 +194,17 @@ func color(col int, v interface{}) string {
 
 func colorwl(col int, w int, c byte, v interface{}) string {
 	vs := fmt.Sprintf("%v", v)
-	vl := len(vs)
-	vs += strings.Repeat(string(c), w-vl)
+	vl := utf8.RuneCountInString(vs)
+	if vl < w {
+		vs += strings.Repeat(string(c), w-vl)
+	}
 	return fmt.Sprintf("\x1b[%dm%v\x1b[0m", col, vs)
 }
 
 func colorwr(col int, w int, c byte, v interface{}) string {
 	vs := fmt.Sprintf("%v", v)
 	cs := string(c)
-	for i := len(vs); i < w; i++ {
+	for i := utf8.RuneCountInString(vs); i < w; i++ {
 		vs = cs + vs
 	}
 	return fmt.Sprintf("\x1b[%dm%v\x1b[0m", col, vs)
@@ -0,0 +1,253 @@
+package servicetest
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"time"
+)
+
+// ReplayFuzzOps issues ops against new Runners, each at the same offset from
+// the start of the replay as it was originally issued. When all of the
+// operations have completed and the runners have been shut down, the trace of
+// the replay is passed to check, and its result is returned. If check is
+// nil, CheckFuzzTrace is used.
+//
+// The runners are also watched by an InvariantChecker. If check passes but
+// an invariant was violated, the *InvariantError is returned.
+//
+// The runner is concurrent, so a replay will not interleave in exactly the
+// same way as the original run. A failure may need to be replayed several
+// times before it reproduces.
+func ReplayFuzzOps(ops []FuzzOp, check FuzzCheck) error {
+	if check == nil {
+		check = CheckFuzzTrace
+	}
+
+	ic := NewInvariantChecker()
+	defer ic.Close()
+
+	fz := &RunnerFuzzer{
+		Stats:      NewFuzzStats(),
+		Trace:      NewFuzzTrace(),
+		Invariants: ic,
+	}
+
+	// finish() uses these to decide how long to wait for the operations to
+	// complete:
+	for _, op := range ops {
+		fz.ServiceHaltTimeout.Max = maxDuration(fz.ServiceHaltTimeout.Max, op.Timeout, op.HaltTimeout)
+		fz.ServiceRunTime.Max = maxDuration(fz.ServiceRunTime.Max, op.StartDelay+op.RunTime, op.HaltAfter)
+		fz.ServiceHaltDelay.Max = maxDuration(fz.ServiceHaltDelay.Max, op.HaltDelay)
+	}
+
+	fz.Stats.Start()
+	fz.init()
+	defer fz.RunnerSource.End()
+
+	start := time.Now()
+	for _, op := range ops {
+		if d := op.At - time.Since(start); d > 0 {
+			time.Sleep(d)
+		}
+		fz.exec(op)
+	}
+	fz.finish()
+
+	if err := check(fz.Trace); err != nil {
+		return err
+	}
+	return ic.Close()
+}
+
+// ShrinkFuzzOps repeatedly removes operations from ops for as long as fails
+// still reports a failure, and returns the smallest sequence it finds.
+//
+// Because the runner is concurrent, a failure may not reproduce every time;
+// each candidate sequence is tried up to attempts times before it is
+// considered to pass.
+//
+// When operations are removed, the ones after them are moved earlier by the
+// time the removed operations took, so each one keeps the same delay after
+// the operation before it. Otherwise every candidate would take as long to
+// replay as the original run.
+//
+// To shrink a failing trace against a FuzzCheck:
+//
+//	min := ShrinkFuzzOps(trace.Ops, 3, func(ops []FuzzOp) bool {
+//		return ReplayFuzzOps(ops, check) != nil
+//	})
+//	fmt.Println(FuzzOpsGoTest("TestFuzzRepro", min, FuzzGoTestCheck("check")))
+func ShrinkFuzzOps(ops []FuzzOp, attempts int, fails func(ops []FuzzOp) bool) []FuzzOp {
+	if attempts < 1 {
+		attempts = 1
+	}
+	reproduces := func(ops []FuzzOp) bool {
+		for i := 0; i < attempts; i++ {
+			if fails(ops) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// This is a simplified form of delta debugging: try removing chunks of
+	// decreasing size until no single operation can be removed.
+	chunks := 2
+	for len(ops) > 1 {
+		size := (len(ops) + chunks - 1) / chunks
+		removed := false
+
+		for start := 0; start < len(ops); start += size {
+			end := start + size
+			if end > len(ops) {
+				end = len(ops)
+			}
+			candidate := removeFuzzOps(ops, start, end)
+			if reproduces(candidate) {
+				ops = candidate
+				removed = true
+				break
+			}
+		}
+
+		if removed {
+			if chunks > 2 {
+				chunks--
+			}
+		} else if size == 1 {
+			break
+		} else {
+			chunks *= 2
+			if chunks > len(ops) {
+				chunks = len(ops)
+			}
+		}
+	}
+	return ops
+}
+
+// removeFuzzOps returns a copy of ops without ops[start:end]. The ops after
+// end are moved earlier by the time between ops[start-1] (or the start of the
+// run) and ops[end-1].
+func removeFuzzOps(ops []FuzzOp, start, end int) []FuzzOp {
+	var shift time.Duration
+	if end > start {
+		shift = ops[end-1].At
+		if start > 0 {
+			shift -= ops[start-1].At
+		}
+	}
+
+	out := make([]FuzzOp, 0, len(ops)-(end-start))
+	out = append(out, ops[:start]...)
+	for _, op := range ops[end:] {
+		if op.At -= shift; op.At < 0 {
+			op.At = 0
+		}
+		out = append(out, op)
+	}
+	return out
+}
+
+type FuzzGoTestOption func(gt *fuzzGoTest)
+
+type fuzzGoTest struct {
+	check string
+}
+
+// FuzzGoTestCheck replays the ops in the generated test with the FuzzCheck
+// named by expr, which is Go source for an expression in the test's package,
+// i.e. "checkNoRestarts" or "mypkg.CheckThing". It should be the check that
+// failed, otherwise the generated test may pass. The default is
+// CheckFuzzTrace.
+func FuzzGoTestCheck(expr string) FuzzGoTestOption {
+	return func(gt *fuzzGoTest) { gt.check = expr }
+}
+
+// FuzzOpsGoTest renders ops as the source of a Go test function called name,
+// which replays them with ReplayFuzzOps. The test fails if the check fails,
+// which is CheckFuzzTrace unless FuzzGoTestCheck is supplied, or if any
+// invariants are violated. The test belongs in a package that imports
+// servicetest, testing and time.
+func FuzzOpsGoTest(name string, ops []FuzzOp, options ...FuzzGoTestOption) string {
+	var gt fuzzGoTest
+	for _, o := range options {
+		o(&gt)
+	}
+	if gt.check == "" {
+		gt.check = "nil"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "func %s(t *testing.T) {\n", name)
+	fmt.Fprintf(&buf, "ops := []servicetest.FuzzOp{\n")
+	for _, op := range ops {
+		fmt.Fprintf(&buf, "{Kind: servicetest.%s, At: %s, Runner: %d", op.Kind, goDuration(op.At), op.Runner)
+		if op.Service != 0 {
+			fmt.Fprintf(&buf, ", Service: %d", op.Service)
+		}
+		for _, f := range []struct {
+			name string
+			v    time.Duration
+		}{
+			{"Timeout", op.Timeout},
+			{"HaltTimeout", op.HaltTimeout},
+			{"HaltAfter", op.HaltAfter},
+			{"StartDelay", op.StartDelay},
+			{"RunTime", op.RunTime},
+			{"HaltDelay", op.HaltDelay},
+		} {
+			if f.v != 0 {
+				fmt.Fprintf(&buf, ", %s: %s", f.name, goDuration(f.v))
+			}
+		}
+		if op.StartLimit != 0 {
+			fmt.Fprintf(&buf, ", StartLimit: %d", op.StartLimit)
+		}
+		if op.StartFailure {
+			fmt.Fprintf(&buf, ", StartFailure: true")
+		}
+		if op.RunFailure {
+			fmt.Fprintf(&buf, ", RunFailure: true")
+		}
+		fmt.Fprintf(&buf, "},\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+	fmt.Fprintf(&buf, "if err := servicetest.ReplayFuzzOps(ops, %s); err != nil {\n", gt.check)
+	fmt.Fprintf(&buf, "t.Fatal(err)\n")
+	fmt.Fprintf(&buf, "}\n")
+	fmt.Fprintf(&buf, "}\n")
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		// This is a bug in the generator, but the unformatted source is more
+		// useful than nothing:
+		return buf.String()
+	}
+	return string(out)
+}
+
+func goDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0"
+	case d%time.Second == 0:
+		return fmt.Sprintf("%d * time.Second", d/time.Second)
+	case d%time.Millisecond == 0:
+		return fmt.Sprintf("%d * time.Millisecond", d/time.Millisecond)
+	case d%time.Microsecond == 0:
+		return fmt.Sprintf("%d * time.Microsecond", d/time.Microsecond)
+	default:
+		return fmt.Sprintf("%d", int64(d))
+	}
+}
+
+func maxDuration(ds ...time.Duration) (max time.Duration) {
+	for _, d := range ds {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
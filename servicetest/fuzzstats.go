@@ -138,6 +138,19 @@ type FuzzServiceStats struct {
 	ServiceHalt    *ErrorCounter
 	ServiceStart   *ErrorCounter
 	ServiceRestart *ErrorCounter
+
+	// Time taken by successful calls to Start(), from the time each service
+	// became ready until its Run() returned, and by successful calls to
+	// Halt():
+	StartLatency *DurationStats
+	RunDuration  *DurationStats
+	HaltLatency  *DurationStats
+
+	// Each of the times above is checked against the bounds implied by the
+	// fuzzer's parameters for that service. Times within the bounds count as
+	// successes, the rest count as errors, with a description of the bound
+	// that was crossed.
+	TimeBounds *ErrorCounter
 }
 
 func NewFuzzServiceStats() *FuzzServiceStats {
@@ -148,6 +161,11 @@ func NewFuzzServiceStats() *FuzzServiceStats {
 		ServiceHalt:    &ErrorCounter{},
 		ServiceStart:   &ErrorCounter{},
 		ServiceRestart: &ErrorCounter{},
+
+		StartLatency: &DurationStats{},
+		RunDuration:  &DurationStats{},
+		HaltLatency:  &DurationStats{},
+		TimeBounds:   &ErrorCounter{},
 	}
 }
 
@@ -165,6 +183,7 @@ func (s *FuzzServiceStats) Errors() (out []FuzzError) {
 		{"ServiceHalt", s.ServiceHalt},
 		{"ServiceStart", s.ServiceStart},
 		{"ServiceRestart", s.ServiceRestart},
+		{"TimeBounds", s.TimeBounds},
 	} {
 		for e, cnt := range t.Counter.errors {
 			out = append(out, FuzzError{
@@ -186,6 +205,11 @@ func (s *FuzzServiceStats) Map() map[string]interface{} {
 		"ServiceStart.Failed":      s.ServiceStart.Failed(),
 		"ServiceRestart.Succeeded": s.ServiceRestart.Succeeded(),
 		"ServiceRestart.Failed":    s.ServiceRestart.Failed(),
+		"TimeBounds.Succeeded":     s.TimeBounds.Succeeded(),
+		"TimeBounds.Failed":        s.TimeBounds.Failed(),
+		"StartLatency":             s.StartLatency.Summary(),
+		"RunDuration":              s.RunDuration.Summary(),
+		"HaltLatency":              s.HaltLatency.Summary(),
 	}
 }
 
@@ -219,6 +243,10 @@ func (s *FuzzServiceStats) Clone() *FuzzServiceStats {
 	n.ServiceHalt = s.ServiceHalt.Clone()
 	n.ServiceStart = s.ServiceStart.Clone()
 	n.ServiceRestart = s.ServiceRestart.Clone()
+	n.StartLatency = s.StartLatency.Clone()
+	n.RunDuration = s.RunDuration.Clone()
+	n.HaltLatency = s.HaltLatency.Clone()
+	n.TimeBounds = s.TimeBounds.Clone()
 
 	s.serviceEndsLock.Lock()
 	n.serviceEnded = s.serviceEnded
@@ -0,0 +1,206 @@
+package servicetest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+type FuzzOpKind int
+
+const (
+	FuzzCreateRunner FuzzOpKind = iota + 1
+	FuzzHaltRunner
+	FuzzStartService
+	FuzzHaltService
+	FuzzRestartService
+	FuzzCheckState
+
+	// FuzzServiceEnded only appears in a FuzzOutcome; it is recorded when the
+	// Runner reports that a service has ended.
+	FuzzServiceEnded
+)
+
+var fuzzOpKindNames = map[FuzzOpKind]string{
+	FuzzCreateRunner:   "FuzzCreateRunner",
+	FuzzHaltRunner:     "FuzzHaltRunner",
+	FuzzStartService:   "FuzzStartService",
+	FuzzHaltService:    "FuzzHaltService",
+	FuzzRestartService: "FuzzRestartService",
+	FuzzCheckState:     "FuzzCheckState",
+	FuzzServiceEnded:   "FuzzServiceEnded",
+}
+
+func (k FuzzOpKind) String() string {
+	if s, ok := fuzzOpKindNames[k]; ok {
+		return s
+	}
+	return fmt.Sprintf("FuzzOpKind(%d)", int(k))
+}
+
+// FuzzOp is a single operation issued by a RunnerFuzzer. It contains
+// everything needed to issue the operation again without any randomness.
+type FuzzOp struct {
+	Kind FuzzOpKind
+
+	// Time since the start of the run at which the operation was issued.
+	At time.Duration
+
+	// ID of the runner the operation applies to. Runner IDs are assigned in
+	// the order the runners are created, starting at 1.
+	Runner int
+
+	// ID of the service started by a FuzzStartService. Other service
+	// operations apply to whichever service the runner reports first at the
+	// time, so this is 0.
+	Service int `json:",omitempty"`
+
+	// Timeout for the Start(), Halt() or Shutdown() call made by the
+	// operation. For FuzzRestartService, this is the Start() timeout.
+	Timeout time.Duration `json:",omitempty"`
+
+	// Halt() timeout used by FuzzRestartService, and by the halt that
+	// follows a FuzzStartService after HaltAfter.
+	HaltTimeout time.Duration `json:",omitempty"`
+
+	// FuzzStartService only:
+	HaltAfter    time.Duration `json:",omitempty"`
+	StartDelay   time.Duration `json:",omitempty"`
+	RunTime      time.Duration `json:",omitempty"`
+	HaltDelay    time.Duration `json:",omitempty"`
+	StartLimit   int           `json:",omitempty"`
+	StartFailure bool          `json:",omitempty"`
+	RunFailure   bool          `json:",omitempty"`
+}
+
+// FuzzOutcome is the observed result of a FuzzOp, or of a service ending.
+type FuzzOutcome struct {
+	Kind    FuzzOpKind
+	At      time.Duration
+	Runner  int           `json:",omitempty"`
+	Service int           `json:",omitempty"`
+	Err     string        `json:",omitempty"`
+	State   service.State `json:",omitempty"`
+}
+
+// FuzzTrace records every operation issued by a RunnerFuzzer and every
+// outcome it observes. Assign one to RunnerFuzzer.Trace before calling Run()
+// to enable recording.
+//
+// A nil *FuzzTrace records nothing.
+type FuzzTrace struct {
+	Ops      []FuzzOp
+	Outcomes []FuzzOutcome
+
+	start    time.Time
+	services map[*service.Service]int
+	mu       sync.Mutex
+}
+
+func NewFuzzTrace() *FuzzTrace {
+	return &FuzzTrace{}
+}
+
+func (t *FuzzTrace) begin() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.start = time.Now()
+	t.Ops, t.Outcomes = nil, nil
+	t.services = make(map[*service.Service]int)
+	t.mu.Unlock()
+}
+
+func (t *FuzzTrace) addOp(op FuzzOp) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.Ops = append(t.Ops, op)
+	t.mu.Unlock()
+}
+
+// since returns the offset from the start of the trace, which is used as the
+// At of any new op.
+func (t *FuzzTrace) since() time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Since(t.start)
+}
+
+func (t *FuzzTrace) addOutcome(o FuzzOutcome) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	o.At = time.Since(t.start)
+	t.Outcomes = append(t.Outcomes, o)
+	t.mu.Unlock()
+}
+
+func (t *FuzzTrace) addService(svc *service.Service, id int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.services[svc] = id
+	t.mu.Unlock()
+}
+
+func (t *FuzzTrace) serviceID(svc *service.Service) int {
+	if t == nil || svc == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.services[svc]
+}
+
+// FuzzCheck inspects a completed trace and returns an error describing the
+// first problem it finds.
+type FuzzCheck func(trace *FuzzTrace) error
+
+// CheckFuzzTrace is the default FuzzCheck. It reports any service that
+// started successfully but never ended, which should be impossible once the
+// fuzzer has shut down all of its runners.
+func CheckFuzzTrace(trace *FuzzTrace) error {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+
+	// Outcomes are recorded by several goroutines, so a service's end may be
+	// recorded before its start; count them rather than relying on order:
+	starts, ends := make(map[int]int), make(map[int]int)
+	var ids []int
+	for _, o := range trace.Outcomes {
+		switch o.Kind {
+		case FuzzStartService, FuzzRestartService:
+			if o.Err == "" && o.Service != 0 {
+				if starts[o.Service] == 0 {
+					ids = append(ids, o.Service)
+				}
+				starts[o.Service]++
+			}
+		case FuzzServiceEnded:
+			ends[o.Service]++
+		}
+	}
+	for _, id := range ids {
+		if starts[id] > ends[id] {
+			return fmt.Errorf("servicetest: service %d started %d time(s) but ended %d time(s)", id, starts[id], ends[id])
+		}
+	}
+	return nil
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -1,7 +1,6 @@
 package servicetest
 
 import (
-	"bytes"
 	"expvar"
 	"flag"
 	"fmt"
@@ -9,7 +8,6 @@ import (
 	netprof "net/http/pprof"
 	"os"
 	"runtime"
-	"runtime/pprof"
 	"sync"
 	"testing"
 	"time"
@@ -31,6 +29,8 @@ var (
 	fuzzDebugHost    string
 	fuzzMetaRolls    int64
 	fuzzMetaMin      int
+	fuzzReplay       string
+	fuzzTrace        bool
 
 	fuzzDefaultRunnerLimit  int
 	fuzzDefaultServiceLimit int
@@ -71,10 +71,14 @@ func TestMain(m *testing.M) {
 		"Minimum number of times to run the meta fuzzer regardless of duration")
 	flag.StringVar(&fuzzOutputFormat, "service.fuzzoutfmt", "cli",
 		"Fuzz verbose output format (cli or json)")
-	flag.IntVar(&fuzzDefaultRunnerLimit, "service.fuzzrunnerlim", 20000,
+	flag.IntVar(&fuzzDefaultRunnerLimit, "service.fuzzrunnerlim", DefaultFuzzRunnerLimit,
 		"Limit the number of runners that can concurrently exist if the fuzz test does not explicitly declare a limit")
-	flag.IntVar(&fuzzDefaultServiceLimit, "service.fuzzservicelim", 100000,
+	flag.IntVar(&fuzzDefaultServiceLimit, "service.fuzzservicelim", DefaultFuzzServiceLimit,
 		"Limit the number of services that can concurrently exist if the fuzz test does not explicitly declare a limit")
+	flag.StringVar(&fuzzReplay, "service.fuzz.replay", "",
+		"Run TestRunnerFuzzReplay with the fuzzer parameters in this JSON file")
+	flag.BoolVar(&fuzzTrace, "service.fuzz.trace", false,
+		"Record every fuzzer operation, and shrink the operations to a Go test if the trace check fails")
 
 	flag.Parse()
 
@@ -126,29 +130,6 @@ func TestMain(m *testing.M) {
 
 	fmt.Printf("Fuzz seed: %d\n", fuzzSeed)
 
-	beforeCount := pprof.Lookup("goroutine").Count()
-	code := m.Run()
-
-	// This little hack gives things like "go OnServiceState" a chance to
-	// finish - it routinely shows up in the profile.
-	//
-	// Also, some calls to the listener that are called with "go" might
-	// not have had a chance to finish. This is brittle, true, but some
-	// of the tests are hopelessly complicated without it.
-	time.Sleep(20 * time.Millisecond)
-
-	if code == 0 {
-		after := pprof.Lookup("goroutine")
-		afterCount := after.Count()
-
-		diff := afterCount - beforeCount
-		if diff > 0 {
-			var buf bytes.Buffer
-			after.WriteTo(&buf, 1)
-			fmt.Fprintf(os.Stderr, "stray goroutines: %d\n%s\n", diff, buf.String())
-			os.Exit(2)
-		}
-	}
-
-	os.Exit(code)
+	// Connections to the debug server may still be open when the tests end:
+	os.Exit(VerifyNoLeaksMain(m, IgnoreLeak("net/http.(*conn).serve")))
 }
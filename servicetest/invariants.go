@@ -0,0 +1,584 @@
+package servicetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	// DefaultInvariantStateBuffer is the size of the StateChange channel the
+	// InvariantChecker gives to each Runner.
+	DefaultInvariantStateBuffer = 256
+
+	invariantPollInterval = time.Millisecond
+	invariantMaxReported  = 10
+)
+
+// InvariantChecker watches one or more Runners and records any violation of
+// the guarantees a Runner is supposed to make about the services it runs:
+//
+//   - Each StateChange for a service begins in the State the previous one
+//     ended in. Each run of a service begins from Halted, after the previous
+//     run has Ended.
+//   - OnEnd is called at most once per run, after the Ended StateChange.
+//   - Halt() does not return successfully until every service that was
+//     running when it was called has Ended.
+//   - Start() does not return successfully while any service it started is
+//     still Starting.
+//   - An error returned by a service before it became ready is only returned
+//     by Start(), never by Halt().
+//
+// Create a Listener for each Runner with Listener(), pass it to a
+// RunnerSource, and wrap the resulting Runner with InvariantListener.Runner()
+// so that the results of Start() and Halt() can be checked. Call Close() when
+// the Runners have been shut down to get the violations, along with the
+// history of events for each service that was involved.
+//
+// A Runner drops StateChanges if its channel is full. If the checker sees a
+// full channel, it stops checking the invariants that depend on StateChanges
+// for that Runner; see Dropped().
+type InvariantChecker struct {
+	stateBuffer int
+
+	listeners  []*InvariantListener
+	tracks     map[invariantKey]*invariantTrack
+	violations []InvariantViolation
+	total      int
+	seq        int
+	closed     bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+}
+
+type InvariantOption func(ic *InvariantChecker)
+
+// InvariantStateBuffer sets the size of the StateChange channel given to
+// each Runner. The default is DefaultInvariantStateBuffer.
+func InvariantStateBuffer(n int) InvariantOption {
+	return func(ic *InvariantChecker) { ic.stateBuffer = n }
+}
+
+// NewInvariantChecker creates an InvariantChecker. Close() must be called to
+// stop it.
+func NewInvariantChecker(options ...InvariantOption) *InvariantChecker {
+	ic := &InvariantChecker{
+		stateBuffer: DefaultInvariantStateBuffer,
+		tracks:      make(map[invariantKey]*invariantTrack),
+		stop:        make(chan struct{}),
+	}
+	for _, o := range options {
+		o(ic)
+	}
+
+	ic.wg.Add(1)
+	go ic.poll()
+	return ic
+}
+
+// Listener creates an InvariantListener for a new Runner. Events are passed
+// on to next, which may be nil.
+func (ic *InvariantChecker) Listener(next Listener) *InvariantListener {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	il := &InvariantListener{
+		checker: ic,
+		runner:  len(ic.listeners) + 1,
+		next:    next,
+		states:  make(chan service.StateChange, ic.stateBuffer),
+	}
+	ic.listeners = append(ic.listeners, il)
+	return il
+}
+
+// NewRunner creates a Runner that is watched by the checker. The checker's
+// RunnerOnEnd and RunnerOnState options replace any passed in options.
+func (ic *InvariantChecker) NewRunner(options ...service.RunnerOption) service.Runner {
+	il := ic.Listener(nil)
+	options = append(options,
+		service.RunnerOnEnd(il.OnServiceEnd()),
+		service.RunnerOnState(il.OnServiceState()))
+	return il.Runner(service.NewRunner(options...))
+}
+
+// Dropped returns the number of Runners that may have dropped StateChanges
+// because their channel was full.
+func (ic *InvariantChecker) Dropped() (n int) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	for _, il := range ic.listeners {
+		if il.dropped {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns an *InvariantError if any violations have been recorded so
+// far.
+func (ic *InvariantChecker) Err() error {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.drain()
+	if ic.total == 0 {
+		return nil
+	}
+	return &InvariantError{
+		Violations: append([]InvariantViolation(nil), ic.violations...),
+		Total:      ic.total,
+	}
+}
+
+// Close stops the checker and returns the result of Err(). The Runners
+// should be shut down first.
+func (ic *InvariantChecker) Close() error {
+	ic.mu.Lock()
+	closed := ic.closed
+	ic.closed = true
+	ic.mu.Unlock()
+
+	if !closed {
+		close(ic.stop)
+		ic.wg.Wait()
+	}
+	return ic.Err()
+}
+
+// poll drains the StateChange channels periodically so that they don't fill
+// up between the other events, which drain them as well.
+func (ic *InvariantChecker) poll() {
+	defer ic.wg.Done()
+	tick := time.NewTicker(invariantPollInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			ic.mu.Lock()
+			ic.drain()
+			ic.mu.Unlock()
+		case <-ic.stop:
+			return
+		}
+	}
+}
+
+// drain consumes every StateChange that is waiting in a channel. A Runner
+// sends its StateChanges before it calls OnEnd or returns from Start() or
+// Halt(), so draining before recording any of those puts the events in the
+// order they happened. ic.mu must be held.
+func (ic *InvariantChecker) drain() {
+	for _, il := range ic.listeners {
+		if len(il.states) == cap(il.states) {
+			il.dropped = true
+		}
+	states:
+		for {
+			select {
+			case sc := <-il.states:
+				ic.state(il, sc)
+			default:
+				break states
+			}
+		}
+	}
+}
+
+// track returns the track for svc in il's Runner. ic.mu must be held.
+func (ic *InvariantChecker) track(il *InvariantListener, svc *service.Service) *invariantTrack {
+	key := invariantKey{il.runner, svc}
+	t := ic.tracks[key]
+	if t == nil {
+		t = &invariantTrack{}
+		ic.tracks[key] = t
+	}
+	return t
+}
+
+// record adds ev to the history of t. ic.mu must be held.
+func (ic *InvariantChecker) record(t *invariantTrack, ev InvariantEvent) {
+	ic.seq++
+	ev.Seq = ic.seq
+	t.history = append(t.history, ev)
+}
+
+// violate records a violation of an invariant. ic.mu must be held.
+func (ic *InvariantChecker) violate(il *InvariantListener, svc *service.Service, t *invariantTrack, msg string, args ...interface{}) {
+	ic.total++
+	if len(ic.violations) >= invariantMaxReported {
+		return
+	}
+	ic.violations = append(ic.violations, InvariantViolation{
+		Runner:  il.runner,
+		Service: svc,
+		Message: fmt.Sprintf(msg, args...),
+		History: append([]InvariantEvent(nil), t.history...),
+	})
+}
+
+func (ic *InvariantChecker) state(il *InvariantListener, sc service.StateChange) {
+	t := ic.track(il, sc.Service)
+	ic.record(t, InvariantEvent{Kind: InvariantState, From: sc.From, To: sc.To})
+
+	expected := t.last
+	if t.last == service.NoState || t.last == service.Ended {
+		expected = service.Halted
+	}
+	if !il.dropped && sc.From != expected {
+		ic.violate(il, sc.Service, t, "state changed from %s, expected %s", sc.From, expected)
+	}
+	t.last = sc.To
+
+	if sc.From == service.Starting {
+		if run := t.run(); run != nil {
+			run.started = true
+		}
+	}
+	switch sc.To {
+	case service.Starting:
+		t.runs = append(t.runs, &invariantRun{})
+	case service.Ended:
+		if run := t.run(); run != nil {
+			run.ended = true
+		}
+	}
+}
+
+func (ic *InvariantChecker) end(il *InvariantListener, stage service.Stage, svc *service.Service, err error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.drain()
+
+	t := ic.track(il, svc)
+	ic.record(t, InvariantEvent{Kind: InvariantEnd, Stage: stage, Err: err})
+	if !il.trusted() {
+		return
+	}
+
+	run := t.run()
+	if run == nil {
+		ic.violate(il, svc, t, "OnEnd called for a service that never started")
+		return
+	}
+	run.ends++
+	if run.ends > 1 {
+		ic.violate(il, svc, t, "OnEnd called %d times for one run", run.ends)
+	}
+	if !run.ended {
+		ic.violate(il, svc, t, "OnEnd called before the Ended state change")
+	}
+	if stage == service.StageReady && err != nil {
+		run.readyErr = err
+	}
+}
+
+// begin records a call to Start() or Halt() and returns a snapshot of each
+// service's runs at the time of the call.
+func (ic *InvariantChecker) begin(il *InvariantListener, kind InvariantEventKind, services []*service.Service) []invariantCall {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.drain()
+
+	calls := make([]invariantCall, 0, len(services))
+	for _, svc := range services {
+		if svc == nil {
+			continue
+		}
+		t := ic.track(il, svc)
+		ic.record(t, InvariantEvent{Kind: kind})
+		calls = append(calls, invariantCall{
+			service: svc,
+			runs:    len(t.runs),
+			running: t.run() != nil && !t.run().ended,
+		})
+	}
+	return calls
+}
+
+func (ic *InvariantChecker) started(il *InvariantListener, calls []invariantCall, err error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.drain()
+
+	for _, c := range calls {
+		t := ic.track(il, c.service)
+		ic.record(t, InvariantEvent{Kind: InvariantStartReturned, Err: err})
+		if err != nil || !il.trusted() {
+			continue
+		}
+
+		// Another caller may have halted and restarted the service since
+		// this Start() returned, so any run since the call will do. The
+		// service may also have been halted, or ended without error, before it
+		// became Started.
+		ok := false
+		for _, run := range t.runs[c.runs:] {
+			if run.started {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			ic.violate(il, c.service, t, "Start() succeeded while the service was still Starting")
+		}
+	}
+}
+
+func (ic *InvariantChecker) halted(il *InvariantListener, calls []invariantCall, err error) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.drain()
+
+	for _, c := range calls {
+		t := ic.track(il, c.service)
+		ic.record(t, InvariantEvent{Kind: InvariantHaltReturned, Err: err})
+		if !c.running || !il.trusted() {
+			continue
+		}
+
+		run := t.runs[c.runs-1]
+		if err == nil && !run.ended {
+			ic.violate(il, c.service, t, "Halt() succeeded before the service Ended")
+		}
+		if run.readyErr != nil && !service.IsTimeout(err) {
+			for _, e := range service.Errors(err) {
+				// A context's errors are shared sentinels, so they don't say
+				// where the error came from; Halt() timing out has nothing to
+				// do with a ready stage that was also ended by a deadline:
+				ec := cause(e)
+				if service.IsTimeout(e) || ec == context.Canceled || ec == context.DeadlineExceeded {
+					continue
+				}
+				if ec == cause(run.readyErr) {
+					ic.violate(il, c.service, t, "Halt() returned the service's ready error: %v", e)
+					break
+				}
+			}
+		}
+	}
+}
+
+// InvariantListener is a Listener that reports the events of a single Runner
+// to an InvariantChecker.
+type InvariantListener struct {
+	checker *InvariantChecker
+	runner  int
+	next    Listener
+	states  chan service.StateChange
+
+	// These are protected by checker.mu:
+	watching bool
+	dropped  bool
+}
+
+var _ StateListener = &InvariantListener{}
+
+func (il *InvariantListener) OnServiceEnd() service.OnEnd {
+	var next service.OnEnd
+	if il.next != nil {
+		next = il.next.OnServiceEnd()
+	}
+	return func(stage service.Stage, svc *service.Service, err error) {
+		il.checker.end(il, stage, svc, err)
+		if next != nil {
+			next(stage, svc, err)
+		}
+	}
+}
+
+func (il *InvariantListener) OnServiceError() service.OnError {
+	if il.next == nil {
+		return nil
+	}
+	return il.next.OnServiceError()
+}
+
+func (il *InvariantListener) OnServiceState() chan<- service.StateChange {
+	il.checker.mu.Lock()
+	il.watching = true
+	il.checker.mu.Unlock()
+	return il.states
+}
+
+// Runner wraps r, which must have been created with il, so that calls to
+// Start() and Halt() are checked.
+func (il *InvariantListener) Runner(r service.Runner) service.Runner {
+	return &invariantRunner{Runner: r, listener: il}
+}
+
+// trusted reports whether il has seen every StateChange sent by its Runner.
+// checker.mu must be held.
+func (il *InvariantListener) trusted() bool {
+	return il.watching && !il.dropped
+}
+
+type invariantRunner struct {
+	service.Runner
+	listener *InvariantListener
+}
+
+func (ir *invariantRunner) Start(ctx context.Context, services ...*service.Service) error {
+	ic := ir.listener.checker
+	calls := ic.begin(ir.listener, InvariantStart, services)
+	err := ir.Runner.Start(ctx, services...)
+	ic.started(ir.listener, calls, err)
+	return err
+}
+
+func (ir *invariantRunner) Halt(ctx context.Context, services ...*service.Service) error {
+	ic := ir.listener.checker
+	calls := ic.begin(ir.listener, InvariantHalt, services)
+	err := ir.Runner.Halt(ctx, services...)
+	ic.halted(ir.listener, calls, err)
+	return err
+}
+
+func (ir *invariantRunner) Drain(ctx context.Context, services ...*service.Service) error {
+	ic := ir.listener.checker
+	calls := ic.begin(ir.listener, InvariantHalt, services)
+	err := ir.Runner.Drain(ctx, services...)
+	ic.halted(ir.listener, calls, err)
+	return err
+}
+
+type InvariantEventKind int
+
+const (
+	InvariantState InvariantEventKind = iota + 1
+	InvariantEnd
+	InvariantStart
+	InvariantStartReturned
+	InvariantHalt
+	InvariantHaltReturned
+)
+
+func (k InvariantEventKind) String() string {
+	switch k {
+	case InvariantState:
+		return "state"
+	case InvariantEnd:
+		return "end"
+	case InvariantStart:
+		return "start"
+	case InvariantStartReturned:
+		return "start returned"
+	case InvariantHalt:
+		return "halt"
+	case InvariantHaltReturned:
+		return "halt returned"
+	default:
+		return fmt.Sprintf("InvariantEventKind(%d)", int(k))
+	}
+}
+
+// InvariantEvent is an event seen by an InvariantChecker for a single
+// service.
+type InvariantEvent struct {
+	// Seq orders the events seen by a checker across all services.
+	Seq  int
+	Kind InvariantEventKind
+
+	// InvariantState only:
+	From, To service.State
+
+	// InvariantEnd only:
+	Stage service.Stage
+
+	// InvariantEnd, InvariantStartReturned and InvariantHaltReturned only:
+	Err error
+}
+
+func (e InvariantEvent) String() string {
+	switch e.Kind {
+	case InvariantState:
+		return fmt.Sprintf("#%d %s %s -> %s", e.Seq, e.Kind, e.From, e.To)
+	case InvariantEnd:
+		stage := "run"
+		if e.Stage == service.StageReady {
+			stage = "ready"
+		}
+		return fmt.Sprintf("#%d %s in %s stage: %v", e.Seq, e.Kind, stage, e.Err)
+	case InvariantStartReturned, InvariantHaltReturned:
+		return fmt.Sprintf("#%d %s: %v", e.Seq, e.Kind, e.Err)
+	default:
+		return fmt.Sprintf("#%d %s", e.Seq, e.Kind)
+	}
+}
+
+// InvariantViolation describes a single violation found by an
+// InvariantChecker, along with every event that had been seen for the
+// service when it was found.
+type InvariantViolation struct {
+	Runner  int
+	Service *service.Service
+	Message string
+	History []InvariantEvent
+}
+
+// InvariantError is returned by InvariantChecker.Err() and Close() if any
+// violations were found. Only the first few violations are kept; Total
+// counts all of them.
+type InvariantError struct {
+	Violations []InvariantViolation
+	Total      int
+}
+
+func (e *InvariantError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "servicetest: %d invariant violation(s)", e.Total)
+	if e.Total > len(e.Violations) {
+		fmt.Fprintf(&b, ", showing the first %d", len(e.Violations))
+	}
+	b.WriteString(":\n")
+
+	for _, v := range e.Violations {
+		name := string(v.Service.Name)
+		if name == "" {
+			name = fmt.Sprintf("%p", v.Service)
+		}
+		fmt.Fprintf(&b, "- runner %d, service %q: %s\n", v.Runner, name, v.Message)
+		for _, ev := range v.History {
+			fmt.Fprintf(&b, "    %s\n", ev)
+		}
+	}
+	return b.String()
+}
+
+type invariantKey struct {
+	runner  int
+	service *service.Service
+}
+
+type invariantTrack struct {
+	last    service.State
+	runs    []*invariantRun
+	history []InvariantEvent
+}
+
+// run returns the current run, or nil if the service has never started.
+func (t *invariantTrack) run() *invariantRun {
+	if len(t.runs) == 0 {
+		return nil
+	}
+	return t.runs[len(t.runs)-1]
+}
+
+type invariantRun struct {
+	started  bool // The service has left the Starting state.
+	ended    bool
+	ends     int
+	readyErr error
+}
+
+type invariantCall struct {
+	service *service.Service
+	runs    int
+	running bool
+}
@@ -0,0 +1,391 @@
+package servicetest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	// DefaultLeakTimeout is how long VerifyNoLeaks and VerifyNoLeaksMain
+	// wait for goroutines to finish before they are considered leaked.
+	DefaultLeakTimeout = time.Second
+
+	leakMinBackoff = time.Millisecond
+	leakMaxBackoff = 100 * time.Millisecond
+)
+
+// Goroutines started with a function that has one of these prefixes belong
+// to the runtime or the test framework, not the code under test.
+var leakIgnoredEntries = []string{
+	"runtime.",
+	"runtime/",
+	"testing.",
+	"os/signal.",
+}
+
+type LeakOption func(lc *leakChecker)
+
+// LeakTimeout sets how long to wait for goroutines to finish before they are
+// considered leaked. The goroutines are checked repeatedly with an
+// increasing backoff until then.
+func LeakTimeout(d time.Duration) LeakOption {
+	return func(lc *leakChecker) { lc.timeout = d }
+}
+
+// IgnoreLeak ignores any goroutine with a call to fn in its stack. fn is the
+// fully qualified name of the function, as it appears in a stack trace, i.e.
+// "net/http.(*Server).Serve".
+func IgnoreLeak(fn string) LeakOption {
+	return func(lc *leakChecker) { lc.ignore = append(lc.ignore, fn) }
+}
+
+// VerifyNoLeaks takes a snapshot of the running goroutines, and returns a
+// function that fails tb if any goroutine started since the snapshot is still
+// running. Defer it at the start of a test:
+//
+//	func TestThing(t *testing.T) {
+//		defer servicetest.VerifyNoLeaks(t)()
+//		...
+//	}
+//
+// The leaked goroutines are reported grouped by the service that started
+// them, using the service.PprofLabel label the Runner sets, or by where they
+// were created if they don't belong to a service.
+//
+// Nothing is checked if tb has already failed, as failed tests routinely
+// leave things running. Tests that run in parallel will see each other's
+// goroutines, so don't use VerifyNoLeaks with t.Parallel().
+func VerifyNoLeaks(tb testing.TB, options ...LeakOption) func() {
+	lc := newLeakChecker(options)
+	return func() {
+		tb.Helper()
+		if tb.Failed() {
+			return
+		}
+		if leaked := lc.leaks(); len(leaked) > 0 {
+			tb.Error(formatLeaks(leaked))
+		}
+	}
+}
+
+// VerifyNoLeaksMain runs the tests in m, and if they pass, checks that they
+// did not leave any goroutines running. The leaked goroutines are written to
+// stderr in the same way as VerifyNoLeaks. It returns the exit code to pass
+// to os.Exit():
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(servicetest.VerifyNoLeaksMain(m))
+//	}
+func VerifyNoLeaksMain(m *testing.M, options ...LeakOption) int {
+	lc := newLeakChecker(options)
+	code := m.Run()
+	if code == 0 {
+		if leaked := lc.leaks(); len(leaked) > 0 {
+			fmt.Fprintln(os.Stderr, formatLeaks(leaked))
+			return 2
+		}
+	}
+	return code
+}
+
+type leakChecker struct {
+	before  map[int64]bool
+	timeout time.Duration
+	ignore  []string
+}
+
+func newLeakChecker(options []LeakOption) *leakChecker {
+	lc := &leakChecker{
+		before:  make(map[int64]bool),
+		timeout: DefaultLeakTimeout,
+	}
+	for _, o := range options {
+		o(lc)
+	}
+	for _, g := range goroutines() {
+		lc.before[g.id] = true
+	}
+	return lc
+}
+
+// leaks returns the goroutines that were started after the snapshot and are
+// still running. Goroutines may be tearing themselves down asynchronously,
+// so leaks retries with an increasing backoff until there are none or the
+// timeout expires.
+func (lc *leakChecker) leaks() []goroutine {
+	deadline := time.Now().Add(lc.timeout)
+	backoff := leakMinBackoff
+
+	for {
+		var leaked []goroutine
+		for _, g := range goroutines() {
+			if !lc.before[g.id] && !lc.ignored(g) {
+				leaked = append(leaked, g)
+			}
+		}
+		if len(leaked) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			labelGoroutines(leaked, goroutineProfile(1))
+			return leaked
+		}
+
+		time.Sleep(backoff)
+		if backoff < leakMaxBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+func (lc *leakChecker) ignored(g goroutine) bool {
+	for _, prefix := range leakIgnoredEntries {
+		if strings.HasPrefix(g.entry, prefix) {
+			return true
+		}
+	}
+	for _, fn := range lc.ignore {
+		for _, frame := range g.frames {
+			if frame == fn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goroutine is a goroutine parsed from a goroutine profile.
+type goroutine struct {
+	id      int64
+	labels  map[string]string
+	frames  []string // Functions in the stack, innermost first
+	entry   string   // The function the goroutine was started with
+	created string   // Where the goroutine was created, if known
+	stack   string
+}
+
+// site describes what a goroutine belongs to, which is used to group leaked
+// goroutines together.
+func (g goroutine) site() string {
+	if name, ok := g.labels[service.PprofLabel]; ok {
+		return fmt.Sprintf("service %q", name)
+	}
+	if g.created != "" {
+		return "created by " + g.created
+	}
+	return "started by " + g.entry
+}
+
+func goroutines() []goroutine {
+	return parseGoroutines(goroutineProfile(2))
+}
+
+func goroutineProfile(debug int) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, debug); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
+// labelGoroutines finds the labels of any goroutines in gs that were parsed
+// without them, using the goroutine profile with debug=1. That profile does
+// not identify goroutines, so they are matched by their stacks; if several
+// goroutines have the same stack, the labels are handed out in the order
+// they appear.
+func labelGoroutines(gs []goroutine, profile string) {
+	labels := make(map[string][]map[string]string)
+	for _, rec := range parseGoroutineRecords(profile) {
+		sig := stackSignature(rec.frames)
+		for i := 0; i < rec.count; i++ {
+			labels[sig] = append(labels[sig], rec.labels)
+		}
+	}
+
+	for i := range gs {
+		if gs[i].labels != nil {
+			continue
+		}
+		sig := stackSignature(gs[i].frames)
+		if candidates := labels[sig]; len(candidates) > 0 {
+			gs[i].labels, labels[sig] = candidates[0], candidates[1:]
+		}
+	}
+}
+
+// stackSignature identifies a stack by its functions. Runtime functions are
+// left out, as the goroutine profile with debug=2 hides them.
+func stackSignature(frames []string) string {
+	var sig []string
+	for _, frame := range frames {
+		if !strings.HasPrefix(frame, "runtime.") {
+			sig = append(sig, frame)
+		}
+	}
+	return strings.Join(sig, "\n")
+}
+
+type goroutineRecord struct {
+	count  int
+	labels map[string]string
+	frames []string
+}
+
+// parseGoroutineRecords parses the output of the goroutine profile with
+// debug=1, which groups goroutines with identical stacks and labels.
+func parseGoroutineRecords(dump string) (out []goroutineRecord) {
+	for _, block := range strings.Split(strings.TrimSpace(dump), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if strings.HasPrefix(lines[0], "goroutine profile:") {
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fields := strings.Fields(lines[0])
+		if len(fields) < 2 || fields[1] != "@" {
+			continue
+		}
+		count, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		rec := goroutineRecord{count: count}
+		for _, line := range lines[1:] {
+			if strings.HasPrefix(line, "# labels: ") {
+				labels := strings.TrimPrefix(line, "# labels: ")
+				rec.labels = parseGoroutineLabels(strings.TrimSuffix(strings.TrimPrefix(labels, "{"), "}"))
+				continue
+			}
+			// Frames look like "#\t0x4809c4\ttime.Sleep+0x164\t/path/to/time.go:368"
+			parts := strings.Split(line, "\t")
+			if len(parts) < 3 {
+				continue
+			}
+			fn := parts[2]
+			if idx := strings.LastIndex(fn, "+0x"); idx >= 0 {
+				fn = fn[:idx]
+			}
+			rec.frames = append(rec.frames, fn)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) \[[^\]]*\](?: \{(.*)\})?:$`)
+
+// parseGoroutines parses the output of the goroutine profile with debug=2.
+// Labels are only included by recent versions of Go, and only if the
+// tracebacklabels GODEBUG setting is enabled; see labelGoroutines.
+func parseGoroutines(dump string) (out []goroutine) {
+	for _, block := range strings.Split(strings.TrimSpace(dump), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		match := goroutineHeader.FindStringSubmatch(lines[0])
+		if match == nil {
+			continue
+		}
+		id, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		g := goroutine{id: id, labels: parseGoroutineLabels(match[2]), stack: block}
+		for i := 1; i < len(lines); i++ {
+			line := lines[i]
+			if strings.HasPrefix(line, "\t") {
+				continue
+			}
+			if strings.HasPrefix(line, "created by ") {
+				g.created = strings.TrimPrefix(line, "created by ")
+				if idx := strings.Index(g.created, " in goroutine "); idx >= 0 {
+					g.created = g.created[:idx]
+				}
+				if i+1 < len(lines) {
+					g.created += " at " + stackLocation(lines[i+1])
+				}
+				break
+			}
+			if idx := strings.LastIndex(line, "("); idx > 0 {
+				g.frames = append(g.frames, line[:idx])
+			}
+		}
+		if len(g.frames) > 0 {
+			g.entry = g.frames[len(g.frames)-1]
+		}
+		out = append(out, g)
+	}
+	return out
+}
+
+// parseGoroutineLabels parses labels formatted like '"k1":"v1", "k2":"v2"' or
+// 'k1: v1, k2: v2', depending on the version of Go.
+func parseGoroutineLabels(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ", ") {
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		labels[unquoteLabel(parts[0])] = unquoteLabel(parts[1])
+	}
+	return labels
+}
+
+func unquoteLabel(s string) string {
+	s = strings.TrimSpace(s)
+	if uq, err := strconv.Unquote(s); err == nil {
+		return uq
+	}
+	return s
+}
+
+// stackLocation trims the tab and program counter offset from the file and
+// line of a stack frame.
+func stackLocation(line string) string {
+	line = strings.TrimSpace(line)
+	if idx := strings.LastIndex(line, " +0x"); idx >= 0 {
+		line = line[:idx]
+	}
+	return line
+}
+
+func formatLeaks(leaked []goroutine) string {
+	groups := make(map[string][]goroutine)
+	var sites []string
+	for _, g := range leaked {
+		site := g.site()
+		if _, ok := groups[site]; !ok {
+			sites = append(sites, site)
+		}
+		groups[site] = append(groups[site], g)
+	}
+	sort.Strings(sites)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d leaked goroutine(s) in %d group(s):\n", len(leaked), len(sites))
+	for _, site := range sites {
+		gs := groups[site]
+		sort.Slice(gs, func(i, j int) bool { return gs[i].id < gs[j].id })
+
+		fmt.Fprintf(&buf, "\n%s: %d goroutine(s)\n", site, len(gs))
+		for _, g := range gs {
+			fmt.Fprintf(&buf, "\n%s\n", g.stack)
+		}
+	}
+	return buf.String()
+}
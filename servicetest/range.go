@@ -10,8 +10,8 @@ type TimeRange struct {
 	Max time.Duration
 }
 
-func (t TimeRange) Rand() time.Duration {
-	return randDuration(t.Min, t.Max)
+func (t TimeRange) Rand(rng *rand.Rand) time.Duration {
+	return randDuration(rng, t.Min, t.Max)
 }
 
 type TimeRangeMaker struct {
@@ -19,8 +19,8 @@ type TimeRangeMaker struct {
 	Max TimeRange
 }
 
-func (t TimeRangeMaker) Rand() TimeRange {
-	return TimeRange{Min: t.Min.Rand(), Max: t.Max.Rand()}
+func (t TimeRangeMaker) Rand(rng *rand.Rand) TimeRange {
+	return TimeRange{Min: t.Min.Rand(rng), Max: t.Max.Rand(rng)}
 }
 
 type IntRange struct {
@@ -28,8 +28,8 @@ type IntRange struct {
 	Max int
 }
 
-func (t IntRange) Rand() int {
-	return rand.Intn((t.Max+1)-t.Min) + t.Min
+func (t IntRange) Rand(rng *rand.Rand) int {
+	return rng.Intn((t.Max+1)-t.Min) + t.Min
 }
 
 type IntRangeMaker struct {
@@ -37,8 +37,8 @@ type IntRangeMaker struct {
 	Max IntRange
 }
 
-func (t IntRangeMaker) Rand() IntRange {
-	return IntRange{Min: t.Min.Rand(), Max: t.Max.Rand()}
+func (t IntRangeMaker) Rand(rng *rand.Rand) IntRange {
+	return IntRange{Min: t.Min.Rand(rng), Max: t.Max.Rand(rng)}
 }
 
 type FloatRange struct {
@@ -46,8 +46,8 @@ type FloatRange struct {
 	Max float64
 }
 
-func (t FloatRange) Rand() float64 {
-	f := rand.Float64()
+func (t FloatRange) Rand(rng *rand.Rand) float64 {
+	f := rng.Float64()
 	top := (t.Max - t.Min) * f
 	return top + t.Min
 }
@@ -57,26 +57,26 @@ type FloatRangeMaker struct {
 	Max FloatRange
 }
 
-func (t FloatRangeMaker) Rand() FloatRange {
-	return FloatRange{Min: t.Min.Rand(), Max: t.Max.Rand()}
+func (t FloatRangeMaker) Rand(rng *rand.Rand) FloatRange {
+	return FloatRange{Min: t.Min.Rand(rng), Max: t.Max.Rand(rng)}
 }
 
-func should(chance float64) bool {
+func should(rng *rand.Rand, chance float64) bool {
 	if chance <= 0 {
 		return false
 	} else if chance >= 1 {
 		return true
 	}
 	max := uint64(1000000)
-	next := float64(rand.Uint64() % max)
+	next := float64(rng.Uint64() % max)
 	return next < (chance * float64(max))
 }
 
-func randDuration(min, max time.Duration) time.Duration {
+func randDuration(rng *rand.Rand, min, max time.Duration) time.Duration {
 	if min == 0 && max == 0 {
 		return 0
 	} else if min == max {
 		return min
 	}
-	return time.Duration(rand.Int63n(int64(max)-int64(min))) + min
+	return time.Duration(rng.Int63n(int64(max)-int64(min))) + min
 }
@@ -2,9 +2,13 @@ package servicetest
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 	"time"
 
@@ -12,8 +16,26 @@ import (
 	"github.com/shabbyrobe/go-service/internal/assert"
 )
 
+const (
+	DefaultFuzzRunnerLimit  = 20000
+	DefaultFuzzServiceLimit = 100000
+
+	DefaultFuzzTimeTolerance = 50 * time.Millisecond
+)
+
+// RunnerFuzzer randomly starts and halts runners and services until Duration
+// has elapsed.
+//
+// Every random decision the fuzzer makes is drawn from a *rand.Rand seeded
+// with Seed, so a run can be reproduced from its JSON encoding (see
+// ReplayInfo()), at least as far as the scheduler allows.
 type RunnerFuzzer struct {
-	RunnerSource RunnerSource
+	RunnerSource RunnerSource `json:"-"`
+
+	Seed int64
+
+	// Only create and halt runners; do not start any services.
+	DisableServices bool `json:",omitempty"`
 
 	Duration     time.Duration
 	Tick         time.Duration
@@ -57,40 +79,198 @@ type RunnerFuzzer struct {
 	ServiceHaltTimeout TimeRange
 	StateCheckChance   float64
 
+	// How far past the bounds implied by the times above a start, run or halt
+	// may take before it is counted in FuzzServiceStats.TimeBounds. Defaults to
+	// DefaultFuzzTimeTolerance.
+	TimeTolerance time.Duration `json:",omitempty"`
+
 	Stats *FuzzStats `json:"-"`
 
-	runners []service.Runner `json:"-"`
-	wg      *condGroup       `json:"-"`
+	// If not nil, every operation and outcome is recorded here. See
+	// FuzzTrace.
+	Trace *FuzzTrace `json:"-"`
+
+	// If not nil, every runner is watched by the checker. The caller must
+	// Close() it after Run() returns.
+	Invariants *InvariantChecker `json:"-"`
+
+	runners      []fuzzRunner
+	createdFirst bool
+	nextRunner   int
+	nextService  int
+	wg           *condGroup
+	rng          *rand.Rand
 }
 
 var (
 	errStartFailure = errors.New("start failure")
 	errRunFailure   = errors.New("run failure")
+
+	// These are counted in FuzzServiceStats.TimeBounds:
+	errStartTooFast = errors.New("start returned before StartDelay")
+	errStartTooSlow = errors.New("start took longer than its timeout")
+	errRunTooShort  = errors.New("run ended by itself before RunTime")
+	errRunTooLong   = errors.New("run took longer than RunTime+HaltDelay")
+	errHaltTooSlow  = errors.New("halt took longer than its timeout")
 )
 
+type fuzzRunner struct {
+	id     int
+	runner service.Runner
+}
+
 func (r *RunnerFuzzer) startRunner() {
 	if !r.RunnerSource.CanCreateRunner() {
 		return
 	}
-
-	runner := r.RunnerSource.CreateRunner(r)
-	r.Stats.AddRunnersCurrent(1)
-	r.Stats.AddRunnersStarted(1)
-	r.runners = append(r.runners, runner)
+	r.nextRunner++
+	r.exec(FuzzOp{Kind: FuzzCreateRunner, Runner: r.nextRunner})
 }
 
 func (r *RunnerFuzzer) haltRunner() {
 	if !r.RunnerSource.CanHaltRunner() {
 		return
 	}
+	r.exec(FuzzOp{
+		Kind:    FuzzHaltRunner,
+		Runner:  r.randomRunner(),
+		Timeout: r.ServiceHaltTimeout.Rand(r.rng),
+	})
+}
+
+func (r *RunnerFuzzer) haltService() {
+	r.exec(FuzzOp{
+		Kind:    FuzzHaltService,
+		Runner:  r.randomRunner(),
+		Timeout: r.ServiceHaltTimeout.Rand(r.rng),
+	})
+}
+
+func (r *RunnerFuzzer) checkState() {
+	r.exec(FuzzOp{Kind: FuzzCheckState, Runner: r.randomRunner()})
+}
+
+func (r *RunnerFuzzer) restartService() {
+	// FIXME: this does not work yet.
+	return
+
+	r.exec(FuzzOp{
+		Kind:        FuzzRestartService,
+		Runner:      r.randomRunner(),
+		HaltTimeout: r.ServiceHaltTimeout.Rand(r.rng),
+		Timeout:     r.StartWaitTimeout.Rand(r.rng),
+	})
+}
+
+func (r *RunnerFuzzer) createService() {
+	r.nextService++
+	op := FuzzOp{
+		Kind:       FuzzStartService,
+		Service:    r.nextService,
+		StartDelay: r.ServiceStartTime.Rand(r.rng),
+		RunTime:    r.ServiceRunTime.Rand(r.rng),
+		HaltDelay:  r.ServiceHaltDelay.Rand(r.rng),
+		StartLimit: 1,
+	}
+
+	if should(r.rng, r.ServiceRestartableChance) {
+		op.StartLimit = 0
+	}
+
+	if should(r.rng, r.ServiceStartFailureChance) {
+		op.StartFailure = true
+	} else if should(r.rng, r.ServiceRunFailureChance) {
+		op.RunFailure = true
+	}
+
+	op.Runner = r.randomRunner()
+	op.Timeout = r.StartWaitTimeout.Rand(r.rng)
+	op.HaltAfter = r.ServiceHaltAfter.Rand(r.rng)
+	op.HaltTimeout = r.ServiceHaltTimeout.Rand(r.rng)
+	r.exec(op)
+}
+
+func (r *RunnerFuzzer) randomRunner() int {
+	return r.runners[r.rng.Intn(len(r.runners))].id
+}
+
+func (r *RunnerFuzzer) findRunner(id int) (idx int, runner service.Runner) {
+	for i, fr := range r.runners {
+		if fr.id == id {
+			return i, fr.runner
+		}
+	}
+	return -1, nil
+}
+
+// exec issues op, recording it and its outcome in r.Trace. Operations that
+// refer to a runner that does not exist are ignored; this only happens when
+// replaying a trace that has had operations removed.
+//
+// exec must only be called from the goroutine driving the fuzzer.
+func (r *RunnerFuzzer) exec(op FuzzOp) {
+	if op.Kind == FuzzCreateRunner {
+		r.execCreateRunner(op)
+		return
+	}
 
-	idx := rand.Intn(r.Stats.GetRunnersCurrent())
-	runner := r.runners[idx]
+	idx, runner := r.findRunner(op.Runner)
+	if runner == nil {
+		return
+	}
+	op.At = r.Trace.since()
+	r.Trace.addOp(op)
+
+	switch op.Kind {
+	case FuzzHaltRunner:
+		r.execHaltRunner(op, idx, runner)
+	case FuzzStartService:
+		r.execStartService(op, runner)
+	case FuzzHaltService:
+		r.execHaltService(op, runner)
+	case FuzzRestartService:
+		r.execRestartService(op, runner)
+	case FuzzCheckState:
+		r.execCheckState(op, runner)
+	default:
+		panic(fmt.Errorf("servicetest: unknown fuzz op %s", op.Kind))
+	}
+}
 
+func (r *RunnerFuzzer) execCreateRunner(op FuzzOp) {
+	if _, rn := r.findRunner(op.Runner); rn != nil {
+		return
+	}
+	op.At = r.Trace.since()
+	r.Trace.addOp(op)
+
+	var l Listener = r
+	var il *InvariantListener
+	if r.Invariants != nil {
+		il = r.Invariants.Listener(r)
+		l = il
+	}
+
+	var runner service.Runner
+	if !r.createdFirst {
+		runner = r.RunnerSource.FirstRunner(l)
+		r.createdFirst = true
+	} else {
+		runner = r.RunnerSource.CreateRunner(l)
+	}
+	if il != nil {
+		runner = il.Runner(runner)
+	}
+	r.Stats.AddRunnersCurrent(1)
+	r.Stats.AddRunnersStarted(1)
+	r.runners = append(r.runners, fuzzRunner{id: op.Runner, runner: runner})
+}
+
+func (r *RunnerFuzzer) execHaltRunner(op FuzzOp, idx int, runner service.Runner) {
 	// delete runner before we go off and halt it so we can keep the runners
 	// list single threaded
 	last := len(r.runners) - 1
-	r.runners[idx], r.runners[last] = r.runners[last], nil
+	r.runners[idx], r.runners[last] = r.runners[last], fuzzRunner{}
 	r.runners = r.runners[:last]
 	r.Stats.AddRunnersCurrent(-1)
 
@@ -99,162 +279,206 @@ func (r *RunnerFuzzer) haltRunner() {
 		defer r.wg.Done()
 
 		// this can take a while so make sure it's done in a goroutine
-		ctx, cancel := context.WithTimeout(context.Background(), r.ServiceHaltTimeout.Rand())
+		ctx, cancel := context.WithTimeout(context.Background(), op.Timeout)
 		defer cancel()
-		if err := runner.Shutdown(ctx); err != nil {
+		err := runner.Shutdown(ctx)
+		r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Err: errString(err)})
+		if err != nil {
 			// FIXME: accumulate as stats:
-			panic(err)
+			panic(fmt.Errorf("%v\n%s", err, r.ReplayInfo()))
 		}
 		r.Stats.AddRunnersHalted(1)
 	}()
 }
 
-func (r *RunnerFuzzer) haltService() {
-	idx := rand.Intn(r.Stats.GetRunnersCurrent())
-	rn := r.runners[idx]
-
+func (r *RunnerFuzzer) execHaltService(op FuzzOp, runner service.Runner) {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
 
-		svc := randomService(rn)
+		svc := randomService(runner)
 		if svc != nil {
-			err := service.HaltTimeout(r.ServiceHaltTimeout.Rand(), rn, svc)
+			err := r.timedHalt(runner, svc, op.Timeout)
 			r.Stats.Service.ServiceHalt.Add(err)
+			r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Service: r.Trace.serviceID(svc), Err: errString(err)})
 		}
 	}()
 }
 
-func (r *RunnerFuzzer) checkState() {
-	idx := rand.Intn(r.Stats.GetRunnersCurrent())
-	rn := r.runners[idx]
-
+func (r *RunnerFuzzer) execCheckState(op FuzzOp, runner service.Runner) {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
 
-		svc := randomService(rn)
+		svc := randomService(runner)
 		if svc != nil {
-			state := rn.State(svc)
+			state := runner.State(svc)
 			r.Stats.AddStateCheckResult(state)
+			r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Service: r.Trace.serviceID(svc), State: state})
 		}
 	}()
 }
 
-func (r *RunnerFuzzer) restartService() {
-	// FIXME: this does not work yet.
-	return
-
-	idx := rand.Intn(r.Stats.GetRunnersCurrent())
-	rn := r.runners[idx]
-	if rn == nil {
-		return
-	}
-
+func (r *RunnerFuzzer) execRestartService(op FuzzOp, runner service.Runner) {
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
 
-		svc := randomService(rn)
-		if svc != nil {
-			if err := service.HaltTimeout(r.ServiceHaltTimeout.Rand(), rn, svc); err != nil {
-				r.Stats.Service.ServiceRestart.Add(err)
+		svc := randomService(runner)
+		if svc == nil {
+			return
+		}
+		id := r.Trace.serviceID(svc)
 
-			} else {
-				err := service.StartTimeout(r.StartWaitTimeout.Rand(), rn, svc)
+		if err := r.timedHalt(runner, svc, op.HaltTimeout); err != nil {
+			r.Stats.Service.ServiceRestart.Add(err)
+			r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Service: id, Err: errString(err)})
 
-				// FIXME: the fuzzer should get its stats from the state listener,
-				// not from the ended listener. These hacks are all in here because
-				// the fuzzer can only dynamically react to a service ending, but
-				// not when a service is starting.
-				if err == nil {
-					r.Stats.AddServicesCurrent(1)
-				}
+		} else {
+			err := r.timedStart(runner, svc, op.Timeout)
 
-				r.Stats.Service.ServiceRestart.Add(err)
+			// FIXME: the fuzzer should get its stats from the state listener,
+			// not from the ended listener. These hacks are all in here because
+			// the fuzzer can only dynamically react to a service ending, but
+			// not when a service is starting.
+			if err == nil {
+				r.Stats.AddServicesCurrent(1)
 			}
+
+			r.Stats.Service.ServiceRestart.Add(err)
+			r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Service: id, Err: errString(err)})
 		}
 	}()
 }
 
-func (r *RunnerFuzzer) createService() {
-	service := (&TimedService{
-		StartDelay: r.ServiceStartTime.Rand(),
-		RunTime:    r.ServiceRunTime.Rand(),
-		HaltDelay:  r.ServiceHaltDelay.Rand(),
+func (r *RunnerFuzzer) execStartService(op FuzzOp, runner service.Runner) {
+	ts := (&TimedService{
+		StartDelay: op.StartDelay,
+		RunTime:    op.RunTime,
+		HaltDelay:  op.HaltDelay,
+		StartLimit: op.StartLimit,
 	}).Init()
-
-	service.StartLimit = 1
-	if should(r.ServiceRestartableChance) {
-		service.StartLimit = 0
+	if op.StartFailure {
+		ts.StartFailure = errStartFailure
+	} else if op.RunFailure {
+		ts.RunFailure = errRunFailure
 	}
 
-	if should(r.ServiceStartFailureChance) {
-		service.StartFailure = errStartFailure
-	} else if should(r.ServiceRunFailureChance) {
-		service.RunFailure = errRunFailure
+	stats := r.Stats.Service
+	ts.RunEnded = func(ran time.Duration, halted bool) {
+		stats.RunDuration.Add(ran)
+		if !halted && ran < op.RunTime {
+			stats.TimeBounds.Add(errRunTooShort)
+		} else if ran > op.RunTime+op.HaltDelay+r.TimeTolerance {
+			stats.TimeBounds.Add(errRunTooLong)
+		} else {
+			stats.TimeBounds.Add(nil)
+		}
 	}
-	r.runService(service, r.Stats.Service)
-}
-
-func (r *RunnerFuzzer) runService(runnable service.Runnable, stats *FuzzServiceStats) {
-	runner := r.runners[rand.Intn(r.Stats.GetRunnersCurrent())]
 
 	svc := &service.Service{
-		Runnable: runnable,
+		Runnable: ts,
 	}
+	r.Trace.addService(svc, op.Service)
 
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
 
-		err := service.StartTimeout(r.StartWaitTimeout.Rand(), runner, svc)
+		err := r.timedStart(runner, svc, op.Timeout)
 
 		r.Stats.AddServicesCurrent(1)
 
 		stats.ServiceStart.Add(err)
+		r.Trace.addOutcome(FuzzOutcome{Kind: op.Kind, Runner: op.Runner, Service: op.Service, Err: errString(err)})
 
 		r.wg.Add(1)
-		time.AfterFunc(r.ServiceHaltAfter.Rand(), func() {
+		time.AfterFunc(op.HaltAfter, func() {
 			defer r.wg.Done()
 
-			err := service.HaltTimeout(r.ServiceHaltTimeout.Rand(), runner, svc)
+			err := r.timedHalt(runner, svc, op.HaltTimeout)
 			stats.ServiceHalt.Add(err)
+			r.Trace.addOutcome(FuzzOutcome{Kind: FuzzHaltService, Runner: op.Runner, Service: op.Service, Err: errString(err)})
 		})
 	}()
 }
 
+// timedStart starts svc, recording how long it took and whether that was
+// within the bounds implied by timeout and the service's StartDelay.
+func (r *RunnerFuzzer) timedStart(runner service.Runner, svc *service.Service, timeout time.Duration) error {
+	start := time.Now()
+	err := service.StartTimeout(timeout, runner, svc)
+	took := time.Since(start)
+
+	stats := r.Stats.Service
+	if err == nil {
+		stats.StartLatency.Add(took)
+	}
+
+	var minTook time.Duration
+	if ts, ok := svc.Runnable.(*TimedService); ok {
+		minTook = ts.StartDelay
+	}
+	if err == nil && took < minTook {
+		stats.TimeBounds.Add(errStartTooFast)
+	} else if took > timeout+r.TimeTolerance {
+		stats.TimeBounds.Add(errStartTooSlow)
+	} else {
+		stats.TimeBounds.Add(nil)
+	}
+	return err
+}
+
+// timedHalt halts svc, recording how long it took and whether that was
+// within timeout.
+func (r *RunnerFuzzer) timedHalt(runner service.Runner, svc *service.Service, timeout time.Duration) error {
+	start := time.Now()
+	err := service.HaltTimeout(timeout, runner, svc)
+	took := time.Since(start)
+
+	stats := r.Stats.Service
+	if err == nil {
+		stats.HaltLatency.Add(took)
+	}
+	if took > timeout+r.TimeTolerance {
+		stats.TimeBounds.Add(errHaltTooSlow)
+	} else {
+		stats.TimeBounds.Add(nil)
+	}
+	return err
+}
+
 func (r *RunnerFuzzer) doTick() {
 	// maybe halt a runnner, but never if it's the last.
 	rcur := r.Stats.GetRunnersCurrent()
-	if rcur > 1 && should(r.RunnerHaltChance) {
+	if rcur > 1 && should(r.rng, r.RunnerHaltChance) {
 		r.haltRunner()
 	}
 
 	// maybe start a runner
-	if rcur == 0 || (should(r.RunnerCreateChance)) && rcur < r.RunnerLimit {
+	if rcur == 0 || (should(r.rng, r.RunnerCreateChance)) && rcur < r.RunnerLimit {
 		r.startRunner()
 	}
 
 	// maybe start a service into one of the existing runners, chosen
 	// at random
 	scur := r.Stats.GetServicesCurrent()
-	if fuzzServices && should(r.ServiceCreateChance) && scur < r.ServiceLimit {
+	if !r.DisableServices && should(r.rng, r.ServiceCreateChance) && scur < r.ServiceLimit {
 		r.createService()
 	}
 
 	// maybe try halt a random service, regardless of its current state
-	if fuzzServices && should(r.ServiceHaltChance) {
+	if !r.DisableServices && should(r.rng, r.ServiceHaltChance) {
 		r.haltService()
 	}
 
 	// maybe check the state of a randomly chosen service
-	if should(r.StateCheckChance) {
+	if should(r.rng, r.StateCheckChance) {
 		r.checkState()
 	}
 
 	// maybe restart a random service
-	if should(r.ServiceRestartChance) {
+	if should(r.rng, r.ServiceRestartChance) {
 		r.restartService()
 	}
 
@@ -263,14 +487,31 @@ func (r *RunnerFuzzer) doTick() {
 
 func (r *RunnerFuzzer) Init(tt assert.T) {
 	tt.Helper()
+	r.init()
+	r.startRunner()
+}
+
+func (r *RunnerFuzzer) init() {
 	if r.RunnerSource == nil {
 		r.RunnerSource = &ServiceRunnerSource{}
 	}
-
-	runner := r.RunnerSource.FirstRunner(r)
-	r.Stats.AddRunnersCurrent(1)
-	r.Stats.AddRunnersStarted(1)
-	r.runners = append(r.runners, runner)
+	if r.RunnerLimit == 0 {
+		r.RunnerLimit = DefaultFuzzRunnerLimit
+	}
+	if r.ServiceLimit == 0 {
+		r.ServiceLimit = DefaultFuzzServiceLimit
+	}
+	if r.TimeTolerance == 0 {
+		r.TimeTolerance = DefaultFuzzTimeTolerance
+	}
+	r.rng = rand.New(rand.NewSource(r.Seed))
+	if r.Stats != nil {
+		r.Stats.Seed = r.Seed
+	}
+	r.runners, r.createdFirst = nil, false
+	r.nextRunner, r.nextService = 0, 0
+	r.wg = newCondGroup()
+	r.Trace.begin()
 }
 
 func (r *RunnerFuzzer) Run(tt assert.T) {
@@ -282,14 +523,17 @@ func (r *RunnerFuzzer) Run(tt assert.T) {
 	r.Init(tt)
 	defer r.RunnerSource.End()
 
-	r.wg = newCondGroup()
-
 	if r.Tick == 0 {
 		r.hotLoop()
 	} else {
 		r.tickLoop()
 	}
+	r.finish()
+}
 
+// finish waits for the operations in progress to complete, then shuts down
+// all of the runners.
+func (r *RunnerFuzzer) finish() {
 	wait := r.ServiceHaltTimeout.Max
 	if r.ServiceRunTime.Max > wait {
 		wait = r.ServiceRunTime.Max
@@ -322,8 +566,8 @@ func (r *RunnerFuzzer) Run(tt assert.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 
-		for _, rn := range r.runners {
-			_ = rn.Shutdown(ctx)
+		for _, fr := range r.runners {
+			_ = fr.runner.Shutdown(ctx)
 		}
 	}
 
@@ -347,6 +591,7 @@ func (r *RunnerFuzzer) OnServiceEnd() service.OnEnd {
 		s.AddServiceEnd(err)
 
 		r.Stats.AddServicesCurrent(-1)
+		r.Trace.addOutcome(FuzzOutcome{Kind: FuzzServiceEnded, Service: r.Trace.serviceID(service), Err: errString(err)})
 	}
 }
 
@@ -357,6 +602,23 @@ func (r *RunnerFuzzer) hotLoop() {
 	}
 }
 
+func (r *RunnerFuzzer) tickLoop() {
+	tick := time.NewTicker(r.Tick)
+	defer tick.Stop()
+	end := time.After(r.Duration)
+
+	for {
+		select {
+		case <-tick.C:
+			r.doTick()
+		case <-end:
+			return
+		}
+	}
+}
+
+// RunnerFuzzerBuilder creates RunnerFuzzers with parameters chosen at random
+// from its ranges.
 type RunnerFuzzerBuilder struct {
 	StateCheckChance FloatRange
 
@@ -375,26 +637,89 @@ type RunnerFuzzerBuilder struct {
 	ServiceHaltAfter   TimeRangeMaker
 	ServiceHaltDelay   TimeRangeMaker
 	ServiceHaltTimeout TimeRangeMaker
+
+	// Limits passed through to each RunnerFuzzer. If zero,
+	// DefaultFuzzRunnerLimit and DefaultFuzzServiceLimit are used.
+	RunnerLimit  int
+	ServiceLimit int
 }
 
-func (f RunnerFuzzerBuilder) Next(dur time.Duration) *RunnerFuzzer {
+// Next draws the parameters for a RunnerFuzzer from rng. The RunnerFuzzer is
+// given its own seed, also drawn from rng, so that it can be replayed
+// independently of the builder.
+func (f RunnerFuzzerBuilder) Next(rng *rand.Rand, dur time.Duration) *RunnerFuzzer {
+	runnerLimit, serviceLimit := f.RunnerLimit, f.ServiceLimit
+	if runnerLimit == 0 {
+		runnerLimit = DefaultFuzzRunnerLimit
+	}
+	if serviceLimit == 0 {
+		serviceLimit = DefaultFuzzServiceLimit
+	}
 	return &RunnerFuzzer{
+		Seed:                      rng.Int63(),
 		Duration:                  dur,
-		RunnerCreateChance:        f.RunnerCreateChance.Rand(),
-		RunnerHaltChance:          f.RunnerHaltChance.Rand(),
-		RunnerLimit:               fuzzDefaultRunnerLimit,
-		ServiceCreateChance:       f.ServiceCreateChance.Rand(),
-		ServiceHaltAfter:          f.ServiceHaltAfter.Rand(),
-		ServiceHaltDelay:          f.ServiceHaltDelay.Rand(),
-		ServiceHaltTimeout:        f.ServiceHaltTimeout.Rand(),
-		ServiceLimit:              fuzzDefaultServiceLimit,
-		ServiceRunFailureChance:   f.ServiceRunFailureChance.Rand(),
-		ServiceRunTime:            f.ServiceRunTime.Rand(),
-		ServiceStartFailureChance: f.ServiceStartFailureChance.Rand(),
-		ServiceStartTime:          f.ServiceStartTime.Rand(),
-		StartWaitTimeout:          f.StartWaitTimeout.Rand(),
-		StateCheckChance:          f.StateCheckChance.Rand(),
+		RunnerCreateChance:        f.RunnerCreateChance.Rand(rng),
+		RunnerHaltChance:          f.RunnerHaltChance.Rand(rng),
+		RunnerLimit:               runnerLimit,
+		ServiceCreateChance:       f.ServiceCreateChance.Rand(rng),
+		ServiceHaltAfter:          f.ServiceHaltAfter.Rand(rng),
+		ServiceHaltDelay:          f.ServiceHaltDelay.Rand(rng),
+		ServiceHaltTimeout:        f.ServiceHaltTimeout.Rand(rng),
+		ServiceLimit:              serviceLimit,
+		ServiceRunFailureChance:   f.ServiceRunFailureChance.Rand(rng),
+		ServiceRunTime:            f.ServiceRunTime.Rand(rng),
+		ServiceStartFailureChance: f.ServiceStartFailureChance.Rand(rng),
+		ServiceStartTime:          f.ServiceStartTime.Rand(rng),
+		StartWaitTimeout:          f.StartWaitTimeout.Rand(rng),
+		StateCheckChance:          f.StateCheckChance.Rand(rng),
+	}
+}
+
+// LoadRunnerFuzzer reads a RunnerFuzzer's parameters from a JSON file, as
+// written by WriteReplay().
+func LoadRunnerFuzzer(file string) (*RunnerFuzzer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fz RunnerFuzzer
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fz); err != nil {
+		return nil, fmt.Errorf("servicetest: could not load fuzzer from %q: %v", file, err)
+	}
+	return &fz, nil
+}
+
+// WriteReplay writes the fuzzer's parameters as indented JSON to a file in
+// dir, which can be passed to LoadRunnerFuzzer() or the -service.fuzz.replay
+// test flag to run the same configuration again. If dir is empty,
+// os.TempDir() is used.
+func (r *RunnerFuzzer) WriteReplay(dir string) (file string, err error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file = filepath.Join(dir, fmt.Sprintf("go-service-fuzz-%d.json", r.Seed))
+	bts, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, append(bts, '\n'), 0644)
+}
+
+// ReplayInfo describes how to reproduce this fuzzer's run, including its
+// parameters in a form that can be pasted into a replay file.
+func (r *RunnerFuzzer) ReplayInfo() string {
+	bts, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("fuzz seed: %d (parameters could not be encoded: %v)", r.Seed, err)
 	}
+	return fmt.Sprintf("fuzz seed: %d\n"+
+		"save the parameters below to a file and run:\n"+
+		"  go test -run TestRunnerFuzzReplay -service.fuzz -service.fuzz.replay=<file>\n%s",
+		r.Seed, bts)
 }
 
 func randomService(rr service.Runner) *service.Service {
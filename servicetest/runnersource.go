@@ -7,6 +7,14 @@ type Listener interface {
 	OnServiceError() service.OnError
 }
 
+// StateListener is a Listener that also wants to receive StateChanges. A
+// RunnerSource should pass the channel to the Runners it creates with
+// service.RunnerOnState().
+type StateListener interface {
+	Listener
+	OnServiceState() chan<- service.StateChange
+}
+
 type RunnerSource interface {
 	CanHaltRunner() bool
 	CanCreateRunner() bool
@@ -40,6 +48,9 @@ func (srs *ServiceRunnerSource) newRunner(l Listener) service.Runner {
 	if onError != nil {
 		opts = append(opts, service.RunnerOnError(onError))
 	}
+	if sl, ok := l.(StateListener); ok {
+		opts = append(opts, service.RunnerOnState(sl.OnServiceState()))
+	}
 	return service.NewRunner(opts...)
 }
 
@@ -0,0 +1,219 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+	"github.com/shabbyrobe/go-service/serviceutil"
+)
+
+func awaitSleepers(tt assert.T, fc *FakeClock, n int) {
+	tt.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), dto)
+	defer cancel()
+	tt.MustOK(fc.BlockUntilSleepers(ctx, n))
+}
+
+func TestFakeClockTimers(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	start := fc.Now()
+
+	t1 := fc.NewTimer(2 * time.Second)
+	t2 := fc.NewTicker(1 * time.Second)
+	tt.MustEqual(2, fc.Sleepers())
+
+	fc.Advance(1 * time.Second)
+	tt.MustEqual(start.Add(1*time.Second), <-t2.C())
+	assertNoTime(tt, t1.C())
+
+	fc.Advance(1500 * time.Millisecond)
+	tt.MustEqual(start.Add(2*time.Second), <-t1.C())
+	tt.MustEqual(start.Add(2*time.Second), <-t2.C())
+	tt.MustEqual(start.Add(2500*time.Millisecond), fc.Now())
+
+	// The timer has fired; the ticker is still going:
+	tt.MustEqual(1, fc.Sleepers())
+	tt.MustAssert(!t1.Stop())
+	t2.Stop()
+	tt.MustEqual(0, fc.Sleepers())
+
+	tt.MustAssert(!t1.Reset(time.Second))
+	fc.Advance(time.Second)
+	tt.MustEqual(start.Add(3500*time.Millisecond), <-t1.C())
+}
+
+func TestFakeClockTimerDue(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	start := fc.Now()
+
+	// Timers that are already due fire without waiting for Advance():
+	t1 := fc.NewTimer(0)
+	tt.MustEqual(start, <-t1.C())
+	t2 := fc.NewTimer(-time.Second)
+	tt.MustEqual(start, <-t2.C())
+	tt.MustEqual(0, fc.Sleepers())
+
+	tt.MustAssert(!t1.Reset(0))
+	tt.MustEqual(start, <-t1.C())
+	tt.MustAssert(!t1.Stop())
+}
+
+func TestFakeClockTimerCreatedDuringAdvance(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	start := fc.Now()
+
+	t1 := fc.NewTimer(time.Second)
+	next := make(chan service.Timer)
+	go func() {
+		<-t1.C()
+		next <- fc.NewTimer(time.Second)
+	}()
+
+	// The second timer would be due at 2s if it was created when the first
+	// one fired, but it is created after Advance() returns, at 3s:
+	fc.Advance(3 * time.Second)
+	t2 := <-next
+	assertNoTime(tt, t2.C())
+	fc.Advance(time.Second)
+	tt.MustEqual(start.Add(4*time.Second), <-t2.C())
+}
+
+func TestFakeClockBlockUntilSleepersTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	ctx, cancel := context.WithTimeout(context.Background(), tscale)
+	defer cancel()
+	tt.MustEqual(context.DeadlineExceeded, fc.BlockUntilSleepers(ctx, 1))
+}
+
+func assertNoTime(tt assert.T, c <-chan time.Time) {
+	tt.Helper()
+	select {
+	case v := <-c:
+		tt.Fatal("unexpected tick", v)
+	default:
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	r := service.NewRunner(service.RunnerClock(fc))
+	defer service.MustShutdownTimeout(dto, r)
+
+	slept := make(chan bool, 1)
+	svc := service.New("", service.RunnableFunc(func(ctx service.Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		slept <- service.Sleep(ctx, time.Hour)
+		<-ctx.Done()
+		return nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dto)
+	defer cancel()
+	tt.MustOK(r.Start(ctx, svc))
+
+	awaitSleepers(tt, fc, 1)
+	fc.Advance(time.Hour)
+	tt.MustEqual(false, <-slept)
+	tt.MustOK(service.HaltTimeout(dto, r, svc))
+}
+
+func TestFakeClockStartTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	r := service.NewRunner(service.RunnerClock(fc))
+
+	svc := service.New("", service.RunnableFunc(func(ctx service.Context) error {
+		<-ctx.Done()
+		return nil
+	}))
+
+	result := make(chan error, 1)
+	go func() { result <- service.StartTimeout(time.Hour, r, svc) }()
+
+	awaitSleepers(tt, fc, 1)
+	fc.Advance(time.Hour - 1)
+	select {
+	case err := <-result:
+		tt.Fatal("start returned early", err)
+	case <-time.After(tscale):
+	}
+
+	fc.Advance(1)
+	err := <-result
+	tt.MustAssert(service.IsTimeout(err), err)
+	tt.MustEqual(context.DeadlineExceeded, err.(*service.TimeoutError).Err)
+}
+
+func TestFakeClockDrainPeriod(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	drained := make(chan struct{})
+	s1 := service.New("", drainingService(drained))
+	r := service.NewRunner(service.RunnerClock(fc), service.RunnerDrainPeriod(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dto)
+	defer cancel()
+	tt.MustOK(r.Start(ctx, s1))
+
+	result := make(chan error, 1)
+	go func() { result <- r.Drain(ctx, s1) }()
+	<-drained
+
+	awaitSleepers(tt, fc, 1)
+	fc.Advance(time.Hour)
+	tt.MustOK(<-result)
+	tt.MustEqual(service.Halted, r.State(s1))
+}
+
+func TestFakeClockTimedRestart(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fc := NewFakeClock(time.Time{})
+	r := service.NewRunner(service.RunnerClock(fc))
+
+	restartErr := errors.New("restart")
+	restarts := make(chan uint64, 3)
+	rn := service.RunnableFunc(func(ctx service.Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		return restartErr
+	})
+	tr := serviceutil.NewTimedRestart(rn, dto, serviceutil.WaitFixed(time.Minute),
+		serviceutil.TimedRestartLimit(3),
+		serviceutil.TimedRestartNotify(func(start uint64, err error) { restarts <- start }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), dto)
+	defer cancel()
+	ender := service.NewEndListener(1)
+	tt.MustOK(r.Start(ctx, service.New("", tr).WithEndListener(ender)))
+
+	for i := uint64(1); i < 3; i++ {
+		tt.MustEqual(i, <-restarts)
+
+		// Nothing happens until the wait has elapsed:
+		awaitSleepers(tt, fc, 1)
+		tt.MustEqual(i, tr.Starts())
+		fc.Advance(time.Minute)
+	}
+	tt.MustEqual(uint64(3), <-restarts)
+	tt.MustAssert(serviceutil.IsRestartLimitExceeded(<-ender.Ends()))
+}
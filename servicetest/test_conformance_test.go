@@ -0,0 +1,34 @@
+package servicetest
+
+import (
+	"testing"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestConformance(t *testing.T) {
+	Conformance(t, func() service.Runner {
+		return service.NewRunner()
+	}, ConformanceSeed(fuzzSeed))
+}
+
+func TestConformanceDrainPeriod(t *testing.T) {
+	Conformance(t, func() service.Runner {
+		return service.NewRunner(service.RunnerDrainPeriod(tscale))
+	}, ConformanceSeed(fuzzSeed), ConformanceSequences(5))
+}
+
+func TestConformanceInvariants(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ic := NewInvariantChecker()
+	defer ic.Close()
+
+	Conformance(t, func() service.Runner {
+		return ic.NewRunner()
+	}, ConformanceSeed(fuzzSeed), ConformanceSequences(5))
+
+	tt.MustOK(ic.Close())
+	tt.MustEqual(0, ic.Dropped())
+}
@@ -0,0 +1,135 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func drainingService(drained chan<- struct{}) service.Runnable {
+	return service.RunnableFunc(func(ctx service.Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		select {
+		case <-service.DrainingFromContext(ctx):
+			close(drained)
+		case <-ctx.Done():
+			return nil
+		}
+		<-ctx.Done()
+		return nil
+	})
+}
+
+func TestRunnerDrainStates(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	drained := make(chan struct{})
+	s1 := service.New("", drainingService(drained))
+	lc := NewListenerCollector()
+	r := service.NewRunner(lc.RunnerOptions(service.RunnerDrainPeriod(2 * tscale))...)
+
+	sw1 := lc.StateWaiter(s1, 4)
+	tt.MustOK(service.StartTimeout(dto, r, s1))
+	tt.MustEqual(service.StateChange{s1, service.Halted, service.Starting}, *sw1.Take(dto))
+	tt.MustEqual(service.StateChange{s1, service.Starting, service.Started}, *sw1.Take(dto))
+
+	tm := time.Now()
+	tt.MustOK(r.Drain(context.Background(), s1))
+	tt.MustAssert(time.Since(tm) >= 2*tscale)
+	<-drained
+
+	tt.MustEqual(service.StateChange{s1, service.Started, service.Draining}, *sw1.Take(dto))
+	tt.MustEqual(service.StateChange{s1, service.Draining, service.Halting}, *sw1.Take(dto))
+	tt.MustEqual(service.StateChange{s1, service.Halting, service.Ended}, *sw1.Take(dto))
+	tt.MustAssert(r.State(s1) == service.Halted)
+}
+
+func TestRunnerDrainCancelled(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	drained := make(chan struct{})
+	s1 := service.New("", drainingService(drained))
+	r := service.NewRunner(service.RunnerDrainPeriod(dto))
+
+	tt.MustOK(service.StartTimeout(dto, r, s1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), tscale)
+	defer cancel()
+	err := r.Drain(ctx, s1)
+	tt.MustAssert(service.IsTimeout(err), err)
+	terr := err.(*service.TimeoutError)
+	tt.MustEqual("drain", terr.Op)
+	tt.MustAssert(errors.Is(err, context.DeadlineExceeded))
+	tt.MustEqual([]service.PendingService{{Service: s1, State: service.Draining}}, terr.Pending)
+	<-drained
+	tt.MustAssert(r.State(s1) == service.Draining)
+
+	// Halting a draining service should not wait for the lame duck period:
+	tt.MustOK(service.HaltTimeout(dto/2, r, s1))
+	tt.MustAssert(r.State(s1) == service.Halted)
+}
+
+func TestRunnerShutdownDrainsTogether(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	period := 10 * tscale
+	r := service.NewRunner(service.RunnerDrainPeriod(period))
+
+	var chans []chan struct{}
+	var svcs []*service.Service
+	for i := 0; i < 5; i++ {
+		drained := make(chan struct{})
+		chans = append(chans, drained)
+		svcs = append(svcs, service.New("", drainingService(drained)))
+	}
+	tt.MustOK(service.StartTimeout(dto, r, svcs...))
+
+	tm := time.Now()
+	tt.MustOK(service.ShutdownTimeout(dto, r))
+	since := time.Since(tm)
+	tt.MustAssert(since >= period)
+	tt.MustAssert(since < period*time.Duration(len(svcs)), since)
+
+	for _, c := range chans {
+		<-c
+	}
+	for _, svc := range svcs {
+		tt.MustAssert(r.State(svc) == service.Halted)
+	}
+}
+
+func TestRunnerShutdownDrainCancelled(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	drained := make(chan struct{})
+	s1 := service.New("", drainingService(drained))
+	s2 := service.New("", (&BlockingService{}).Init())
+	r := service.NewRunner(service.RunnerDrainPeriod(dto))
+
+	tt.MustOK(service.StartTimeout(dto, r, s1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), tscale)
+	defer cancel()
+	err := r.Shutdown(ctx)
+	tt.MustAssert(service.IsTimeout(err), err)
+	tt.MustEqual("shutdown", err.(*service.TimeoutError).Op)
+	tt.MustAssert(errors.Is(err, context.DeadlineExceeded))
+	<-drained
+
+	// The cancelled Shutdown should leave the runner enabled, so it is
+	// obvious that it did not finish:
+	tt.MustEqual(service.RunnerEnabled, r.RunnerState())
+	tt.MustAssert(r.State(s1) == service.Draining)
+	tt.MustOK(service.StartTimeout(dto, r, s2))
+
+	tt.MustOK(service.ShutdownTimeout(2*dto, r))
+	tt.MustEqual(service.RunnerShutdown, r.RunnerState())
+	tt.MustAssert(r.State(s1) == service.Halted)
+	tt.MustAssert(r.State(s2) == service.Halted)
+}
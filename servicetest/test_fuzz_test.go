@@ -2,8 +2,11 @@ package servicetest
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -233,7 +236,7 @@ func TestRunnerMetaFuzzInsanity(t *testing.T) {
 	//       ,d08b.  '|`  ,d08b.  '|`  ,d08b.  '|`  ,d08b.  '|`
 	//       0088MM       0088MM       0088MM       0088MM
 	//       `9MMP'       `9MMP'       `9MMP'       `9MMP'
-	rand.Seed(fuzzSeed)
+	rng := rand.New(rand.NewSource(fuzzSeed))
 
 	start := time.Now()
 	dur := time.Duration(fuzzTimeDur)
@@ -255,6 +258,9 @@ func TestRunnerMetaFuzzInsanity(t *testing.T) {
 		ServiceHaltTimeout: TimeRangeMaker{TimeRange{1 * time.Second, 1 * time.Second}, TimeRange{1 * time.Second, 1 * time.Second}},
 
 		StateCheckChance: FloatRange{0, 1},
+
+		RunnerLimit:  fuzzDefaultRunnerLimit,
+		ServiceLimit: fuzzDefaultServiceLimit,
 	}
 
 	iterDur := dur / time.Duration(fuzzMetaRolls)
@@ -264,10 +270,9 @@ func TestRunnerMetaFuzzInsanity(t *testing.T) {
 	for i < min || time.Since(start) < dur {
 		t.Run("", func(t *testing.T) {
 			stats := NewFuzzStats()
-			stats.Seed = fuzzSeed
 
 			tt := assert.WrapTB(t)
-			fz := builder.Next(iterDur)
+			fz := builder.Next(rng, iterDur)
 			if testing.Verbose() {
 				e := json.NewEncoder(os.Stdout)
 				e.SetIndent("", "  ")
@@ -275,8 +280,10 @@ func TestRunnerMetaFuzzInsanity(t *testing.T) {
 			}
 
 			fz.Tick = time.Duration(fuzzTickNsec)
+			fz.DisableServices = !fuzzServices
 			fz.Stats = stats
-			fz.Run(tt)
+			defer reportFuzzFailure(t, fz)
+			runFuzz(tt, fz)
 			if testing.Verbose() {
 				fuzzOutput(fuzzOutputFormat, t.Name(), stats, os.Stdout)
 			}
@@ -285,6 +292,47 @@ func TestRunnerMetaFuzzInsanity(t *testing.T) {
 	}
 }
 
+func TestRunnerFuzzerBuilderReproducible(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	builder := &RunnerFuzzerBuilder{
+		RunnerCreateChance: FloatRange{0.001, 0.02},
+		ServiceRunTime:     TimeRangeMaker{TimeRange{0, time.Second}, TimeRange{time.Second, 5 * time.Second}},
+	}
+	fz1 := builder.Next(rand.New(rand.NewSource(1)), time.Second)
+	fz2 := builder.Next(rand.New(rand.NewSource(1)), time.Second)
+	tt.MustEqual(fz1, fz2)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+
+	file, err := fz1.WriteReplay(dir)
+	tt.MustOK(err)
+	loaded, err := LoadRunnerFuzzer(file)
+	tt.MustOK(err)
+	tt.MustEqual(fz1, loaded)
+}
+
+func TestRunnerFuzzReplay(t *testing.T) {
+	if fuzzReplay == "" {
+		t.Skip("skipping fuzz replay; pass -service.fuzz.replay=<file>")
+	}
+
+	fz, err := LoadRunnerFuzzer(fuzzReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fz.Stats = NewFuzzStats()
+	setCurrentFuzzer(fz)
+	defer reportFuzzFailure(t, fz)
+
+	runFuzz(assert.WrapTB(t), fz)
+	if testing.Verbose() {
+		fuzzOutput(fuzzOutputFormat, t.Name(), fz.Stats, os.Stdout)
+	}
+}
+
 func testFuzz(t *testing.T, fz *RunnerFuzzer) {
 	if !fuzzEnabled {
 		t.Skip("skipping fuzz test")
@@ -297,16 +345,87 @@ func testFuzz(t *testing.T, fz *RunnerFuzzer) {
 		fz.ServiceLimit = fuzzDefaultServiceLimit
 	}
 
-	rand.Seed(fuzzSeed)
-	fz.Stats.Seed = fuzzSeed
+	fz.Seed = fuzzSeed
+	fz.DisableServices = !fuzzServices
+
+	if fuzzTrace {
+		fz.Trace = NewFuzzTrace()
+	}
 
 	setCurrentFuzzer(fz)
+	defer reportFuzzFailure(t, fz)
 
 	dur := time.Duration(fuzzTimeDur)
 	fz.Duration = dur
-	fz.Run(assert.WrapTB(t))
+	runFuzz(assert.WrapTB(t), fz)
+
+	if fz.Trace != nil {
+		checkFuzzTrace(t, fz.Trace)
+	}
 
 	if testing.Verbose() {
 		fuzzOutput(fuzzOutputFormat, t.Name(), fz.Stats, os.Stdout)
 	}
 }
+
+// runFuzz runs fz with an InvariantChecker watching its runners, and fails
+// with the history of the services involved if any invariants were violated.
+func runFuzz(tt assert.T, fz *RunnerFuzzer) {
+	tt.Helper()
+	ic := NewInvariantChecker()
+	defer ic.Close()
+
+	fz.Invariants = ic
+	fz.Run(tt)
+	if err := ic.Close(); err != nil {
+		tt.Fatal(err)
+	}
+	if n := ic.Dropped(); n > 0 {
+		tt.Logf("%d runner(s) dropped state changes; some invariants were not checked", n)
+	}
+}
+
+// checkFuzzTrace checks the trace recorded by a fuzzer. If the check fails,
+// the operations are shrunk to a smaller sequence that still fails, which is
+// written out as a Go test.
+func checkFuzzTrace(t *testing.T, trace *FuzzTrace) {
+	t.Helper()
+	err := CheckFuzzTrace(trace)
+	if err == nil {
+		return
+	}
+	t.Logf("fuzz trace check failed after %d ops: %v; shrinking", len(trace.Ops), err)
+
+	ops := ShrinkFuzzOps(trace.Ops, 3, func(ops []FuzzOp) bool {
+		return ReplayFuzzOps(ops, nil) != nil
+	})
+	src := FuzzOpsGoTest("TestFuzzRepro", ops)
+
+	file := filepath.Join(os.TempDir(), fmt.Sprintf("go-service-fuzz-%d_test.go", fuzzSeed))
+	if werr := ioutil.WriteFile(file, []byte(src), 0644); werr != nil {
+		t.Logf("could not write shrunk fuzz test: %v", werr)
+	} else {
+		t.Logf("shrunk fuzz test written to %s", file)
+	}
+	t.Fatalf("%v; shrunk to %d op(s):\n%s", err, len(ops), src)
+}
+
+// reportFuzzFailure writes a replay file for fz and logs how to use it if the
+// test has failed or is panicking. It must be deferred.
+func reportFuzzFailure(t *testing.T, fz *RunnerFuzzer) {
+	p := recover()
+	if p == nil && !t.Failed() {
+		return
+	}
+
+	if file, err := fz.WriteReplay(""); err != nil {
+		t.Logf("could not write fuzz replay file: %v", err)
+	} else {
+		t.Logf("fuzz replay file written to %s", file)
+	}
+	t.Log(fz.ReplayInfo())
+
+	if p != nil {
+		panic(p)
+	}
+}
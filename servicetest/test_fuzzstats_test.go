@@ -0,0 +1,141 @@
+package servicetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestDurationStatsSummary(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ds := &DurationStats{}
+	tt.MustEqual(DurationSummary{}, ds.Summary())
+
+	// Add 100..1 so the samples have to be sorted:
+	for i := 100; i >= 1; i-- {
+		ds.Add(time.Duration(i) * time.Millisecond)
+	}
+	sm := ds.Summary()
+	tt.MustEqual(100, sm.Count)
+	tt.MustEqual(1*time.Millisecond, sm.Min)
+	tt.MustEqual(100*time.Millisecond, sm.Max)
+	tt.MustEqual(50500*time.Microsecond, sm.Mean)
+	tt.MustEqual(50*time.Millisecond, sm.P50)
+	tt.MustEqual(99*time.Millisecond, sm.P99)
+
+	// A clone is independent of the original:
+	clone := ds.Clone()
+	ds.Add(time.Hour)
+	tt.MustEqual(sm, clone.Summary())
+	tt.MustEqual(time.Hour, ds.Summary().Max)
+
+	ds = &DurationStats{}
+	ds.Add(time.Second)
+	tt.MustEqual(DurationSummary{Count: 1, Min: time.Second, Max: time.Second, Mean: time.Second, P50: time.Second, P99: time.Second}, ds.Summary())
+}
+
+func TestTimedServiceRunEnded(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	type runEnd struct {
+		ran    time.Duration
+		halted bool
+	}
+	ends := make(chan runEnd, 2)
+	ts := (&TimedService{
+		RunTime:  2 * tscale,
+		RunEnded: func(ran time.Duration, halted bool) { ends <- runEnd{ran, halted} },
+	}).Init()
+
+	r := service.NewRunner()
+	svc := service.New("", ts)
+
+	ender := service.NewEndListener(1)
+	tt.MustOK(service.StartTimeout(dto, r, svc.WithEndListener(ender)))
+	tt.MustAssert(service.IsEnded(<-ender.Ends()))
+	end := <-ends
+	tt.MustAssert(!end.halted)
+	tt.MustAssert(end.ran >= 2*tscale, end.ran.String())
+
+	ts.RunTime = dto
+	tt.MustOK(service.StartTimeout(dto, r, svc))
+	tt.MustOK(service.HaltTimeout(dto, r, svc))
+	end = <-ends
+	tt.MustAssert(end.halted)
+	tt.MustAssert(end.ran < dto, end.ran.String())
+}
+
+func TestFuzzOutputRunTimes(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	stats := NewFuzzStats()
+	stats.Start()
+	stats.Service.StartLatency.Add(time.Millisecond)
+	stats.Service.RunDuration.Add(2 * time.Millisecond)
+	stats.Service.HaltLatency.Add(3 * time.Millisecond)
+	stats.Service.TimeBounds.Add(nil)
+	stats.Service.TimeBounds.Add(errRunTooLong)
+
+	var buf bytes.Buffer
+	fuzzOutput(fuzzFormatJSON, "", stats, &buf)
+
+	var out struct {
+		Service struct {
+			StartLatency DurationSummary
+			RunDuration  DurationSummary
+			HaltLatency  DurationSummary
+			TimeBounds   struct {
+				Succeeded, Failed int
+				Errors            map[string]int
+			}
+		}
+	}
+	tt.MustOK(json.Unmarshal(buf.Bytes(), &out))
+	tt.MustEqual(1, out.Service.StartLatency.Count)
+	tt.MustEqual(2*time.Millisecond, out.Service.RunDuration.P99)
+	tt.MustEqual(3*time.Millisecond, out.Service.HaltLatency.Max)
+	tt.MustEqual(1, out.Service.TimeBounds.Succeeded)
+	tt.MustEqual(map[string]int{errRunTooLong.Error(): 1}, out.Service.TimeBounds.Errors)
+
+	buf.Reset()
+	fuzzOutput(fuzzFormatCLI, "", stats, &buf)
+	cli := buf.String()
+	for _, want := range []string{"start latency", "run duration", "halt latency", "bounds", errRunTooLong.Error()} {
+		tt.MustAssert(strings.Contains(cli, want), want)
+	}
+}
+
+func TestFuzzTimeBounds(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	fz := &RunnerFuzzer{Stats: NewFuzzStats()}
+	fz.init()
+	r := service.NewRunner()
+	defer service.MustShutdownTimeout(dto, r)
+
+	// With a negative tolerance, a halt that times out is flagged as taking
+	// longer than its timeout:
+	unhaltable := service.New("", (&TimedService{
+		RunTime:         4 * tscale,
+		UnhaltableSleep: true,
+	}).Init())
+	fz.TimeTolerance = -tscale
+	tt.MustOK(fz.timedStart(r, unhaltable, dto))
+	tt.MustAssert(service.IsTimeout(fz.timedHalt(r, unhaltable, tscale)))
+
+	bounds := fz.Stats.Service.TimeBounds
+	tt.MustEqual(1, bounds.Succeeded())
+	tt.MustEqual(1, bounds.Failed())
+	tt.MustEqual(map[string]int{errHaltTooSlow.Error(): 1}, bounds.Clone().errors)
+	tt.MustEqual(1, fz.Stats.Service.StartLatency.Count())
+	tt.MustEqual(0, fz.Stats.Service.HaltLatency.Count())
+
+	// Wait for the unhaltable service to finish:
+	tt.MustOK(service.HaltTimeout(dto, r, unhaltable))
+}
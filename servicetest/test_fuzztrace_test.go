@@ -0,0 +1,177 @@
+package servicetest
+
+import (
+	"errors"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestFuzzTraceRecordsOps(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	trace := NewFuzzTrace()
+	fz := &RunnerFuzzer{
+		Duration: 20 * tscale,
+		Tick:     tscale,
+		Seed:     1,
+
+		ServiceCreateChance: 1.0,
+		ServiceHaltChance:   0.1,
+		StartWaitTimeout:    TimeRange{dto, dto},
+		ServiceRunTime:      TimeRange{tscale, 4 * tscale},
+		ServiceHaltAfter:    TimeRange{tscale, 4 * tscale},
+		ServiceHaltTimeout:  TimeRange{dto, dto},
+
+		Stats: NewFuzzStats(),
+		Trace: trace,
+	}
+	fz.Run(tt)
+
+	tt.MustAssert(len(trace.Ops) > 1)
+	tt.MustEqual(FuzzCreateRunner, trace.Ops[0].Kind)
+	tt.MustEqual(1, trace.Ops[0].Runner)
+
+	var starts int
+	for i, op := range trace.Ops {
+		if i > 0 {
+			tt.MustAssert(op.At >= trace.Ops[i-1].At, "ops out of order")
+		}
+		if op.Kind == FuzzStartService {
+			starts++
+			tt.MustAssert(op.Service > 0)
+		}
+	}
+	tt.MustAssert(starts > 0)
+	tt.MustOK(CheckFuzzTrace(trace))
+}
+
+func TestReplayFuzzOps(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ops := []FuzzOp{
+		{Kind: FuzzCreateRunner, Runner: 1},
+		{Kind: FuzzStartService, At: tscale, Runner: 1, Service: 1, Timeout: dto, HaltTimeout: dto, HaltAfter: 2 * tscale, RunTime: time.Second},
+		{Kind: FuzzStartService, At: tscale, Runner: 1, Service: 2, Timeout: dto, HaltTimeout: dto, HaltAfter: time.Second, RunTime: tscale},
+		{Kind: FuzzStartService, At: tscale, Runner: 1, Service: 3, Timeout: dto, HaltTimeout: dto, HaltAfter: tscale, StartFailure: true},
+	}
+
+	var outcomes []FuzzOutcome
+	tt.MustOK(ReplayFuzzOps(ops, func(trace *FuzzTrace) error {
+		outcomes = trace.Outcomes
+		return CheckFuzzTrace(trace)
+	}))
+
+	ended := map[int]string{}
+	for _, o := range outcomes {
+		if o.Kind == FuzzServiceEnded {
+			ended[o.Service] = o.Err
+		}
+	}
+	tt.MustEqual("", ended[1])
+	tt.MustEqual(service.ErrServiceEnded.Error(), ended[2])
+	tt.MustEqual(errStartFailure.Error(), ended[3])
+
+	// A failing check is passed through:
+	checkErr := errors.New("check")
+	tt.MustEqual(checkErr, ReplayFuzzOps(ops[:1], func(*FuzzTrace) error { return checkErr }))
+}
+
+func TestShrinkFuzzOps(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var ops []FuzzOp
+	for i := 1; i <= 20; i++ {
+		ops = append(ops, FuzzOp{Kind: FuzzStartService, At: time.Duration(i) * time.Second, Runner: 1, Service: i})
+	}
+
+	// Fails only if both service 3 and service 17 are present:
+	var calls int
+	fails := func(ops []FuzzOp) bool {
+		calls++
+		var has3, has17 bool
+		for _, op := range ops {
+			has3 = has3 || op.Service == 3
+			has17 = has17 || op.Service == 17
+		}
+		return has3 && has17
+	}
+
+	min := ShrinkFuzzOps(ops, 1, fails)
+	tt.MustEqual(2, len(min))
+	tt.MustEqual(3, min[0].Service)
+	tt.MustEqual(17, min[1].Service)
+	tt.MustAssert(calls < 100, calls)
+
+	// The removed ops' time is taken out, but each op is still issued the
+	// same time after the op before it as it was originally:
+	tt.MustEqual(1*time.Second, min[0].At)
+	tt.MustEqual(2*time.Second, min[1].At)
+	tt.MustEqual(3*time.Second, ops[2].At)
+
+	// Each candidate is tried 'attempts' times before it is considered to
+	// pass, so a flaky failure can still shrink:
+	var flaky int
+	min = ShrinkFuzzOps(ops, 3, func(ops []FuzzOp) bool {
+		flaky++
+		return flaky%3 == 0 && fails(ops)
+	})
+	tt.MustEqual(2, len(min))
+}
+
+func TestFuzzOpsGoTest(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	src := FuzzOpsGoTest("TestFuzzRepro", []FuzzOp{
+		{Kind: FuzzCreateRunner, Runner: 1},
+		{Kind: FuzzStartService, At: 1500 * time.Microsecond, Runner: 1, Service: 1,
+			Timeout: time.Second, HaltAfter: 3 * time.Millisecond, StartLimit: 2, RunFailure: true},
+		{Kind: FuzzHaltService, At: 12345, Runner: 1, Timeout: time.Second},
+	})
+
+	file := "package repro_test\n\n" +
+		"import (\n\"testing\"\n\"time\"\n\n\"github.com/shabbyrobe/go-service/servicetest\"\n)\n\n" +
+		src
+	_, err := parser.ParseFile(token.NewFileSet(), "repro_test.go", file, 0)
+	tt.MustOK(err)
+
+	for _, want := range []string{
+		"servicetest.FuzzStartService",
+		"At: 1500 * time.Microsecond",
+		"At: 12345",
+		"HaltAfter: 3 * time.Millisecond",
+		"StartLimit: 2",
+		"RunFailure: true",
+		"servicetest.ReplayFuzzOps(ops, nil)",
+	} {
+		tt.MustAssert(strings.Contains(src, want), want)
+	}
+
+	// The check that failed can be replayed instead of CheckFuzzTrace:
+	src = FuzzOpsGoTest("TestFuzzRepro", []FuzzOp{{Kind: FuzzCreateRunner, Runner: 1}},
+		FuzzGoTestCheck("checkNoRestarts"))
+	tt.MustAssert(strings.Contains(src, "servicetest.ReplayFuzzOps(ops, checkNoRestarts)"), src)
+}
+
+func TestRemoveFuzzOps(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ops := []FuzzOp{{Service: 1, At: 1}, {Service: 2, At: 3}, {Service: 3, At: 6}, {Service: 4, At: 10}}
+	ats := func(ops []FuzzOp) (out []time.Duration) {
+		for _, op := range ops {
+			out = append(out, op.At)
+		}
+		return out
+	}
+
+	tt.MustEqual([]time.Duration{2, 5, 9}, ats(removeFuzzOps(ops, 0, 1)))
+	tt.MustEqual([]time.Duration{1, 4, 8}, ats(removeFuzzOps(ops, 1, 2)))
+	tt.MustEqual([]time.Duration{1, 5}, ats(removeFuzzOps(ops, 1, 3)))
+	tt.MustEqual([]time.Duration{1, 3, 6}, ats(removeFuzzOps(ops, 3, 4)))
+	tt.MustEqual([]time.Duration{1, 3, 6, 10}, ats(ops))
+}
@@ -0,0 +1,202 @@
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestInvariantCheckerRunner(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ic := NewInvariantChecker()
+	defer ic.Close()
+	r := ic.NewRunner()
+
+	readyErr := errors.New("ready")
+	s1 := service.New("s1", (&TimedService{}).Init())
+	s2 := service.New("s2", (&TimedService{StartFailure: readyErr}).Init())
+
+	for i := 0; i < 3; i++ {
+		tt.MustOK(service.StartTimeout(dto, r, s1))
+		tt.MustOK(service.HaltTimeout(dto, r, s1))
+	}
+	tt.MustEqual(readyErr, cause(service.StartTimeout(dto, r, s2)))
+	tt.MustOK(service.HaltTimeout(dto, r, s2))
+
+	tt.MustOK(service.StartTimeout(dto, r, s1))
+	tt.MustOK(service.ShutdownTimeout(dto, r))
+
+	tt.MustOK(ic.Close())
+	tt.MustEqual(0, ic.Dropped())
+}
+
+// misbehavingRunner sends the state changes and ends chosen by a test to an
+// InvariantListener, rather than running anything.
+type misbehavingRunner struct {
+	service.Runner
+	start func(svc *service.Service) error
+	halt  func(svc *service.Service) error
+}
+
+func (m *misbehavingRunner) Start(ctx context.Context, services ...*service.Service) error {
+	return m.start(services[0])
+}
+
+func (m *misbehavingRunner) Halt(ctx context.Context, services ...*service.Service) error {
+	return m.halt(services[0])
+}
+
+func TestInvariantCheckerViolations(t *testing.T) {
+	readyErr := errors.New("ready")
+
+	for _, tc := range []struct {
+		name  string
+		start func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error
+		halt  func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error
+		msg   string
+	}{
+		{
+			name: "discontinuous",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Started}
+				states <- service.StateChange{Service: svc, From: service.Halting, To: service.Ended}
+				return nil
+			},
+			msg: "state changed from halting, expected started",
+		},
+		{
+			name: "end-twice",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Started}
+				states <- service.StateChange{Service: svc, From: service.Started, To: service.Ended}
+				onEnd(service.StageRun, svc, nil)
+				onEnd(service.StageRun, svc, nil)
+				return nil
+			},
+			msg: "OnEnd called 2 times for one run",
+		},
+		{
+			name: "end-before-ended",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Started}
+				onEnd(service.StageRun, svc, nil)
+				return nil
+			},
+			halt: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Started, To: service.Ended}
+				return nil
+			},
+			msg: "OnEnd called before the Ended state change",
+		},
+		{
+			name: "halt-before-ended",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Started}
+				return nil
+			},
+			halt: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Started, To: service.Halting}
+				return nil
+			},
+			msg: "Halt() succeeded before the service Ended",
+		},
+		{
+			name: "start-while-starting",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				return nil
+			},
+			halt: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Ended}
+				return nil
+			},
+			msg: "Start() succeeded while the service was still Starting",
+		},
+		{
+			name: "ready-error-to-halt",
+			start: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+				return context.DeadlineExceeded
+			},
+			halt: func(states chan<- service.StateChange, onEnd service.OnEnd, svc *service.Service) error {
+				states <- service.StateChange{Service: svc, From: service.Starting, To: service.Ended}
+				onEnd(service.StageReady, svc, readyErr)
+				return service.WrapError(readyErr, svc)
+			},
+			msg: "Halt() returned the service's ready error",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := assert.WrapTB(t)
+
+			ic := NewInvariantChecker()
+			defer ic.Close()
+
+			il := ic.Listener(nil)
+			states, onEnd := il.OnServiceState(), il.OnServiceEnd()
+			r := il.Runner(&misbehavingRunner{
+				start: func(svc *service.Service) error { return tc.start(states, onEnd, svc) },
+				halt: func(svc *service.Service) error {
+					if tc.halt == nil {
+						return nil
+					}
+					return tc.halt(states, onEnd, svc)
+				},
+			})
+
+			svc := service.New("svc", (&TimedService{}).Init())
+			_ = r.Start(nil, svc)
+			_ = r.Halt(nil, svc)
+
+			err := ic.Close()
+			tt.MustAssert(err != nil)
+			ierr := err.(*InvariantError)
+			tt.MustEqual(1, ierr.Total, err.Error())
+			tt.MustEqual(1, ierr.Violations[0].Runner)
+			tt.MustEqual(svc, ierr.Violations[0].Service)
+			tt.MustAssert(strings.Contains(err.Error(), tc.msg), err.Error())
+
+			// The history of the service is included:
+			tt.MustAssert(len(ierr.Violations[0].History) > 0)
+			tt.MustAssert(strings.Contains(err.Error(), "#1 start\n"), err.Error())
+		})
+	}
+}
+
+func TestInvariantCheckerHaltTimeoutAfterReadyDeadline(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ic := NewInvariantChecker()
+	defer ic.Close()
+
+	// The service's ready stage is ended by Start()'s deadline, then Halt()
+	// times out too. Both errors are context.DeadlineExceeded underneath,
+	// but Halt() has not returned the ready error:
+	il := ic.Listener(nil)
+	states, onEnd := il.OnServiceState(), il.OnServiceEnd()
+	r := il.Runner(&misbehavingRunner{
+		start: func(svc *service.Service) error {
+			states <- service.StateChange{Service: svc, From: service.Halted, To: service.Starting}
+			return &service.TimeoutError{Op: "start", Err: context.DeadlineExceeded}
+		},
+		halt: func(svc *service.Service) error {
+			states <- service.StateChange{Service: svc, From: service.Starting, To: service.Ended}
+			onEnd(service.StageReady, svc, context.DeadlineExceeded)
+			return &service.TimeoutError{Op: "halt", Err: context.DeadlineExceeded}
+		},
+	})
+
+	svc := service.New("svc", (&TimedService{}).Init())
+	_ = r.Start(nil, svc)
+	_ = r.Halt(nil, svc)
+	tt.MustOK(ic.Close())
+}
@@ -0,0 +1,150 @@
+package servicetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+// leakTB records the errors reported by VerifyNoLeaks rather than failing
+// the test.
+type leakTB struct {
+	testing.TB
+	errs []string
+}
+
+func (l *leakTB) Helper()      {}
+func (l *leakTB) Failed() bool { return len(l.errs) > 0 }
+
+func (l *leakTB) Error(args ...interface{}) {
+	l.errs = append(l.errs, fmt.Sprint(args...))
+}
+
+func leakyBlock(block chan struct{}) { <-block }
+
+func TestVerifyNoLeaks(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ltb := &leakTB{TB: t}
+	check := VerifyNoLeaks(ltb, LeakTimeout(4*tscale))
+
+	r := service.NewRunner()
+	svc := service.New("leaky", (&BlockingService{}).Init())
+	tt.MustOK(service.StartTimeout(dto, r, svc))
+	check()
+
+	// The service's goroutine has its label, but the runner's goroutine that
+	// waits for the service to halt does not:
+	tt.MustEqual(1, len(ltb.errs))
+	msg := ltb.errs[0]
+	tt.MustAssert(strings.Contains(msg, "2 leaked goroutine(s) in 2 group(s)"), msg)
+	tt.MustAssert(strings.Contains(msg, `service "leaky": 1 goroutine(s)`), msg)
+	tt.MustAssert(strings.Contains(msg, "created by github.com/shabbyrobe/go-service.joinDone at "), msg)
+	tt.MustAssert(strings.Contains(msg, "(*BlockingService).Run"), msg)
+
+	// Nothing is checked once the test has failed:
+	check()
+	tt.MustEqual(1, len(ltb.errs))
+
+	ltb.errs = nil
+	tt.MustOK(service.ShutdownTimeout(dto, r))
+	check()
+	tt.MustEqual(0, len(ltb.errs))
+}
+
+func TestVerifyNoLeaksWaits(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ltb := &leakTB{TB: t}
+	check := VerifyNoLeaks(ltb)
+	go time.Sleep(4 * tscale)
+	check()
+	tt.MustEqual(0, len(ltb.errs))
+}
+
+func TestVerifyNoLeaksIgnore(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	block := make(chan struct{})
+	defer close(block)
+
+	ltb := &leakTB{TB: t}
+	check := VerifyNoLeaks(ltb,
+		LeakTimeout(tscale),
+		IgnoreLeak("github.com/shabbyrobe/go-service/servicetest.leakyBlock"))
+	go leakyBlock(block)
+	check()
+	tt.MustEqual(0, len(ltb.errs))
+}
+
+func TestParseGoroutines(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dump := "" +
+		"goroutine 7 [sleep] {service: foo, other: bar}:\n" +
+		"time.Sleep(0x34630b8a000)\n" +
+		"\t/usr/local/go/src/runtime/time.go:368 +0x165\n" +
+		"main.main.func1.1()\n" +
+		"\t/tmp/main.go:12 +0x1d\n" +
+		"created by main.main.func1 in goroutine 1\n" +
+		"\t/tmp/main.go:12 +0x1a\n" +
+		"\n" +
+		"goroutine 8 [chan receive, 2 minutes] {\"service\":\"baz\"}:\n" +
+		"main.block(...)\n" +
+		"\t/tmp/main.go:20\n" +
+		"created by main.main\n" +
+		"\t/tmp/main.go:14 +0x7c\n" +
+		"\n" +
+		"goroutine 1 [running]:\n" +
+		"main.main()\n" +
+		"\t/tmp/main.go:16 +0xaf\n"
+
+	gs := parseGoroutines(dump)
+	tt.MustEqual(3, len(gs))
+
+	tt.MustEqual(int64(7), gs[0].id)
+	tt.MustEqual(map[string]string{"service": "foo", "other": "bar"}, gs[0].labels)
+	tt.MustEqual([]string{"time.Sleep", "main.main.func1.1"}, gs[0].frames)
+	tt.MustEqual("main.main.func1.1", gs[0].entry)
+	tt.MustEqual("main.main.func1 at /tmp/main.go:12", gs[0].created)
+	tt.MustEqual(`service "foo"`, gs[0].site())
+
+	tt.MustEqual(`service "baz"`, gs[1].site())
+	tt.MustEqual("main.main at /tmp/main.go:14", gs[1].created)
+
+	tt.MustEqual(0, len(gs[2].labels))
+	tt.MustEqual("started by main.main", gs[2].site())
+}
+
+func TestLabelGoroutines(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	gs := parseGoroutines("" +
+		"goroutine 7 [chan receive]:\n" +
+		"main.block(...)\n" +
+		"\t/tmp/main.go:20\n" +
+		"created by main.main\n" +
+		"\t/tmp/main.go:14 +0x7c\n" +
+		"\n" +
+		"goroutine 8 [chan receive]:\n" +
+		"main.other()\n" +
+		"\t/tmp/main.go:30 +0x1d\n")
+
+	labelGoroutines(gs, ""+
+		"goroutine profile: total 2\n"+
+		"1 @ 0x47d86a 0x45bd06 0x4e142f 0x483601\n"+
+		"# labels: {\"service\":\"foo\"}\n"+
+		"#\t0x47d869\truntime.gopark+0xc9\t/usr/local/go/src/runtime/proc.go:435\n"+
+		"#\t0x4e142e\tmain.block+0xe\t/tmp/main.go:20\n"+
+		"\n"+
+		"1 @ 0x47d86a 0x4e142f 0x483601\n"+
+		"#\t0x4e142e\tmain.other+0x1c\t/tmp/main.go:30\n")
+
+	tt.MustEqual(`service "foo"`, gs[0].site())
+	tt.MustEqual(0, len(gs[1].labels))
+	tt.MustEqual("started by main.other", gs[1].site())
+}
@@ -119,7 +119,11 @@ func TestRunnerHalt(t *testing.T) {
 	s2 := service.New("", sr2)
 	e2 := lc.EndWaiter(s2, 1)
 	tt.MustOK(service.StartTimeout(dto, r, s2))
-	tt.MustEqual(context.DeadlineExceeded, service.HaltTimeout(1*time.Nanosecond, r, s2))
+	err = service.HaltTimeout(1*time.Nanosecond, r, s2)
+	tt.MustAssert(service.IsTimeout(err), err)
+	terr := err.(*service.TimeoutError)
+	tt.MustEqual(context.DeadlineExceeded, terr.Err)
+	tt.MustEqual([]service.PendingService{{Service: s2, State: service.Halting}}, terr.Pending)
 	close(sr2.halt)
 	mustRecv(tt, e2.C(), dto)
 }
@@ -295,13 +299,34 @@ func TestRunnerReadyTimeout(t *testing.T) {
 	r := service.NewRunner()
 
 	err := service.StartTimeout(1*tscale, r, s1)
-	tt.MustEqual(context.DeadlineExceeded, err)
+	tt.MustAssert(service.IsTimeout(err), err)
+	terr := err.(*service.TimeoutError)
+	tt.MustEqual(context.DeadlineExceeded, terr.Err)
+	tt.MustEqual([]service.PendingService{{Service: s1, State: service.Starting}}, terr.Pending)
 
 	herr := service.HaltTimeout(dto, r, s1)
 	tt.MustOK(herr)
 	tt.MustAssert(r.State(s1) == service.Halted)
 }
 
+func TestRunnerReadyTimeoutReportsPending(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s1 := service.New("fast", (&BlockingService{}).Init())
+	s2 := service.New("slow", (&BlockingService{StartDelay: 20 * tscale}).Init())
+	r := service.NewRunner()
+
+	err := service.StartTimeout(5*tscale, r, s1, s2)
+	tt.MustAssert(service.IsTimeout(err), err)
+	terr := err.(*service.TimeoutError)
+	tt.MustEqual("start", terr.Op)
+	tt.MustEqual([]service.PendingService{{Service: s2, State: service.Starting}}, terr.Pending)
+	tt.MustEqual([]service.CompletedService{{Service: s1}}, terr.Completed)
+	tt.MustEqual(`service: start timed out with 1 of 2 service(s) pending: "slow" (starting): context deadline exceeded`, err.Error())
+
+	tt.MustOK(service.ShutdownTimeout(dto, r))
+}
+
 func TestRunnerStartHaltWhileInStartDelay(t *testing.T) {
 	tt := assert.WrapTB(t)
 
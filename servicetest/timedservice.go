@@ -16,6 +16,10 @@ type TimedService struct {
 	HaltDelay       time.Duration
 	UnhaltableSleep bool
 
+	// If not nil, RunEnded is called when a run that became ready ends, with
+	// the time since it became ready and whether it was halted.
+	RunEnded func(ran time.Duration, halted bool)
+
 	init   bool
 	starts int32
 	halts  int32
@@ -51,6 +55,7 @@ func (d *TimedService) Run(ctx service.Context) error {
 
 	defer atomic.AddInt32(&d.halts, 1)
 
+	ready := time.Now()
 	if d.RunTime > 0 {
 		if d.UnhaltableSleep {
 			time.Sleep(d.RunTime)
@@ -58,15 +63,21 @@ func (d *TimedService) Run(ctx service.Context) error {
 			service.Sleep(ctx, d.RunTime)
 		}
 	}
-	if ctx.ShouldHalt() {
+
+	var err error
+	halted := ctx.ShouldHalt()
+	if halted {
 		if d.HaltDelay > 0 {
 			time.Sleep(d.HaltDelay)
 		}
-		return nil
+	} else if d.RunFailure == nil {
+		err = service.ErrServiceEnded
 	} else {
-		if d.RunFailure == nil {
-			return service.ErrServiceEnded
-		}
-		return d.RunFailure
+		err = d.RunFailure
+	}
+
+	if d.RunEnded != nil {
+		d.RunEnded(time.Since(ready), halted)
 	}
+	return err
 }
@@ -0,0 +1,206 @@
+package serviceutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const DefaultCertReloadInterval = 10 * time.Second
+
+// CertReloader is an experimental service.Runnable that serves a TLS
+// certificate via tls.Config.GetCertificate and reloads it from disk when the
+// files change.
+//
+// While it is running, the files are polled and reloaded when their contents
+// change. Reload() may also be called at any time, whether or not the service
+// is running.
+//
+// If a reload fails, the previously loaded certificate continues to be used.
+// When running as a service, reload failures are passed to ctx.OnError().
+//
+// If a client CA file is supplied with CertReloaderClientCA, the tls.Config
+// returned by TLSConfig() requires and verifies client certificates against
+// the CA pool, which is reloaded along with the certificate.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+
+	cert *tls.Certificate
+	cas  *x509.CertPool
+	hash []byte
+	mu   sync.RWMutex
+}
+
+var _ service.Runnable = &CertReloader{}
+
+type CertReloaderOption func(c *CertReloader)
+
+// CertReloaderClientCA loads a PEM encoded pool of CAs used to verify client
+// certificates from caFile.
+func CertReloaderClientCA(caFile string) CertReloaderOption {
+	return func(c *CertReloader) { c.caFile = caFile }
+}
+
+// CertReloaderInterval sets how often the files are checked for changes. The
+// default is DefaultCertReloadInterval.
+func CertReloaderInterval(d time.Duration) CertReloaderOption {
+	return func(c *CertReloader) { c.interval = d }
+}
+
+// NewCertReloader creates a CertReloader and loads the certificate. It returns
+// an error if the initial load fails.
+func NewCertReloader(certFile, keyFile string, options ...CertReloaderOption) (*CertReloader, error) {
+	c := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: DefaultCertReloadInterval,
+	}
+	for _, o := range options {
+		o(c)
+	}
+	if _, err := c.reload(true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	cert := c.cert
+	c.mu.RUnlock()
+	return cert, nil
+}
+
+// ClientCAs returns the current client CA pool, or nil if no client CA file
+// was supplied.
+func (c *CertReloader) ClientCAs() *x509.CertPool {
+	c.mu.RLock()
+	cas := c.cas
+	c.mu.RUnlock()
+	return cas
+}
+
+// TLSConfig returns a tls.Config that uses the CertReloader for certificates
+// and, if configured, client certificate verification.
+//
+// If a client CA file was supplied, its pool replaces any ClientCAs set on the
+// returned config. Otherwise ClientCAs is left alone, so it may be set by
+// other means, i.e. HTTP.SetTLS(TLSClientCAFile(...)).
+//
+// With a client CA file, every handshake uses a copy of the returned config,
+// not the copy net/http makes when it serves it, so protocols net/http would
+// add such as "h2" are only offered via ALPN if they are in NextProtos.
+// HTTP.SetTLSCertReloader sets NextProtos for you.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	config := &tls.Config{GetCertificate: c.GetCertificate}
+	if c.caFile != "" {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			// tls.Config.ClientCAs can not be swapped on a live config, so
+			// hand out a copy with the current pool for every handshake:
+			out := config.Clone()
+			out.GetConfigForClient = nil
+			if cas := c.ClientCAs(); cas != nil {
+				out.ClientCAs = cas
+			}
+			return out, nil
+		}
+	}
+	return config
+}
+
+// Reload loads the certificate (and client CA pool, if configured) from disk.
+// If loading fails, the previous certificate is retained and the error is
+// returned.
+func (c *CertReloader) Reload() error {
+	_, err := c.reload(true)
+	return err
+}
+
+// reload loads the files if force is true or if their contents have changed
+// since they were last loaded.
+func (c *CertReloader) reload(force bool) (changed bool, rerr error) {
+	files := []string{c.certFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+
+	contents := make([][]byte, len(files))
+	hash := sha256.New()
+	for i, file := range files {
+		bts, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		contents[i] = bts
+		hash.Write(bts)
+	}
+	sum := hash.Sum(nil)
+
+	c.mu.RLock()
+	same := bytes.Equal(sum, c.hash)
+	c.mu.RUnlock()
+	if same && !force {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, err
+	}
+
+	var cas *x509.CertPool
+	if c.caFile != "" {
+		cas = x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("serviceutil: no certificates found in client CA file %q", c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.cas = cas
+	c.hash = sum
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+func (c *CertReloader) Run(ctx service.Context) error {
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	tick := service.ClockFromContext(ctx).NewTicker(c.interval)
+	defer tick.Stop()
+
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C():
+			if _, err := c.reload(false); err != nil {
+				// Files that are mid-rotation will fail on every tick until
+				// they are fixed; only report each distinct failure once:
+				if err.Error() != lastErr {
+					lastErr = err.Error()
+					ctx.OnError(err)
+				}
+			} else {
+				lastErr = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package serviceutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+type testCert struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	Cert    *x509.Certificate
+	Key     *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for "127.0.0.1" signed by parent, or a
+// self-signed CA if parent is nil.
+func newTestCert(tt assert.T, cn string, parent *testCert) *testCert {
+	tt.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tt.MustOK(err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tt.MustOK(err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Cert, parent.Key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	tt.MustOK(err)
+	cert, err := x509.ParseCertificate(der)
+	tt.MustOK(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	tt.MustOK(err)
+
+	return &testCert{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		Cert:    cert,
+		Key:     key,
+	}
+}
+
+func (c *testCert) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return pool
+}
+
+func (c *testCert) TLSCertificate(tt assert.T) tls.Certificate {
+	tt.Helper()
+	cert, err := tls.X509KeyPair(c.CertPEM, c.KeyPEM)
+	tt.MustOK(err)
+	return cert
+}
+
+func (c *testCert) Write(tt assert.T, certFile, keyFile string) {
+	tt.Helper()
+	tt.MustOK(ioutil.WriteFile(certFile, c.CertPEM, 0600))
+	tt.MustOK(ioutil.WriteFile(keyFile, c.KeyPEM, 0600))
+}
+
+func certCN(tt assert.T, c *CertReloader) string {
+	tt.Helper()
+	cert, err := c.GetCertificate(nil)
+	tt.MustOK(err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	tt.MustOK(err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	newTestCert(tt, "a", nil).Write(tt, certFile, keyFile)
+	c, err := NewCertReloader(certFile, keyFile)
+	tt.MustOK(err)
+	tt.MustEqual("a", certCN(tt, c))
+
+	newTestCert(tt, "b", nil).Write(tt, certFile, keyFile)
+	tt.MustOK(c.Reload())
+	tt.MustEqual("b", certCN(tt, c))
+
+	// A broken cert must not replace the working one:
+	tt.MustOK(ioutil.WriteFile(certFile, []byte("nope"), 0600))
+	tt.MustAssert(c.Reload() != nil)
+	tt.MustEqual("b", certCN(tt, c))
+}
+
+func TestCertReloaderPolls(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	newTestCert(tt, "a", nil).Write(tt, certFile, keyFile)
+	c, err := NewCertReloader(certFile, keyFile, CertReloaderInterval(time.Millisecond))
+	tt.MustOK(err)
+
+	errs := make(chan error, 10)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		errs <- err
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", c)))
+
+	newTestCert(tt, "b", nil).Write(tt, certFile, keyFile)
+	deadline := time.Now().Add(1 * time.Second)
+	for certCN(tt, c) != "b" {
+		tt.MustAssert(time.Now().Before(deadline), "certificate was not reloaded")
+		time.Sleep(time.Millisecond)
+	}
+
+	tt.MustOK(ioutil.WriteFile(certFile, []byte("nope"), 0600))
+	select {
+	case err := <-errs:
+		tt.MustAssert(err != nil)
+	case <-time.After(1 * time.Second):
+		tt.Fatal("reload failure not reported")
+	}
+	tt.MustEqual("b", certCN(tt, c))
+}
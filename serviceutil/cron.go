@@ -0,0 +1,193 @@
+package serviceutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule calculates the next time a Scheduled job should run.
+type Schedule interface {
+	// Next returns the next activation time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+type intervalSchedule time.Duration
+
+// Every returns a Schedule that activates every d, starting d after the
+// Scheduled service is started.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("serviceutil: schedule interval must be > 0")
+	}
+	return intervalSchedule(d)
+}
+
+func (i intervalSchedule) Next(t time.Time) time.Time { return t.Add(time.Duration(i)) }
+
+// cronSchedule is a standard 5-field cron expression, evaluated in the
+// location of the time passed to Next.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// Matches the traditional cron behaviour: if both day-of-month and
+	// day-of-week are restricted, a day matches if either field matches.
+	domStar, dowStar bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a standard 5-field cron expression ("minute hour dom month
+// dow"). Each field accepts '*', single values, ranges ('1-5'), steps ('*/15',
+// '1-30/5') and lists ('1,15,30'). Day of week accepts 0-7, where both 0 and 7
+// are Sunday.
+//
+// The descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are also accepted.
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("serviceutil: cron expression %q must have %d fields, found %d", expr, len(cronFields), len(parts))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("serviceutil: cron expression %q: %v", expr, err)
+		}
+		bits[i] = b
+	}
+
+	cs := &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}
+
+	// Sunday can be either 0 or 7:
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	return cs, nil
+}
+
+// MustParseCron is like ParseCron but panics if the expression is invalid.
+func MustParseCron(expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseCronField(field string, spec cronField) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		lo, hi, step := spec.min, spec.max, 1
+
+		rng := item
+		if idx := strings.IndexByte(item, '/'); idx >= 0 {
+			rng = item[:idx]
+			if step, err = strconv.Atoi(item[idx+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", spec.name, item)
+			}
+		}
+
+		if rng != "*" {
+			if idx := strings.IndexByte(rng, '-'); idx >= 0 {
+				if lo, err = strconv.Atoi(rng[:idx]); err != nil {
+					return 0, fmt.Errorf("invalid range in %s field %q", spec.name, item)
+				}
+				if hi, err = strconv.Atoi(rng[idx+1:]); err != nil {
+					return 0, fmt.Errorf("invalid range in %s field %q", spec.name, item)
+				}
+			} else {
+				if lo, err = strconv.Atoi(rng); err != nil {
+					return 0, fmt.Errorf("invalid value in %s field %q", spec.name, item)
+				}
+				hi = lo
+				if step > 1 {
+					hi = spec.max
+				}
+			}
+		}
+
+		if lo < spec.min || hi > spec.max || lo > hi {
+			return 0, fmt.Errorf("%s field %q out of range %d-%d", spec.name, item, spec.min, spec.max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (cs *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := cs.dom&(1<<uint(t.Day())) != 0
+	dowMatch := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domStar || cs.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (cs *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+
+	// If nothing matches within 5 years, the expression can never match
+	// (i.e. Feb 30th):
+	limit := t.Year() + 5
+
+	for t.Year() <= limit {
+		if cs.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !cs.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if cs.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if cs.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,314 @@
+package serviceutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	DefaultFileWatcherInterval = 1 * time.Second
+	DefaultFileWatcherDebounce = 100 * time.Millisecond
+)
+
+type FileOp int
+
+const (
+	FileCreated FileOp = iota + 1
+	FileModified
+	FileDeleted
+)
+
+func (op FileOp) String() string {
+	switch op {
+	case FileCreated:
+		return "created"
+	case FileModified:
+		return "modified"
+	case FileDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+type FileEvent struct {
+	Path string
+	Op   FileOp
+}
+
+// FileWatcher is an experimental service.Runnable that polls files for
+// changes. It is portable, but it is not suitable for watching large numbers
+// of files.
+//
+// Each path passed to NewFileWatcher may be a glob, as per filepath.Match.
+// Matching directories are expanded to the files they contain; they are not
+// watched recursively. Globs are re-evaluated on every poll, so new files
+// that match are reported as created.
+//
+// A file is considered modified if its mtime, size or mode has changed, or
+// if the hash of its contents has changed. Hashing can be disabled with
+// FileWatcherHash(false) if the files are large.
+//
+// Events are debounced: they are collected until no changes have been seen
+// for the period supplied by FileWatcherDebounce, then delivered as a single
+// batch sorted by path. Several changes to the same file within a batch are
+// collapsed into one event; a file that is created then deleted is not
+// reported at all.
+//
+// Batches are delivered to the callback supplied by FileWatcherFunc, or
+// otherwise to Events(). The watcher blocks until the batch is accepted.
+//
+// The watcher is Ready once the initial scan has completed. Errors from
+// individual files during a scan are passed to ctx.OnError() and the file is
+// treated as unchanged.
+type FileWatcher struct {
+	patterns []string
+	interval time.Duration
+	debounce time.Duration
+	hash     bool
+	fn       func(events []FileEvent)
+	events   chan []FileEvent
+}
+
+var _ service.Runnable = &FileWatcher{}
+
+type FileWatcherOption func(w *FileWatcher)
+
+// FileWatcherInterval sets how often the files are polled. The default is
+// DefaultFileWatcherInterval.
+func FileWatcherInterval(d time.Duration) FileWatcherOption {
+	return func(w *FileWatcher) { w.interval = d }
+}
+
+// FileWatcherDebounce sets how long the files must remain unchanged before a
+// batch of events is delivered. The default is DefaultFileWatcherDebounce.
+func FileWatcherDebounce(d time.Duration) FileWatcherOption {
+	return func(w *FileWatcher) { w.debounce = d }
+}
+
+// FileWatcherHash enables or disables comparing the hash of each file's
+// contents. It is enabled by default.
+func FileWatcherHash(enabled bool) FileWatcherOption {
+	return func(w *FileWatcher) { w.hash = enabled }
+}
+
+// FileWatcherFunc delivers batches of events to fn instead of Events(). fn is
+// called from the watcher's Run goroutine; polling is paused until it
+// returns.
+func FileWatcherFunc(fn func(events []FileEvent)) FileWatcherOption {
+	return func(w *FileWatcher) { w.fn = fn }
+}
+
+func NewFileWatcher(paths []string, options ...FileWatcherOption) *FileWatcher {
+	if len(paths) == 0 {
+		panic("no paths")
+	}
+	w := &FileWatcher{
+		patterns: paths,
+		interval: DefaultFileWatcherInterval,
+		debounce: DefaultFileWatcherDebounce,
+		hash:     true,
+	}
+	for _, o := range options {
+		o(w)
+	}
+	if w.fn == nil {
+		w.events = make(chan []FileEvent)
+	}
+	return w
+}
+
+// Events returns the channel batches of events are delivered to, or nil if
+// FileWatcherFunc was used.
+func (w *FileWatcher) Events() <-chan []FileEvent { return w.events }
+
+type fileSnapshot struct {
+	mtime time.Time
+	size  int64
+	mode  os.FileMode
+	hash  []byte
+}
+
+func (s fileSnapshot) same(o fileSnapshot) bool {
+	return s.mtime.Equal(o.mtime) && s.size == o.size && s.mode == o.mode && bytes.Equal(s.hash, o.hash)
+}
+
+func (w *FileWatcher) Run(ctx service.Context) error {
+	for _, pattern := range w.patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	files := w.scan(ctx, nil)
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	clock := service.ClockFromContext(ctx)
+	tick := clock.NewTicker(w.interval)
+	defer tick.Stop()
+
+	debounce := clock.NewTimer(w.debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	pending := make(map[string]FileOp)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-tick.C():
+			next := w.scan(ctx, files)
+			if changed := diffFiles(files, next, pending); changed {
+				if !debounce.Stop() {
+					select {
+					case <-debounce.C():
+					default:
+					}
+				}
+				debounce.Reset(w.debounce)
+			}
+			files = next
+
+		case <-debounce.C():
+			if len(pending) == 0 {
+				continue
+			}
+			events := make([]FileEvent, 0, len(pending))
+			for path, op := range pending {
+				events = append(events, FileEvent{Path: path, Op: op})
+				delete(pending, path)
+			}
+			sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+
+			if w.fn != nil {
+				w.fn(events)
+			} else {
+				select {
+				case w.events <- events:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// scan snapshots every file matching the patterns. If a file can't be read,
+// the error is reported and its entry in prev is carried over.
+func (w *FileWatcher) scan(ctx service.Context, prev map[string]fileSnapshot) map[string]fileSnapshot {
+	files := make(map[string]fileSnapshot)
+
+	add := func(path string, fi os.FileInfo) {
+		snap, err := w.snapshot(path, fi)
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			ctx.OnError(err)
+			if old, ok := prev[path]; ok {
+				files[path] = old
+			}
+			return
+		}
+		files[path] = snap
+	}
+
+	for _, pattern := range w.patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			fi, err := os.Stat(match)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				ctx.OnError(err)
+				continue
+			}
+
+			if !fi.IsDir() {
+				add(match, fi)
+				continue
+			}
+
+			entries, err := ioutil.ReadDir(match)
+			if err != nil {
+				ctx.OnError(err)
+				continue
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					add(filepath.Join(match, entry.Name()), entry)
+				}
+			}
+		}
+	}
+	return files
+}
+
+func (w *FileWatcher) snapshot(path string, fi os.FileInfo) (snap fileSnapshot, err error) {
+	snap = fileSnapshot{mtime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+	if !w.hash {
+		return snap, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return snap, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return snap, err
+	}
+	snap.hash = h.Sum(nil)
+	return snap, nil
+}
+
+// diffFiles merges the differences between prev and next into pending,
+// collapsing them with any events already pending for the same path. It
+// returns true if anything changed.
+func diffFiles(prev, next map[string]fileSnapshot, pending map[string]FileOp) (changed bool) {
+	merge := func(path string, op FileOp) {
+		changed = true
+		old, ok := pending[path]
+		if !ok {
+			pending[path] = op
+			return
+		}
+		switch {
+		case old == FileCreated && op == FileDeleted:
+			delete(pending, path)
+		case old == FileCreated:
+			// Still created, no matter how much it was modified.
+		case old == FileDeleted && op == FileCreated:
+			pending[path] = FileModified
+		default:
+			pending[path] = op
+		}
+	}
+
+	for path, snap := range next {
+		if old, ok := prev[path]; !ok {
+			merge(path, FileCreated)
+		} else if !old.same(snap) {
+			merge(path, FileModified)
+		}
+	}
+	for path := range prev {
+		if _, ok := next[path]; !ok {
+			merge(path, FileDeleted)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,146 @@
+package serviceutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func startFileWatcher(tt assert.T, w *FileWatcher) service.Runner {
+	tt.Helper()
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("watcher", w)))
+	return runner
+}
+
+func nextFileEvents(tt assert.T, w *FileWatcher) []FileEvent {
+	tt.Helper()
+	select {
+	case events := <-w.Events():
+		return events
+	case <-time.After(1 * time.Second):
+		tt.Fatal("timed out waiting for events")
+		return nil
+	}
+}
+
+func TestFileWatcherGlob(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+
+	a, b, c := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf"), filepath.Join(dir, "c.conf")
+	ignored := filepath.Join(dir, "ignored.txt")
+	tt.MustOK(ioutil.WriteFile(b, []byte("b"), 0644))
+	tt.MustOK(ioutil.WriteFile(c, []byte("c"), 0644))
+
+	w := NewFileWatcher([]string{filepath.Join(dir, "*.conf")},
+		FileWatcherInterval(2*time.Millisecond),
+		FileWatcherDebounce(20*time.Millisecond))
+	runner := startFileWatcher(tt, w)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	tt.MustOK(ioutil.WriteFile(a, []byte("a"), 0644))
+	tt.MustOK(ioutil.WriteFile(b, []byte("bb"), 0644))
+	tt.MustOK(os.Remove(c))
+	tt.MustOK(ioutil.WriteFile(ignored, []byte("nope"), 0644))
+
+	tt.MustEqual([]FileEvent{
+		{Path: a, Op: FileCreated},
+		{Path: b, Op: FileModified},
+		{Path: c, Op: FileDeleted},
+	}, nextFileEvents(tt, w))
+}
+
+func TestFileWatcherHash(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	tt.MustOK(ioutil.WriteFile(path, []byte("aaaa"), 0644))
+	fi, err := os.Stat(path)
+	tt.MustOK(err)
+
+	w := NewFileWatcher([]string{path},
+		FileWatcherInterval(2*time.Millisecond),
+		FileWatcherDebounce(5*time.Millisecond))
+	runner := startFileWatcher(tt, w)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	// Same size, same mtime; only the hash can tell:
+	tt.MustOK(ioutil.WriteFile(path, []byte("bbbb"), 0644))
+	tt.MustOK(os.Chtimes(path, fi.ModTime(), fi.ModTime()))
+
+	tt.MustEqual([]FileEvent{{Path: path, Op: FileModified}}, nextFileEvents(tt, w))
+}
+
+func TestFileWatcherDebounceCollapses(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+
+	transient, kept := filepath.Join(dir, "transient"), filepath.Join(dir, "kept")
+
+	batches := make(chan []FileEvent, 10)
+	w := NewFileWatcher([]string{dir},
+		FileWatcherInterval(2*time.Millisecond),
+		FileWatcherDebounce(50*time.Millisecond),
+		FileWatcherFunc(func(events []FileEvent) { batches <- events }))
+	tt.MustAssert(w.Events() == nil)
+
+	runner := startFileWatcher(tt, w)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	tt.MustOK(ioutil.WriteFile(transient, []byte("1"), 0644))
+	tt.MustOK(ioutil.WriteFile(kept, []byte("1"), 0644))
+	time.Sleep(10 * time.Millisecond)
+	tt.MustOK(os.Remove(transient))
+	tt.MustOK(ioutil.WriteFile(kept, []byte("22"), 0644))
+
+	select {
+	case events := <-batches:
+		tt.MustEqual([]FileEvent{{Path: kept, Op: FileCreated}}, events)
+	case <-time.After(1 * time.Second):
+		tt.Fatal("timed out waiting for events")
+	}
+}
+
+func TestFileWatcherHaltsWhileBlocked(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+
+	w := NewFileWatcher([]string{dir},
+		FileWatcherInterval(1*time.Millisecond),
+		FileWatcherDebounce(1*time.Millisecond))
+	runner := startFileWatcher(tt, w)
+
+	// Nobody reads the events, so the watcher blocks trying to deliver them:
+	tt.MustOK(ioutil.WriteFile(filepath.Join(dir, "file"), []byte("1"), 0644))
+	time.Sleep(20 * time.Millisecond)
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+}
+
+func TestFileWatcherBadPattern(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	w := NewFileWatcher([]string{"["})
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustEqual(filepath.ErrBadPattern, service.StartTimeout(1*time.Second, runner, service.New("watcher", w)))
+}
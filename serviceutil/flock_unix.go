@@ -0,0 +1,25 @@
+// +build !windows
+
+package serviceutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile attempts to take an exclusive flock on f without blocking. It
+// returns false if another open file description holds the lock, even one in
+// the same process.
+func tryLockFile(f *os.File) (ok bool, err error) {
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,18 @@
+// +build windows
+
+package serviceutil
+
+import (
+	"errors"
+	"os"
+)
+
+var errFlockUnsupported = errors.New("serviceutil: file locks not supported")
+
+func tryLockFile(f *os.File) (ok bool, err error) {
+	return false, errFlockUnsupported
+}
+
+func unlockFile(f *os.File) error {
+	return errFlockUnsupported
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -26,7 +28,49 @@ type HTTP struct {
 	CertFile string // If TLS == true, use this file for the certificate.
 	KeyFile  string // If TLS == true, use this file for the key.
 
-	port int32
+	// Listeners allows the Server to be served on more than one address at
+	// once. If empty, a single listener is created using Server.Addr and the
+	// TLS settings above. If not empty, Server.Addr and the TLS settings
+	// above are ignored.
+	Listeners []HTTPListener
+
+	// H2C enables HTTP/2 over cleartext connections (with prior knowledge)
+	// using the standard library. It requires Go 1.24 or later; Run will fail
+	// if it is not supported.
+	H2C bool
+
+	// ReadyProbe, if set, is polled against the first listener once the
+	// server is serving. The service is not Ready until the probe succeeds.
+	// If it has not succeeded within ReadyTimeout, the service fails to start
+	// with the probe's last error.
+	//
+	// TLS listeners are probed without verifying the server's certificate.
+	// Listeners that require client certificates can't be probed.
+	ReadyProbe HTTPProbe
+
+	// ReadyTimeout is the start deadline for ReadyProbe. The default is
+	// DefaultHTTPReadyTimeout.
+	ReadyTimeout time.Duration
+
+	// ReadyInterval is how often ReadyProbe is polled. The default is
+	// DefaultHTTPReadyInterval.
+	ReadyInterval time.Duration
+
+	addrs    []net.Addr
+	addrsMu  sync.Mutex
+	draining int32
+	stats    httpStats
+}
+
+// HTTPListener describes an address an HTTP service should listen on.
+type HTTPListener struct {
+	// Network is "tcp", "tcp4", "tcp6" or "unix". If empty, "tcp" is used.
+	Network string
+	Addr    string
+
+	// If TLSConfig is not nil, connections accepted by this listener are
+	// served using TLS with this config.
+	TLSConfig *tls.Config
 }
 
 var _ service.Runnable = &HTTP{}
@@ -35,35 +79,86 @@ func NewHTTP(server *http.Server) *HTTP {
 	return &HTTP{Server: server}
 }
 
-func (h *HTTP) Port() int { return int(atomic.LoadInt32(&h.port)) }
+// AddListener adds an address to listen on. See HTTP.Listeners.
+func (h *HTTP) AddListener(network, addr string, tlsConfig *tls.Config) *HTTP {
+	h.Listeners = append(h.Listeners, HTTPListener{Network: network, Addr: addr, TLSConfig: tlsConfig})
+	return h
+}
+
+// Port returns the addresses the server is bound to, in the same order as
+// HTTP.Listeners. It returns nil if the server is not running.
+func (h *HTTP) Port() (out []net.Addr) {
+	h.addrsMu.Lock()
+	out = append(out, h.addrs...)
+	h.addrsMu.Unlock()
+	return out
+}
+
+// Healthy reports false once the service has started draining. See
+// service.Runner.Drain().
+func (h *HTTP) Healthy() bool { return atomic.LoadInt32(&h.draining) == 0 }
+
+// HealthHandler returns an http.Handler that responds with 200 OK while the
+// service is healthy, and 503 Service Unavailable once it has started
+// draining. Mount it wherever your load balancer expects a health check.
+func (h *HTTP) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Healthy() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// SetTLS configures the HTTP server to use TLS, applying the options to
+// Server.TLSConfig. If Server.TLSConfig is nil, a new one is created.
+//
+// Options are applied in order to the existing config, so SetTLS may be
+// called more than once, or after SetTLSCertReloader:
+//
+//	err := h.SetTLS(
+//		TLSCertFile("cert.pem", "key.pem"),
+//		TLSClientCAFile("ca.pem"),
+//		TLSMinVersion(tls.VersionTLS12),
+//	)
+//
+// SetTLS only affects the listener created from Server.Addr. Use
+// NewTLSConfig to build the TLSConfig for entries in HTTP.Listeners.
+func (h *HTTP) SetTLS(options ...TLSOption) error {
+	if h.Server.TLSConfig == nil {
+		h.Server.TLSConfig = &tls.Config{}
+	}
+	h.TLS = true
+	return applyTLSOptions(h.Server.TLSConfig, options)
+}
 
 // SetTLSCertFile configures the HTTP server to use TLS with a cert file and a
-// key file
+// key file. The files are loaded when the service starts.
 //
 // Any certificates set in Server.TLSConfig are cleared.
 func (h *HTTP) SetTLSCertFile(certFile, keyFile string) {
 	h.TLS = true
 	h.CertFile = certFile
 	h.KeyFile = keyFile
-	h.Server.TLSConfig.Certificates = nil
 	if h.Server.TLSConfig == nil {
 		h.Server.TLSConfig = &tls.Config{}
 	}
+	h.Server.TLSConfig.Certificates = nil
 }
 
-// SetTLSCertFile configures the HTTP server to use TLS with a cert and key
+// SetTLSCert configures the HTTP server to use TLS with a cert and key
 // supplied as byte arrays.
 //
 // Any certificates set in Server.TLSConfig, or set in CertFile/KeyFile are
 // cleared.
 func (h *HTTP) SetTLSCert(certPem, keyPem []byte) error {
-	h.TLS = true
-	h.CertFile, h.KeyFile = "", ""
-
-	key, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	key, err := tls.X509KeyPair(certPem, keyPem)
 	if err != nil {
 		return err
 	}
+	h.TLS = true
+	h.CertFile, h.KeyFile = "", ""
 	if h.Server.TLSConfig == nil {
 		h.Server.TLSConfig = &tls.Config{}
 	}
@@ -71,6 +166,23 @@ func (h *HTTP) SetTLSCert(certPem, keyPem []byte) error {
 	return nil
 }
 
+// SetTLSCertReloader configures the HTTP server to use TLS with certificates
+// supplied by a CertReloader. The CertReloader should be started as a service
+// alongside the HTTP service if you want it to pick up changes automatically.
+//
+// Server.TLSConfig, CertFile and KeyFile are replaced. SetTLS may be called
+// afterwards to apply other options.
+//
+// The protocols offered via ALPN are chosen when this is called, so disable
+// HTTP/2 on Server beforehand if you don't want it; see CertReloader.TLSConfig.
+func (h *HTTP) SetTLSCertReloader(c *CertReloader) {
+	h.TLS = true
+	h.CertFile, h.KeyFile = "", ""
+	config := c.TLSConfig()
+	config.NextProtos = tlsNextProtos(h.Server)
+	h.Server.TLSConfig = config
+}
+
 func (h *HTTP) addr() string {
 	addr := h.Server.Addr
 	if addr == "" && !h.TLS {
@@ -81,54 +193,227 @@ func (h *HTTP) addr() string {
 	return addr
 }
 
-// Run the HTTP server as a service.Service.
-func (h *HTTP) Run(ctx service.Context) (rerr error) {
-	done := make(chan struct{})
-	failer := service.NewFailureListener(1)
+func (h *HTTP) listeners() []HTTPListener {
+	if len(h.Listeners) > 0 {
+		return h.Listeners
+	}
+	return []HTTPListener{{Network: "tcp", Addr: h.addr()}}
+}
+
+// listen binds every listener, or none of them.
+func (h *HTTP) listen() (lns []net.Listener, rerr error) {
+	defer func() {
+		if rerr != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			lns = nil
+		}
+	}()
 
-	go func() {
-		defer close(done)
-		defer atomic.StoreInt32(&h.port, 0)
+	for _, hl := range h.listeners() {
+		network := hl.Network
+		if network == "" {
+			network = "tcp"
+		}
+		if network == "unix" {
+			if err := removeStaleSocket(hl.Addr); err != nil {
+				return lns, err
+			}
+		}
 
-		ln, err := net.Listen("tcp", h.addr())
+		ln, err := net.Listen(network, hl.Addr)
 		if err != nil {
-			failer.Send(err)
-			return
+			return lns, err
+		}
+		if tcp, ok := ln.(*net.TCPListener); ok {
+			ln = tcpKeepAliveListener{TCPListener: tcp}
 		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
 
-		atomic.StoreInt32(&h.port, int32(ln.Addr().(*net.TCPAddr).Port))
-		if err := ctx.Ready(); err != nil {
-			failer.Send(err)
-			return
+func (h *HTTP) serve(srv *http.Server, ln net.Listener, hl HTTPListener) error {
+	if len(h.Listeners) == 0 {
+		if h.TLS || srv.TLSConfig != nil {
+			return srv.ServeTLS(ln, h.CertFile, h.KeyFile)
 		}
+		return srv.Serve(ln)
+	}
 
-		keep := tcpKeepAliveListener{TCPListener: ln.(*net.TCPListener)}
-		if h.Server.TLSConfig != nil {
-			failer.SendNonNil(h.Server.ServeTLS(keep, "", ""))
-		} else {
-			failer.SendNonNil(h.Server.Serve(keep))
+	if hl.TLSConfig != nil {
+		config := hl.TLSConfig.Clone()
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = tlsNextProtos(srv)
 		}
+		ln = tls.NewListener(ln, config)
+	}
+	return srv.Serve(ln)
+}
+
+// tlsNextProtos returns the protocols srv can serve over TLS, for configs that
+// net/http does not set up itself.
+func tlsNextProtos(srv *http.Server) []string {
+	if http2Enabled(srv) {
+		return []string{"h2", "http/1.1"}
+	}
+	return []string{"http/1.1"}
+}
+
+// cloneServer returns a new http.Server with the exported fields of srv. Its
+// unexported state, such as listeners and shutdown hooks, starts out empty.
+func cloneServer(srv *http.Server) *http.Server {
+	out := &http.Server{}
+	from, to := reflect.ValueOf(srv).Elem(), reflect.ValueOf(out).Elem()
+	for i, n := 0, from.NumField(); i < n; i++ {
+		if from.Type().Field(i).PkgPath == "" {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+	return out
+}
+
+// Run the HTTP server as a service.Service.
+//
+// The service is Ready once every listener has been bound and, if set,
+// ReadyProbe has succeeded. If any listener fails to bind, the service fails
+// to start.
+//
+// Each run serves a copy of Server's exported fields, with ConnState and
+// Handler wrapped to collect connection and request stats; see ActiveConns()
+// and friends. Server itself is not modified, so functions registered with
+// Server.RegisterOnShutdown are not called, and calling Server.Shutdown or
+// Server.Close has no effect on the service; halt it instead.
+//
+// When the service is halted, connections are given ShutdownTimeout to
+// finish. Any that remain are forcibly closed and an *HTTPShutdownTimeout is
+// passed to ctx.OnError(). Any other error from shutting down the server is
+// returned.
+func (h *HTTP) Run(ctx service.Context) (rerr error) {
+	atomic.StoreInt32(&h.draining, 0)
+	h.resetStats()
+	srv := h.instrument(cloneServer(h.Server))
+
+	if h.H2C {
+		if err := enableH2C(srv); err != nil {
+			return err
+		}
+	}
+
+	lns, err := h.listen()
+	if err != nil {
+		return err
+	}
+
+	addrs := make([]net.Addr, len(lns))
+	for i, ln := range lns {
+		addrs[i] = ln.Addr()
+	}
+	h.addrsMu.Lock()
+	h.addrs = addrs
+	h.addrsMu.Unlock()
+
+	defer func() {
+		h.addrsMu.Lock()
+		h.addrs = nil
+		h.addrsMu.Unlock()
 	}()
 
+	hls := h.listeners()
+
+	// This must be checked before serving; net/http modifies srv.TLSConfig:
+	useTLS := hls[0].TLSConfig != nil
+	if len(h.Listeners) == 0 {
+		useTLS = h.TLS || srv.TLSConfig != nil
+	}
+
+	var wg sync.WaitGroup
+	failer := service.NewFailureListener(len(lns))
+	for i, ln := range lns {
+		wg.Add(1)
+		go func(ln net.Listener, hl HTTPListener) {
+			defer wg.Done()
+
+			// If serve fails before the listener is handed to the Server,
+			// Shutdown won't know to close it:
+			defer ln.Close()
+
+			if err := h.serve(srv, ln, hl); err != http.ErrServerClosed {
+				failer.SendNonNil(err)
+			}
+		}(ln, hls[i])
+	}
+
 	defer func() {
 		to := h.ShutdownTimeout
 		if to <= 0 {
 			to = DefaultShutdownTimeout
 		}
-		hctx, cancelFunc := context.WithTimeout(context.Background(), to)
-		defer cancelFunc()
-		if err := h.Server.Shutdown(hctx); err != nil && rerr == nil {
+		if err := h.shutdown(ctx, srv, to); err != nil && rerr == nil {
 			rerr = err
 		}
-		<-done
+		wg.Wait()
 	}()
 
-	select {
-	case err := <-failer.Failures():
+	if h.ReadyProbe != nil {
+		if err := h.waitReady(ctx, lns[0].Addr(), useTLS, failer.Failures()); err != nil {
+			return err
+		}
+		if ctx.ShouldHalt() {
+			return nil
+		}
+	}
+
+	if err := ctx.Ready(); err != nil {
 		return err
-	case <-ctx.Done():
-		return nil
 	}
+
+	draining := service.DrainingFromContext(ctx)
+	for {
+		select {
+		case err := <-failer.Failures():
+			return err
+		case <-draining:
+			// Stop handing out keep-alive connections so clients reconnect
+			// elsewhere, but keep serving until we are halted:
+			draining = nil
+			atomic.StoreInt32(&h.draining, 1)
+			srv.SetKeepAlivesEnabled(false)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// shutdown gracefully shuts down the server, forcibly closing any connections
+// that remain after the timeout. The timeout is reported to ctx.OnError();
+// any other error is returned.
+func (h *HTTP) shutdown(ctx service.Context, srv *http.Server, timeout time.Duration) (rerr error) {
+	start := time.Now()
+	hctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	var forced int
+	if err := srv.Shutdown(hctx); err == context.DeadlineExceeded {
+		serr := &HTTPShutdownTimeout{
+			Timeout:     timeout,
+			ForceClosed: h.ActiveConns() + h.IdleConns(),
+			InFlight:    h.InFlight(),
+		}
+		forced = serr.ForceClosed
+		srv.Close()
+		ctx.OnError(serr)
+	} else if err != nil {
+		// Shutdown also reports errors from closing the listeners, which
+		// have nothing to do with the timeout:
+		srv.Close()
+		rerr = err
+	}
+
+	atomic.StoreInt64(&h.stats.lastDrain, int64(time.Since(start)))
+	atomic.StoreInt32(&h.stats.lastForceClosed, int32(forced))
+	return rerr
 }
 
 type tcpKeepAliveListener struct {
@@ -0,0 +1,27 @@
+// +build go1.24
+
+package serviceutil
+
+import "net/http"
+
+func enableH2C(srv *http.Server) error {
+	var protocols http.Protocols
+	if srv.Protocols != nil {
+		protocols = *srv.Protocols
+	} else {
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	protocols.SetUnencryptedHTTP2(true)
+	srv.Protocols = &protocols
+	return nil
+}
+
+// http2Enabled reports whether srv serves HTTP/2 over TLS. Server.Protocols
+// takes precedence; otherwise a non-nil TLSNextProto without "h2" disables it.
+func http2Enabled(srv *http.Server) bool {
+	if srv.Protocols != nil {
+		return srv.Protocols.HTTP2()
+	}
+	return srv.TLSNextProto == nil || srv.TLSNextProto["h2"] != nil
+}
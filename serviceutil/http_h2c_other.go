@@ -0,0 +1,20 @@
+// +build !go1.24
+
+package serviceutil
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errH2CUnsupported = errors.New("serviceutil: h2c requires go1.24 or later")
+
+func enableH2C(srv *http.Server) error {
+	return errH2CUnsupported
+}
+
+// http2Enabled reports whether srv serves HTTP/2 over TLS. A non-nil
+// TLSNextProto without "h2" disables it.
+func http2Enabled(srv *http.Server) bool {
+	return srv.TLSNextProto == nil || srv.TLSNextProto["h2"] != nil
+}
@@ -0,0 +1,45 @@
+// +build go1.24
+
+package serviceutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestHTTPH2C(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	server := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	h := NewHTTP(server)
+	h.H2C = true
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	tr := &http.Transport{Protocols: &protocols}
+	defer tr.CloseIdleConnections()
+
+	rs, err := (&http.Client{Transport: tr}).Get("http://" + h.Port()[0].String())
+	tt.MustOK(err)
+	defer rs.Body.Close()
+	b, err := ioutil.ReadAll(rs.Body)
+	tt.MustOK(err)
+	tt.MustEqual("HTTP/2.0", string(b))
+
+	// h2c is enabled on the copy of the server that is served:
+	tt.MustAssert(server.Protocols == nil)
+}
@@ -0,0 +1,120 @@
+package serviceutil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	DefaultHTTPReadyTimeout  = 10 * time.Second
+	DefaultHTTPReadyInterval = 50 * time.Millisecond
+)
+
+// HTTPProbe checks whether an HTTP service is ready to serve. client is
+// connected to the service's own listener, and baseURL is the scheme and
+// host to use with it, i.e. "http://127.0.0.1:1234".
+//
+// See HTTP.ReadyProbe.
+type HTTPProbe func(ctx context.Context, client *http.Client, baseURL string) error
+
+// HTTPProbePath succeeds once a GET request for path returns a 2xx status.
+func HTTPProbePath(path string) HTTPProbe {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return func(ctx context.Context, client *http.Client, baseURL string) error {
+		rq, err := http.NewRequest("GET", baseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		rs, err := client.Do(rq.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		rs.Body.Close()
+		if rs.StatusCode < 200 || rs.StatusCode >= 300 {
+			return fmt.Errorf("serviceutil: probe %q returned %s", path, rs.Status)
+		}
+		return nil
+	}
+}
+
+// probeClient creates an http.Client that always dials addr, the address of
+// one of the service's own listeners.
+func probeClient(addr net.Addr, useTLS bool) (client *http.Client, baseURL string) {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, addr.Network(), addr.String())
+		},
+		DisableKeepAlives: true,
+	}
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+		// We're talking to ourselves, so there's nothing to verify; the
+		// certificate may not even be valid for the address we dial:
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	host := "localhost"
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		host = tcp.String()
+	}
+	return &http.Client{Transport: transport}, scheme + "://" + host
+}
+
+// waitReady polls ReadyProbe against addr until it succeeds, the
+// ReadyTimeout expires, the service is halted or one of the listeners fails.
+func (h *HTTP) waitReady(ctx service.Context, addr net.Addr, useTLS bool, failures <-chan error) error {
+	timeout, interval := h.ReadyTimeout, h.ReadyInterval
+	if timeout <= 0 {
+		timeout = DefaultHTTPReadyTimeout
+	}
+	if interval <= 0 {
+		interval = DefaultHTTPReadyInterval
+	}
+
+	clock := service.ClockFromContext(ctx)
+	pctx, cancel := service.WithClockTimeout(ctx, clock, timeout)
+	defer cancel()
+
+	client, baseURL := probeClient(addr, useTLS)
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+
+	tick := clock.NewTicker(interval)
+	defer tick.Stop()
+
+	var lastErr error
+	for {
+		err := h.ReadyProbe(pctx, client, baseURL)
+		if err == nil {
+			return nil
+		}
+
+		// If the deadline interrupted a probe that was in progress, the
+		// error from the previous attempt is more useful:
+		if pctx.Err() == nil || lastErr == nil {
+			lastErr = err
+		}
+
+		select {
+		case <-tick.C():
+		case ferr := <-failures:
+			return ferr
+		case <-pctx.Done():
+			if ctx.ShouldHalt() {
+				return nil
+			}
+			return lastErr
+		}
+	}
+}
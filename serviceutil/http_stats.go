@@ -0,0 +1,136 @@
+package serviceutil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPShutdownTimeout is passed to ctx.OnError() if the HTTP service's
+// connections have not finished by the time HTTP.ShutdownTimeout expires.
+// The remaining connections are forcibly closed.
+type HTTPShutdownTimeout struct {
+	Timeout     time.Duration
+	ForceClosed int
+	InFlight    int
+}
+
+func (e *HTTPShutdownTimeout) Error() string {
+	return fmt.Sprintf("serviceutil: http shutdown timed out after %s; forcibly closed %d connection(s) with %d request(s) in flight",
+		e.Timeout, e.ForceClosed, e.InFlight)
+}
+
+func IsHTTPShutdownTimeout(err error) bool {
+	_, ok := err.(*HTTPShutdownTimeout)
+	return ok
+}
+
+// httpStats tracks connections via http.Server.ConnState and requests via
+// a handler wrapper.
+type httpStats struct {
+	accepted        uint64
+	hijacked        uint64
+	inFlight        int32
+	lastDrain       int64
+	lastForceClosed int32
+
+	conns map[net.Conn]http.ConnState
+	mu    sync.Mutex
+}
+
+// ActiveConns returns the number of connections that are new or are
+// currently serving a request.
+func (h *HTTP) ActiveConns() int { return h.countConns(http.StateNew, http.StateActive) }
+
+// IdleConns returns the number of keep-alive connections waiting for a new
+// request.
+func (h *HTTP) IdleConns() int { return h.countConns(http.StateIdle) }
+
+// HijackedConns returns the total number of connections that have been
+// hijacked since the service was started. Hijacked connections are no longer
+// managed by the server, so it can't tell when they are closed.
+func (h *HTTP) HijackedConns() uint64 { return atomic.LoadUint64(&h.stats.hijacked) }
+
+// AcceptedConns returns the total number of connections accepted since the
+// service was started.
+func (h *HTTP) AcceptedConns() uint64 { return atomic.LoadUint64(&h.stats.accepted) }
+
+// InFlight returns the number of requests currently being handled.
+func (h *HTTP) InFlight() int { return int(atomic.LoadInt32(&h.stats.inFlight)) }
+
+// LastDrainDuration returns how long the last shutdown took to finish serving
+// the remaining connections, including any time spent waiting for the
+// shutdown timeout to expire.
+func (h *HTTP) LastDrainDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.stats.lastDrain))
+}
+
+// LastForceClosed returns the number of connections that were forcibly closed
+// when the last shutdown timed out.
+func (h *HTTP) LastForceClosed() int { return int(atomic.LoadInt32(&h.stats.lastForceClosed)) }
+
+func (h *HTTP) countConns(states ...http.ConnState) (n int) {
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	for _, state := range h.stats.conns {
+		for _, s := range states {
+			if state == s {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// resetStats clears the counters that only apply to a single run. The results
+// of the last shutdown are retained until the next one. inFlight is left
+// alone as handlers from the last run may still be returning.
+func (h *HTTP) resetStats() {
+	atomic.StoreUint64(&h.stats.accepted, 0)
+	atomic.StoreUint64(&h.stats.hijacked, 0)
+	h.stats.mu.Lock()
+	h.stats.conns = make(map[net.Conn]http.ConnState)
+	h.stats.mu.Unlock()
+}
+
+// instrument wraps srv.ConnState and srv.Handler to collect stats. srv must
+// be the copy of Server made for a single run, not Server itself.
+func (h *HTTP) instrument(srv *http.Server) *http.Server {
+	connState, handler := srv.ConnState, srv.Handler
+
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		h.stats.mu.Lock()
+		switch state {
+		case http.StateNew:
+			atomic.AddUint64(&h.stats.accepted, 1)
+			h.stats.conns[conn] = state
+		case http.StateHijacked:
+			atomic.AddUint64(&h.stats.hijacked, 1)
+			delete(h.stats.conns, conn)
+		case http.StateClosed:
+			delete(h.stats.conns, conn)
+		default:
+			h.stats.conns[conn] = state
+		}
+		h.stats.mu.Unlock()
+
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+
+	next := handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&h.stats.inFlight, 1)
+		defer atomic.AddInt32(&h.stats.inFlight, -1)
+		next.ServeHTTP(w, r)
+	})
+	return srv
+}
@@ -1,9 +1,16 @@
 package serviceutil
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -27,10 +34,354 @@ func TestHTTP(t *testing.T) {
 	defer service.MustShutdownTimeout(1*time.Second, runner)
 	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
 
-	hc, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d", h.Port()))
+	hc, err := http.Get("http://" + h.Port()[0].String())
 	tt.MustOK(err)
 	b, err := ioutil.ReadAll(hc.Body)
 	tt.MustOK(err)
 	tt.MustEqual(string(b), string(bts))
 	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
 }
+
+func TestHTTPDrainUnhealthy(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mux := http.NewServeMux()
+	h := NewHTTP(&http.Server{Addr: ":0", Handler: mux})
+	mux.Handle("/health", h.HealthHandler())
+
+	runner := service.NewRunner(service.RunnerDrainPeriod(200 * time.Millisecond))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	svc := service.New("http", h)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, svc))
+	url := "http://" + h.Port()[0].String() + "/health"
+
+	rs, err := http.Get(url)
+	tt.MustOK(err)
+	rs.Body.Close()
+	tt.MustEqual(http.StatusOK, rs.StatusCode)
+
+	drained := make(chan error, 1)
+	go func() { drained <- runner.Drain(context.Background(), svc) }()
+
+	for h.Healthy() {
+		time.Sleep(time.Millisecond)
+	}
+	rs, err = http.Get(url)
+	tt.MustOK(err)
+	rs.Body.Close()
+	tt.MustEqual(http.StatusServiceUnavailable, rs.StatusCode)
+
+	tt.MustOK(<-drained)
+}
+
+func TestHTTPHealthDrainTransition(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	h := NewHTTP(&http.Server{Addr: ":0", Handler: http.NotFoundHandler()})
+	health := h.HealthHandler()
+	status := func() int {
+		rec := httptest.NewRecorder()
+		health.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+		return rec.Code
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(1 * time.Second)
+		for !cond() {
+			tt.MustAssert(time.Now().Before(deadline), "timed out")
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	runner := service.NewRunner(service.RunnerDrainPeriod(50 * time.Millisecond))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	svc := service.New("http", h)
+
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, svc))
+	tt.MustAssert(h.Healthy())
+	tt.MustEqual(http.StatusOK, status())
+
+	// The service becomes unhealthy as soon as it starts draining, while it
+	// is still running:
+	drained := make(chan error, 1)
+	go func() { drained <- runner.Drain(context.Background(), svc) }()
+	waitFor(func() bool { return !h.Healthy() })
+	tt.MustEqual(service.Draining, runner.State(svc))
+	tt.MustEqual(http.StatusServiceUnavailable, status())
+
+	tt.MustOK(<-drained)
+	tt.MustEqual(service.Halted, runner.State(svc))
+	tt.MustEqual(http.StatusServiceUnavailable, status())
+}
+
+func TestHTTPMultipleListeners(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "http.sock")
+
+	h := NewHTTP(&http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("yep"))
+		}),
+	})
+	h.AddListener("tcp", "127.0.0.1:0", nil)
+	h.AddListener("unix", sock, nil)
+	h.AddListener("tcp", "127.0.0.1:0", nil)
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
+
+	addrs := h.Port()
+	tt.MustEqual(3, len(addrs))
+
+	get := func(client *http.Client, url string) string {
+		rs, err := client.Get(url)
+		tt.MustOK(err)
+		defer rs.Body.Close()
+		b, err := ioutil.ReadAll(rs.Body)
+		tt.MustOK(err)
+		return string(b)
+	}
+
+	unixTransport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}
+	defer unixTransport.CloseIdleConnections()
+
+	tt.MustEqual("yep", get(http.DefaultClient, "http://"+addrs[0].String()))
+	tt.MustEqual("yep", get(&http.Client{Transport: unixTransport}, "http://unix"))
+	tt.MustEqual("yep", get(http.DefaultClient, "http://"+addrs[2].String()))
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, len(h.Port()))
+}
+
+func TestHTTPListenerFailure(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.MustOK(err)
+	defer ln.Close()
+
+	h := NewHTTP(&http.Server{Handler: http.NotFoundHandler()})
+	h.AddListener("tcp", "127.0.0.1:0", nil)
+	h.AddListener("tcp", ln.Addr().String(), nil)
+
+	runner := service.NewRunner()
+	tt.MustAssert(service.StartTimeout(1*time.Second, runner, service.New("http", h)) != nil)
+	tt.MustEqual(0, len(h.Port()))
+}
+
+func TestHTTPStats(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	release := make(chan struct{})
+	h := NewHTTP(&http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}),
+	})
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+
+	done := make(chan error, 1)
+	go func() {
+		rs, err := client.Get("http://" + h.Port()[0].String())
+		if err == nil {
+			rs.Body.Close()
+		}
+		done <- err
+	}()
+
+	deadline := time.Now().Add(1 * time.Second)
+	for h.InFlight() != 1 {
+		tt.MustAssert(time.Now().Before(deadline), "request not in flight")
+		time.Sleep(time.Millisecond)
+	}
+	tt.MustEqual(1, h.ActiveConns())
+	tt.MustEqual(0, h.IdleConns())
+	tt.MustEqual(uint64(1), h.AcceptedConns())
+
+	close(release)
+	tt.MustOK(<-done)
+
+	for h.IdleConns() != 1 {
+		tt.MustAssert(time.Now().Before(deadline), "connection not idle")
+		time.Sleep(time.Millisecond)
+	}
+	tt.MustEqual(0, h.InFlight())
+	tt.MustEqual(0, h.ActiveConns())
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, h.LastForceClosed())
+}
+
+func TestHTTPShutdownTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	release := make(chan struct{})
+	defer close(release)
+
+	h := NewHTTP(&http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}),
+	})
+	h.ShutdownTimeout = 50 * time.Millisecond
+
+	errs := make(chan error, 10)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		errs <- err
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rs, err := http.Get("http://" + h.Port()[0].String())
+		if err == nil {
+			rs.Body.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(1 * time.Second)
+	for h.InFlight() != 1 {
+		tt.MustAssert(time.Now().Before(deadline), "request not in flight")
+		time.Sleep(time.Millisecond)
+	}
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	<-done
+
+	select {
+	case err := <-errs:
+		tt.MustAssert(IsHTTPShutdownTimeout(err), err)
+		serr := err.(*HTTPShutdownTimeout)
+		tt.MustEqual(1, serr.ForceClosed)
+		tt.MustEqual(1, serr.InFlight)
+	default:
+		tt.Fatal("shutdown timeout not reported")
+	}
+	tt.MustEqual(1, h.LastForceClosed())
+	tt.MustAssert(h.LastDrainDuration() >= h.ShutdownTimeout)
+}
+
+func TestHTTPServerNotModified(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mux := http.NewServeMux()
+	server := &http.Server{Addr: "127.0.0.1:0", Handler: mux}
+	h := NewHTTP(server)
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	svc := service.New("http", h)
+	for i := 0; i < 2; i++ {
+		tt.MustOK(service.StartTimeout(1*time.Second, runner, svc))
+		rs, err := http.Get("http://" + h.Port()[0].String())
+		tt.MustOK(err)
+		rs.Body.Close()
+		tt.MustEqual(uint64(1), h.AcceptedConns())
+		tt.MustOK(service.HaltTimeout(1*time.Second, runner, svc))
+	}
+
+	tt.MustAssert(server.Handler == http.Handler(mux))
+	tt.MustAssert(server.ConnState == nil)
+}
+
+type errCloseListener struct {
+	net.Listener
+	err error
+}
+
+func (ln errCloseListener) Close() error {
+	ln.Listener.Close()
+	return ln.err
+}
+
+func TestHTTPShutdownError(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tt.MustOK(err)
+
+	h := NewHTTP(&http.Server{Handler: http.NotFoundHandler()})
+	h.resetStats()
+	srv := h.instrument(cloneServer(h.Server))
+	closeErr := errors.New("close")
+	go srv.Serve(errCloseListener{Listener: ln, err: closeErr})
+
+	// Once a request has been served, the server is tracking the listener:
+	rs, err := http.Get("http://" + ln.Addr().String())
+	tt.MustOK(err)
+	rs.Body.Close()
+
+	// Errors other than the timeout are returned rather than passed to
+	// OnError, so the context isn't needed:
+	tt.MustEqual(closeErr, h.shutdown(nil, srv, 1*time.Second))
+}
+
+func TestHTTPReadyProbe(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var probes int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&probes, 1) < 3 {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+		}
+	})
+	h := NewHTTP(&http.Server{Addr: "127.0.0.1:0", Handler: mux})
+	h.ReadyProbe = HTTPProbePath("/ready")
+	h.ReadyInterval = time.Millisecond
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("http", h)))
+	tt.MustEqual(int32(3), atomic.LoadInt32(&probes))
+}
+
+func TestHTTPReadyProbeTLS(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	tt.MustOK(s.SetTLSCert(s.Server.CertPEM, s.Server.KeyPEM))
+	s.ReadyProbe = HTTPProbePath("/")
+	s.Start(tt)
+	defer s.Stop(tt)
+}
+
+func TestHTTPReadyProbeTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	h := NewHTTP(&http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusServiceUnavailable)
+		}),
+	})
+	h.ReadyProbe = HTTPProbePath("/ready")
+	h.ReadyTimeout = 50 * time.Millisecond
+	h.ReadyInterval = time.Millisecond
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	err := service.StartTimeout(1*time.Second, runner, service.New("http", h))
+	tt.MustAssert(err != nil)
+	tt.MustAssert(strings.Contains(err.Error(), "503"), err)
+	tt.MustEqual(0, len(h.Port()))
+}
@@ -0,0 +1,199 @@
+package serviceutil
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const DefaultLeaderInterval = 1 * time.Second
+
+// Leader is an experimental service.Runnable that elects a single leader
+// amongst several processes on the same host by competing for an exclusive
+// flock(2) on a file. flock is not supported on Windows.
+//
+// While it holds the lock, the Leader runs its child services in a child
+// service.Runner. When the Leader is halted or loses the lock, the children are
+// halted and the lock is released.
+//
+// The lock is considered lost if the file at path is removed or replaced. The
+// Leader also steps down if any of its children end, so another replica has a
+// chance to take over; it will compete for the lock again after the
+// interval supplied by LeaderInterval. Errors from the children are passed to
+// ctx.OnError().
+//
+// flock locks belong to an open file, not to a process, so several Leaders
+// in one process will compete with each other just as they would across
+// processes.
+type Leader struct {
+	path        string
+	services    []*service.Service
+	interval    time.Duration
+	haltTimeout time.Duration
+	onChange    func(leader bool)
+
+	leader int32
+}
+
+var _ service.Runnable = &Leader{}
+
+type LeaderOption func(l *Leader)
+
+// LeaderInterval sets how often a follower tries to take the lock, and how
+// often a leader checks that it still holds it. The default is
+// DefaultLeaderInterval.
+func LeaderInterval(d time.Duration) LeaderOption {
+	return func(l *Leader) { l.interval = d }
+}
+
+// LeaderHaltTimeout sets how long to wait for the children to halt when the
+// Leader steps down. The default is DefaultShutdownTimeout.
+func LeaderHaltTimeout(d time.Duration) LeaderOption {
+	return func(l *Leader) { l.haltTimeout = d }
+}
+
+// LeaderOnChange supplies a callback which is called with true when the
+// Leader has been elected and its children have started, and with false when
+// it has stepped down and its children have halted.
+//
+// The callback is called from the Leader's Run goroutine and must not block.
+func LeaderOnChange(cb func(leader bool)) LeaderOption {
+	return func(l *Leader) { l.onChange = cb }
+}
+
+func NewLeader(path string, services []*service.Service, options ...LeaderOption) *Leader {
+	if len(services) == 0 {
+		panic("no services")
+	}
+	l := &Leader{
+		path:        path,
+		services:    services,
+		interval:    DefaultLeaderInterval,
+		haltTimeout: DefaultShutdownTimeout,
+	}
+	for _, o := range options {
+		o(l)
+	}
+	return l
+}
+
+// IsLeader reports whether the Leader currently holds the lock and is running
+// its children.
+func (l *Leader) IsLeader() bool { return atomic.LoadInt32(&l.leader) == 1 }
+
+func (l *Leader) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	if atomic.SwapInt32(&l.leader, v) != v && l.onChange != nil {
+		l.onChange(leader)
+	}
+}
+
+func (l *Leader) Run(ctx service.Context) error {
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	tick := service.ClockFromContext(ctx).NewTicker(l.interval)
+	defer tick.Stop()
+
+	for {
+		lock, err := l.tryLock()
+		if err != nil {
+			return err
+		}
+		if lock != nil {
+			if err := l.lead(ctx, lock, tick.C()); err != nil {
+				ctx.OnError(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C():
+		}
+	}
+}
+
+// tryLock returns the locked file, or nil if another Leader holds the lock.
+func (l *Leader) tryLock() (*os.File, error) {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := tryLockFile(f)
+	if err != nil || !ok {
+		f.Close()
+		return nil, err
+	}
+
+	// The file may have been removed or replaced between opening and locking
+	// it, in which case we hold a lock nobody else can see:
+	if !fileIsAt(f, l.path) {
+		unlockFile(f)
+		f.Close()
+		return nil, nil
+	}
+	return f, nil
+}
+
+// fileIsAt reports whether f is still the file at path.
+func fileIsAt(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+// lead runs the children until the Leader is halted, loses the lock, or one
+// of the children ends.
+func (l *Leader) lead(ctx service.Context, lock *os.File, tick <-chan time.Time) (rerr error) {
+	ended := make(chan error, len(l.services))
+	runner := service.NewRunner(service.RunnerClock(service.ClockFromContext(ctx)), service.RunnerOnEnd(func(stage service.Stage, svc *service.Service, err error) {
+		if err == nil {
+			err = fmt.Errorf("serviceutil: leader child %q ended", svc.Name)
+		}
+		select {
+		case ended <- err:
+		default:
+		}
+	}))
+
+	defer func() {
+		if err := service.ShutdownTimeout(l.haltTimeout, runner); err != nil && rerr == nil {
+			rerr = err
+		}
+		l.setLeader(false)
+		unlockFile(lock)
+		lock.Close()
+	}()
+
+	if err := runner.Start(ctx, l.services...); err != nil {
+		return err
+	}
+	l.setLeader(true)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-ended:
+			return err
+		case <-tick:
+			if !fileIsAt(lock, l.path) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// +build !windows
+
+package serviceutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+type leaderTestReplica struct {
+	leader  *Leader
+	runner  service.Runner
+	running int32
+	changes chan bool
+}
+
+func newLeaderTestReplica(tt assert.T, path string) *leaderTestReplica {
+	tt.Helper()
+	r := &leaderTestReplica{changes: make(chan bool, 10)}
+	child := service.RunnableFunc(func(ctx service.Context) error {
+		atomic.AddInt32(&r.running, 1)
+		defer atomic.AddInt32(&r.running, -1)
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+	r.leader = NewLeader(path, []*service.Service{service.New("child", child)},
+		LeaderInterval(2*time.Millisecond),
+		LeaderOnChange(func(leader bool) { r.changes <- leader }))
+	r.runner = service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, r.runner, service.New("leader", r.leader)))
+	return r
+}
+
+func (r *leaderTestReplica) Stop(tt assert.T) {
+	tt.Helper()
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, r.runner))
+}
+
+func waitLeaders(tt assert.T, replicas ...*leaderTestReplica) *leaderTestReplica {
+	tt.Helper()
+	deadline := time.Now().Add(1 * time.Second)
+	for {
+		var leaders []*leaderTestReplica
+		for _, r := range replicas {
+			if r.leader.IsLeader() {
+				leaders = append(leaders, r)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		if len(leaders) > 1 {
+			tt.Fatalf("expected one leader, found %d", len(leaders))
+		}
+		if time.Now().After(deadline) {
+			tt.Fatalf("no leader elected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leader.lock")
+
+	a := newLeaderTestReplica(tt, path)
+	b := newLeaderTestReplica(tt, path)
+	c := newLeaderTestReplica(tt, path)
+
+	first := waitLeaders(tt, a, b, c)
+	tt.MustEqual(true, <-first.changes)
+	tt.MustEqual(int32(1), atomic.LoadInt32(&first.running))
+
+	// Give the followers a few chances to wrongly take over:
+	time.Sleep(20 * time.Millisecond)
+	waitLeaders(tt, a, b, c)
+
+	var rest []*leaderTestReplica
+	for _, r := range []*leaderTestReplica{a, b, c} {
+		if r != first {
+			tt.MustEqual(int32(0), atomic.LoadInt32(&r.running))
+			rest = append(rest, r)
+		}
+	}
+
+	first.Stop(tt)
+	tt.MustEqual(false, <-first.changes)
+	tt.MustEqual(int32(0), atomic.LoadInt32(&first.running))
+	tt.MustAssert(!first.leader.IsLeader())
+
+	second := waitLeaders(tt, rest...)
+	tt.MustEqual(true, <-second.changes)
+
+	for _, r := range rest {
+		r.Stop(tt)
+	}
+}
+
+func TestLeaderLosesLock(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leader.lock")
+
+	r := newLeaderTestReplica(tt, path)
+	defer r.Stop(tt)
+	tt.MustEqual(true, <-r.changes)
+
+	// Removing the file means anyone else can now take a lock on a new one,
+	// so the leader must step down. It will then compete for the new file:
+	tt.MustOK(os.Remove(path))
+	tt.MustEqual(false, <-r.changes)
+	tt.MustEqual(true, <-r.changes)
+	tt.MustAssert(r.leader.IsLeader())
+}
+
+func TestLeaderStepsDownWhenChildEnds(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leader.lock")
+
+	var starts int32
+	child := service.RunnableFunc(func(ctx service.Context) error {
+		if atomic.AddInt32(&starts, 1) == 1 {
+			return ctx.Ready()
+		}
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	changes := make(chan bool, 10)
+	errs := make(chan error, 10)
+	leader := NewLeader(path, []*service.Service{service.New("child", child)},
+		LeaderInterval(2*time.Millisecond),
+		LeaderOnChange(func(leader bool) { changes <- leader }))
+
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		errs <- err
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("leader", leader)))
+
+	tt.MustEqual(true, <-changes)
+	tt.MustEqual(false, <-changes)
+	tt.MustAssert(<-errs != nil)
+	tt.MustEqual(true, <-changes)
+	tt.MustEqual(int32(2), atomic.LoadInt32(&starts))
+}
@@ -0,0 +1,194 @@
+package serviceutil
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	DefaultPacketReadTimeout = 100 * time.Millisecond
+	DefaultPacketBufferSize  = 65535
+)
+
+// PacketHandler handles a single datagram received by a PacketServer. conn
+// may be used to send a reply to addr.
+//
+// packet is only valid until the handler returns; copy it if you need to
+// retain it.
+//
+// If the handler returns an error, it is passed to ctx.OnError() and the
+// PacketServer continues to run.
+type PacketHandler func(ctx service.Context, conn net.PacketConn, addr net.Addr, packet []byte) error
+
+// PacketServer is an experimental service.Runnable that reads datagrams from
+// a net.PacketConn ("udp", "udp4", "udp6" or "unixgram") and passes them to a
+// PacketHandler.
+//
+// By default, packets are handled one at a time in the read loop. Use
+// PacketServerWorkers to handle them concurrently in a bounded pool.
+type PacketServer struct {
+	network string
+	address string
+	handler PacketHandler
+
+	workers     int
+	queue       int
+	bufSize     int
+	readTimeout time.Duration
+
+	port int32
+	addr net.Addr
+	mu   sync.Mutex
+}
+
+var _ service.Runnable = &PacketServer{}
+
+type PacketServerOption func(s *PacketServer)
+
+// PacketServerWorkers dispatches packets to n worker goroutines through a
+// queue that can hold queue packets. When the queue is full, the server stops
+// reading from the connection until a worker is free.
+func PacketServerWorkers(n, queue int) PacketServerOption {
+	return func(s *PacketServer) { s.workers, s.queue = n, queue }
+}
+
+// PacketServerBufferSize sets the size of the buffer used to read each
+// packet. Packets larger than this are truncated. The default is
+// DefaultPacketBufferSize.
+func PacketServerBufferSize(n int) PacketServerOption {
+	return func(s *PacketServer) { s.bufSize = n }
+}
+
+// PacketServerReadTimeout sets the read deadline used to periodically check
+// whether the service has been halted. The default is
+// DefaultPacketReadTimeout.
+func PacketServerReadTimeout(d time.Duration) PacketServerOption {
+	return func(s *PacketServer) { s.readTimeout = d }
+}
+
+func NewPacketServer(network, address string, handler PacketHandler, options ...PacketServerOption) *PacketServer {
+	if handler == nil {
+		panic("handler was nil")
+	}
+	s := &PacketServer{
+		network:     network,
+		address:     address,
+		handler:     handler,
+		bufSize:     DefaultPacketBufferSize,
+		readTimeout: DefaultPacketReadTimeout,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Port returns the UDP port the server is bound to, or 0 if it is not running
+// or is not bound to a UDP address.
+func (s *PacketServer) Port() int { return int(atomic.LoadInt32(&s.port)) }
+
+// Addr returns the address the server is bound to, or nil if it is not
+// running.
+func (s *PacketServer) Addr() (addr net.Addr) {
+	s.mu.Lock()
+	addr = s.addr
+	s.mu.Unlock()
+	return addr
+}
+
+type packet struct {
+	addr net.Addr
+	buf  []byte
+	n    int
+}
+
+func (s *PacketServer) Run(ctx service.Context) (rerr error) {
+	conn, err := net.ListenPacket(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.addr = conn.LocalAddr()
+	s.mu.Unlock()
+	if udp, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		atomic.StoreInt32(&s.port, int32(udp.Port))
+	}
+
+	defer func() {
+		atomic.StoreInt32(&s.port, 0)
+		s.mu.Lock()
+		s.addr = nil
+		s.mu.Unlock()
+	}()
+
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	handle := func(addr net.Addr, buf []byte) {
+		if err := s.handler(ctx, conn, addr, buf); err != nil {
+			ctx.OnError(err)
+		}
+	}
+
+	var (
+		wg    sync.WaitGroup
+		queue chan packet
+		bufs  = sync.Pool{New: func() interface{} { return make([]byte, s.bufSize) }}
+	)
+
+	if s.workers > 0 {
+		queue = make(chan packet, s.queue)
+		for i := 0; i < s.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for p := range queue {
+					handle(p.addr, p.buf[:p.n])
+					bufs.Put(p.buf)
+				}
+			}()
+		}
+		defer func() {
+			close(queue)
+			wg.Wait()
+		}()
+	}
+
+	for {
+		buf := bufs.Get().([]byte)
+
+		if err := conn.SetReadDeadline(time.Now().Add(s.readTimeout)); err != nil {
+			return err
+		}
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			bufs.Put(buf)
+			if ctx.ShouldHalt() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && (ne.Timeout() || ne.Temporary()) {
+				continue
+			}
+			return err
+		}
+
+		if queue == nil {
+			handle(addr, buf[:n])
+			bufs.Put(buf)
+			continue
+		}
+
+		select {
+		case queue <- packet{addr: addr, buf: buf, n: n}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package serviceutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestPacketServerEcho(t *testing.T) {
+	for _, opts := range [][]PacketServerOption{
+		nil,
+		{PacketServerWorkers(4, 10)},
+	} {
+		t.Run("", func(t *testing.T) {
+			tt := assert.WrapTB(t)
+
+			s := NewPacketServer("udp", "127.0.0.1:0", func(ctx service.Context, conn net.PacketConn, addr net.Addr, packet []byte) error {
+				_, err := conn.WriteTo(packet, addr)
+				return err
+			}, opts...)
+
+			runner := service.NewRunner()
+			defer service.MustShutdownTimeout(1*time.Second, runner)
+			tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+			tt.MustAssert(s.Port() > 0)
+
+			conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", s.Port()))
+			tt.MustOK(err)
+			defer conn.Close()
+
+			_, err = conn.Write([]byte("yep"))
+			tt.MustOK(err)
+
+			buf := make([]byte, 10)
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			n, err := conn.Read(buf)
+			tt.MustOK(err)
+			tt.MustEqual("yep", string(buf[:n]))
+
+			tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+			tt.MustEqual(0, s.Port())
+		})
+	}
+}
+
+func TestPacketServerHandlerError(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	herr := errors.New("bad packet")
+	errs := make(chan error, 1)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		errs <- err
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	s := NewPacketServer("udp", "127.0.0.1:0", func(ctx service.Context, conn net.PacketConn, addr net.Addr, packet []byte) error {
+		return herr
+	})
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", s.Port()))
+	tt.MustOK(err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("yep"))
+	tt.MustOK(err)
+
+	tt.MustEqual(herr, <-errs)
+}
@@ -0,0 +1,172 @@
+package serviceutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+// PIDFileInUse is returned by PIDFile.Run if another live process owns the
+// PID file.
+type PIDFileInUse struct {
+	Path string
+
+	// PID of the process that owns the file, or 0 if it could not be read.
+	PID int
+}
+
+func (e *PIDFileInUse) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("serviceutil: pid file %q is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("serviceutil: pid file %q is in use by process %d", e.Path, e.PID)
+}
+
+func IsPIDFileInUse(err error) bool {
+	_, ok := err.(*PIDFileInUse)
+	return ok
+}
+
+// PIDFile is an experimental service.Runnable that writes the current
+// process ID to a file and holds an exclusive flock(2) on it for as long as
+// it is running. flock is not supported on Windows.
+//
+// If another process holds the lock, or the file contains the PID of another
+// live process (checked with kill(pid, 0)), PIDFile fails to start with a
+// *PIDFileInUse. Otherwise, the file is considered stale and is replaced.
+//
+// The file is written to a temporary file and renamed into place, so readers
+// never see a partial PID. It is removed when the service is halted.
+//
+// Start a PIDFile before your other services and wait for it to become ready
+// to ensure only one instance of a daemon runs at a time.
+type PIDFile struct {
+	path string
+}
+
+var _ service.Runnable = &PIDFile{}
+
+func NewPIDFile(path string) *PIDFile {
+	return &PIDFile{path: path}
+}
+
+func (p *PIDFile) Path() string { return p.path }
+
+func (p *PIDFile) Run(ctx service.Context) error {
+	f, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Only remove the file if it's still ours:
+		if fileIsAt(f, p.path) {
+			os.Remove(p.path)
+		}
+		unlockFile(f)
+		f.Close()
+	}()
+
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// acquire returns the locked PID file containing our PID.
+func (p *PIDFile) acquire() (*os.File, error) {
+	// If the file is replaced between opening and locking it, we may have
+	// locked a file that nobody else can see; try again a few times.
+	const attempts = 5
+
+	for i := 0; i < attempts; i++ {
+		f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		} else if !ok {
+			f.Close()
+			return nil, &PIDFileInUse{Path: p.path, PID: readPID(p.path)}
+		}
+
+		if !fileIsAt(f, p.path) {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+
+		// We hold the lock, but whoever wrote the file may not have used one:
+		if pid := readPID(p.path); pid != 0 && pid != os.Getpid() && processAlive(pid) {
+			unlockFile(f)
+			f.Close()
+			return nil, &PIDFileInUse{Path: p.path, PID: pid}
+		}
+
+		nf, err := p.replace()
+		unlockFile(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nf, nil
+	}
+
+	return nil, fmt.Errorf("serviceutil: pid file %q kept changing while it was being locked", p.path)
+}
+
+// replace writes our PID to a locked temporary file and renames it over the
+// PID file. The caller must hold the lock on the existing PID file.
+func (p *PIDFile) replace() (f *os.File, rerr error) {
+	f, err := ioutil.TempFile(filepath.Dir(p.path), "."+filepath.Base(p.path)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			os.Remove(f.Name())
+			f.Close()
+		}
+	}()
+
+	if ok, err := tryLockFile(f); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("serviceutil: could not lock new pid file %q", f.Name())
+	}
+	if err := f.Chmod(0644); err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), p.path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readPID returns the PID stored in path, or 0 if there isn't one.
+func readPID(path string) int {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bts)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,92 @@
+// +build !windows
+
+package serviceutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestPIDFile(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.pid")
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("pid", NewPIDFile(path))))
+	tt.MustEqual(os.Getpid(), readPID(path))
+
+	// A second instance must refuse to start while the first holds the lock:
+	other := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, other)
+	err = service.StartTimeout(1*time.Second, other, service.New("pid", NewPIDFile(path)))
+	tt.MustAssert(IsPIDFileInUse(err), err)
+	tt.MustEqual(os.Getpid(), err.(*PIDFileInUse).PID)
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	_, err = os.Stat(path)
+	tt.MustAssert(os.IsNotExist(err), "pid file not removed")
+
+	// No leftover temp files:
+	files, err := ioutil.ReadDir(dir)
+	tt.MustOK(err)
+	tt.MustEqual(0, len(files))
+}
+
+func TestPIDFileStale(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.pid")
+
+	// The PID of a process that has exited:
+	cmd := exec.Command("true")
+	tt.MustOK(cmd.Run())
+	tt.MustOK(ioutil.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)), 0644))
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("pid", NewPIDFile(path))))
+	tt.MustEqual(os.Getpid(), readPID(path))
+}
+
+func TestPIDFileLiveUnlocked(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.pid")
+
+	// A live process that wrote the file without taking a lock:
+	cmd := exec.Command("sleep", "10")
+	tt.MustOK(cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	tt.MustOK(ioutil.WriteFile(path, []byte(strconv.Itoa(cmd.Process.Pid)), 0644))
+
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	err = service.StartTimeout(1*time.Second, runner, service.New("pid", NewPIDFile(path)))
+	tt.MustAssert(IsPIDFileInUse(err), err)
+	tt.MustEqual(cmd.Process.Pid, err.(*PIDFileInUse).PID)
+
+	// The file belongs to someone else, so it must be left alone:
+	tt.MustEqual(cmd.Process.Pid, readPID(path))
+}
@@ -0,0 +1,339 @@
+package serviceutil
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+var errPoolRunning = fmt.Errorf("serviceutil: pool already running")
+
+// Backoff calculates how long to wait before the nth consecutive attempt to
+// restart something that has failed. attempt starts at 1.
+type Backoff func(attempt int) time.Duration
+
+// BackoffFixed waits the same duration before every attempt.
+func BackoffFixed(d time.Duration) Backoff {
+	return func(attempt int) time.Duration { return d }
+}
+
+// BackoffExponential doubles the wait after every consecutive failure,
+// starting at min and never exceeding max.
+func BackoffExponential(min, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := min
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// Pool is an experimental service.Runnable that runs a resizable pool of
+// identical workers as child services in an internal service.Runner.
+//
+// The same Runnable is started once for every worker, so it must be safe to
+// run concurrently.
+//
+// Workers that end for any reason other than being removed by SetSize are
+// replaced after waiting for the Backoff supplied by PoolBackoff. Their
+// errors are passed to the Pool's ctx.OnError().
+type Pool struct {
+	name     service.Name
+	runnable service.Runnable
+
+	quorum  int
+	backoff Backoff
+	timeout time.Duration
+
+	size   int
+	resize chan struct{}
+	runner service.Runner
+	mu     sync.RWMutex
+}
+
+var _ service.Runnable = &Pool{}
+
+type PoolOption func(p *Pool)
+
+// PoolQuorum sets the number of workers that must be ready before the Pool
+// reports that it is ready. By default, all workers must be ready.
+func PoolQuorum(n int) PoolOption {
+	return func(p *Pool) { p.quorum = n }
+}
+
+// PoolBackoff sets the wait between restarts of a failed worker. By default,
+// BackoffExponential(10*time.Millisecond, 10*time.Second) is used.
+func PoolBackoff(backoff Backoff) PoolOption {
+	return func(p *Pool) { p.backoff = backoff }
+}
+
+// PoolHaltTimeout sets how long to wait for workers to halt when they are
+// removed from the Pool, or when the Pool is halted. The default is
+// DefaultShutdownTimeout.
+func PoolHaltTimeout(timeout time.Duration) PoolOption {
+	return func(p *Pool) { p.timeout = timeout }
+}
+
+// PoolName sets the prefix used for the name of every worker service.
+func PoolName(name service.Name) PoolOption {
+	return func(p *Pool) { p.name = name }
+}
+
+func NewPool(runnable service.Runnable, size int, options ...PoolOption) *Pool {
+	if runnable == nil {
+		panic("runnable was nil")
+	}
+	if size < 0 {
+		panic("size must be >= 0")
+	}
+	p := &Pool{
+		name:     "pool",
+		runnable: runnable,
+		size:     size,
+		quorum:   -1,
+		backoff:  BackoffExponential(10*time.Millisecond, 10*time.Second),
+		timeout:  DefaultShutdownTimeout,
+		resize:   make(chan struct{}, 1),
+	}
+	for _, o := range options {
+		o(p)
+	}
+	return p
+}
+
+// Size returns the number of workers the Pool is trying to maintain.
+func (p *Pool) Size() (n int) {
+	p.mu.RLock()
+	n = p.size
+	p.mu.RUnlock()
+	return n
+}
+
+// SetSize changes the number of workers. If the Pool is running, workers are
+// started or halted in the background to match the new size; other workers
+// are not disturbed.
+func (p *Pool) SetSize(n int) {
+	if n < 0 {
+		panic("size must be >= 0")
+	}
+	p.mu.Lock()
+	p.size = n
+	p.mu.Unlock()
+
+	select {
+	case p.resize <- struct{}{}:
+	default:
+	}
+}
+
+// Services returns the workers in the Pool, as per service.Runner.Services().
+// If the Pool is not running, nothing is returned.
+func (p *Pool) Services(state service.State, limit int, into []service.ServiceInfo) []service.ServiceInfo {
+	p.mu.RLock()
+	runner := p.runner
+	p.mu.RUnlock()
+	if runner == nil {
+		return nil
+	}
+	return runner.Services(state, limit, into)
+}
+
+type poolEventKind int
+
+const (
+	poolStarted poolEventKind = iota + 1
+	poolEnded
+	poolRestart
+)
+
+type poolEvent struct {
+	kind poolEventKind
+	svc  *service.Service
+	err  error
+}
+
+type poolWorker struct {
+	svc     *service.Service
+	attempt int
+	ready   bool
+}
+
+func (p *Pool) Run(ctx service.Context) (rerr error) {
+	var wg sync.WaitGroup
+	events := make(chan poolEvent)
+
+	send := func(ev poolEvent) {
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+		}
+	}
+
+	clock := service.ClockFromContext(ctx)
+	runner := service.NewRunner(service.RunnerClock(clock), service.RunnerOnEnd(func(stage service.Stage, svc *service.Service, err error) {
+		// It is not safe to block in here; the runner is locked:
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send(poolEvent{kind: poolEnded, svc: svc, err: err})
+		}()
+	}))
+
+	p.mu.Lock()
+	if p.runner != nil {
+		p.mu.Unlock()
+		return errPoolRunning
+	}
+	p.runner = runner
+	p.mu.Unlock()
+
+	defer func() {
+		if err := service.ShutdownTimeout(p.timeout, runner); err != nil {
+			ctx.OnError(err)
+		}
+		wg.Wait()
+
+		p.mu.Lock()
+		p.runner = nil
+		p.mu.Unlock()
+	}()
+
+	var (
+		workers    []*poolWorker
+		bySvc      = make(map[*service.Service]*poolWorker)
+		nextID     int
+		readyCount int
+		ready      bool
+	)
+
+	start := func(w *poolWorker) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runner.Start(ctx, w.svc); err == nil {
+				send(poolEvent{kind: poolStarted, svc: w.svc})
+			}
+		}()
+	}
+
+	halt := func(w *poolWorker) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := service.HaltTimeout(p.timeout, runner, w.svc); err != nil {
+				ctx.OnError(err)
+			}
+		}()
+	}
+
+	checkReady := func() error {
+		if ready {
+			return nil
+		}
+		quorum := p.quorum
+		size := p.Size()
+		if quorum < 0 || quorum > size {
+			quorum = size
+		}
+		if readyCount >= quorum {
+			ready = true
+			return ctx.Ready()
+		}
+		return nil
+	}
+
+	reconcile := func() {
+		size := p.Size()
+		for len(workers) < size {
+			nextID++
+			w := &poolWorker{svc: service.New(p.name.Append(strconv.Itoa(nextID)), p.runnable)}
+			workers = append(workers, w)
+			bySvc[w.svc] = w
+			start(w)
+		}
+		for len(workers) > size {
+			last := len(workers) - 1
+			w := workers[last]
+			workers[last] = nil
+			workers = workers[:last]
+			delete(bySvc, w.svc)
+			if w.ready {
+				readyCount--
+			}
+			halt(w)
+		}
+	}
+
+	reconcile()
+	if err := checkReady(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-p.resize:
+			reconcile()
+			if err := checkReady(); err != nil {
+				return err
+			}
+
+		case ev := <-events:
+			w := bySvc[ev.svc]
+			if w == nil {
+				// Worker was removed by SetSize:
+				continue
+			}
+
+			switch ev.kind {
+			case poolStarted:
+				if !w.ready && runner.State(w.svc) == service.Started {
+					w.ready = true
+					w.attempt = 0
+					readyCount++
+				}
+				if err := checkReady(); err != nil {
+					return err
+				}
+
+			case poolEnded:
+				if w.ready {
+					w.ready = false
+					readyCount--
+				}
+				if ev.err != nil {
+					ctx.OnError(ev.err)
+				}
+				w.attempt++
+				wait := p.backoff(w.attempt)
+				svc := w.svc
+
+				// The backoff is abandoned when the pool is halted, so this
+				// does not hold up wg.Wait():
+				timer := clock.NewTimer(wait)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					select {
+					case <-timer.C():
+						send(poolEvent{kind: poolRestart, svc: svc})
+					case <-ctx.Done():
+						timer.Stop()
+					}
+				}()
+
+			case poolRestart:
+				start(w)
+			}
+		}
+	}
+}
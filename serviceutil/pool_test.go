@@ -0,0 +1,122 @@
+package serviceutil
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func waitPoolServices(tt assert.T, p *Pool, state service.State, n int) {
+	tt.Helper()
+	deadline := time.Now().Add(1 * time.Second)
+	for len(p.Services(state, 0, nil)) != n {
+		if time.Now().After(deadline) {
+			tt.Fatalf("expected %d %s workers, found %d", n, state, len(p.Services(state, 0, nil)))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	worker := service.RunnableFunc(func(ctx service.Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	p := NewPool(worker, 3)
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+	tt.MustEqual(3, len(p.Services(service.Started, 0, nil)))
+
+	p.SetSize(1)
+	waitPoolServices(tt, p, service.AnyState, 1)
+
+	p.SetSize(4)
+	waitPoolServices(tt, p, service.Started, 4)
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, len(p.Services(service.AnyState, 0, nil)))
+}
+
+func TestPoolQuorum(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var starts int32
+	block := make(chan struct{})
+	worker := service.RunnableFunc(func(ctx service.Context) error {
+		if atomic.AddInt32(&starts, 1) > 2 {
+			// Only the first two workers become ready until we unblock:
+			select {
+			case <-block:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	p := NewPool(worker, 3, PoolQuorum(2))
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+	tt.MustEqual(2, len(p.Services(service.Started, 0, nil)))
+	close(block)
+	waitPoolServices(tt, p, service.Started, 3)
+}
+
+func TestPoolReplacesFailedWorkers(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	failure := errors.New("worker failed")
+	var starts int32
+	worker := service.RunnableFunc(func(ctx service.Context) error {
+		if err := ctx.Ready(); err != nil {
+			return err
+		}
+		if atomic.AddInt32(&starts, 1) <= 2 {
+			return failure
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	errs := make(chan error, 10)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		errs <- err
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	p := NewPool(worker, 1, PoolBackoff(BackoffFixed(time.Millisecond)))
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+
+	tt.MustEqual(failure, <-errs)
+	tt.MustEqual(failure, <-errs)
+	waitPoolServices(tt, p, service.Started, 1)
+	tt.MustEqual(int32(3), atomic.LoadInt32(&starts))
+}
+
+func TestBackoffExponential(t *testing.T) {
+	tt := assert.WrapTB(t)
+	b := BackoffExponential(10*time.Millisecond, 50*time.Millisecond)
+	tt.MustEqual(10*time.Millisecond, b(1))
+	tt.MustEqual(20*time.Millisecond, b(2))
+	tt.MustEqual(40*time.Millisecond, b(3))
+	tt.MustEqual(50*time.Millisecond, b(4))
+	tt.MustEqual(50*time.Millisecond, b(100))
+}
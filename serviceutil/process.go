@@ -0,0 +1,359 @@
+package serviceutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+const (
+	DefaultProcessGracePeriod   = 5 * time.Second
+	DefaultProcessProbeInterval = 50 * time.Millisecond
+
+	// Lines longer than this are not matched against ProcessReadyLine.
+	processMaxLineLen = 64 * 1024
+)
+
+// ExitError is returned by Process.Run if the process exits with a nonzero
+// status before it is halted.
+type ExitError struct {
+	Code int
+	Err  *exec.ExitError
+}
+
+func (e *ExitError) Cause() error { return e.Err }
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("serviceutil: process exited with status %d", e.Code)
+}
+
+func IsExitError(err error) bool {
+	_, ok := err.(*ExitError)
+	return ok
+}
+
+// Probe is polled by a Process until it returns nil, at which point the
+// Process is considered ready.
+type Probe func(ctx context.Context) error
+
+// ProbeFile succeeds once path exists.
+func ProbeFile(path string) Probe {
+	return func(ctx context.Context) error {
+		_, err := os.Stat(path)
+		return err
+	}
+}
+
+// ProbeDial succeeds once a connection to addr can be established.
+func ProbeDial(network, addr string) Probe {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Process is an experimental service.Runnable that manages the lifecycle of
+// an external process.
+//
+// A new *exec.Cmd is created by the supplied function every time the service
+// is started, as an exec.Cmd can not be reused.
+//
+// When the service is halted, the process group is sent SIGTERM. Once the
+// process has exited, or the grace period has passed, the group is sent
+// SIGKILL, so that children that ignored SIGTERM don't outlive the service.
+// On platforms without process groups, the process is killed immediately.
+//
+// If the process exits before it is halted, Run returns an *ExitError if the
+// status was nonzero, or service.ErrServiceEnded if it was zero.
+type Process struct {
+	cmd func() *exec.Cmd
+
+	readyLine     *regexp.Regexp
+	probes        []Probe
+	probeInterval time.Duration
+	grace         time.Duration
+	stdout        io.Writer
+	stderr        io.Writer
+
+	pid int32
+}
+
+var _ service.Runnable = &Process{}
+
+type ProcessOption func(p *Process)
+
+// ProcessReadyLine considers the process ready when a line written to stdout
+// or stderr matches re. If any probes are also supplied, they are polled once
+// the line has matched, and must all succeed as well.
+//
+// Output is no longer matched once the process is ready. Lines longer than
+// 64KiB are never matched.
+func ProcessReadyLine(re *regexp.Regexp) ProcessOption {
+	return func(p *Process) { p.readyLine = re }
+}
+
+// ProcessReadyFile considers the process ready once path exists.
+func ProcessReadyFile(path string) ProcessOption {
+	return ProcessReadyProbe(ProbeFile(path))
+}
+
+// ProcessReadyPort considers the process ready once it accepts connections
+// on addr.
+func ProcessReadyPort(network, addr string) ProcessOption {
+	return ProcessReadyProbe(ProbeDial(network, addr))
+}
+
+// ProcessReadyProbe considers the process ready once probe returns nil. If
+// more than one probe is supplied, all of them must succeed.
+func ProcessReadyProbe(probe Probe) ProcessOption {
+	return func(p *Process) { p.probes = append(p.probes, probe) }
+}
+
+// ProcessProbeInterval sets how often readiness probes are polled. The default
+// is DefaultProcessProbeInterval.
+func ProcessProbeInterval(d time.Duration) ProcessOption {
+	return func(p *Process) { p.probeInterval = d }
+}
+
+// ProcessGracePeriod sets how long to wait after SIGTERM before sending
+// SIGKILL. The default is DefaultProcessGracePeriod.
+func ProcessGracePeriod(d time.Duration) ProcessOption {
+	return func(p *Process) { p.grace = d }
+}
+
+// ProcessOutput streams the process' stdout and stderr to the supplied
+// writers. Either may be nil.
+func ProcessOutput(stdout, stderr io.Writer) ProcessOption {
+	return func(p *Process) { p.stdout, p.stderr = stdout, stderr }
+}
+
+// ProcessCommand returns a function suitable for NewProcess which creates an
+// exec.Cmd using exec.Command.
+func ProcessCommand(name string, args ...string) func() *exec.Cmd {
+	return func() *exec.Cmd { return exec.Command(name, args...) }
+}
+
+func NewProcess(cmd func() *exec.Cmd, options ...ProcessOption) *Process {
+	if cmd == nil {
+		panic("cmd was nil")
+	}
+	p := &Process{
+		cmd:           cmd,
+		probeInterval: DefaultProcessProbeInterval,
+		grace:         DefaultProcessGracePeriod,
+	}
+	for _, o := range options {
+		o(p)
+	}
+	return p
+}
+
+// Pid returns the process ID of the running process, or 0 if it is not
+// running.
+func (p *Process) Pid() int { return int(atomic.LoadInt32(&p.pid)) }
+
+func (p *Process) Run(ctx service.Context) (rerr error) {
+	cmd := p.cmd()
+	setProcessGroup(cmd)
+
+	// lineReady is closed once a line matches p.readyLine, or straight away if
+	// there is no p.readyLine:
+	lineReady := make(chan struct{})
+	var lineOnce sync.Once
+	setLineReady := func() { lineOnce.Do(func() { close(lineReady) }) }
+
+	var match func([]byte)
+	if p.readyLine != nil {
+		match = func(line []byte) {
+			if p.readyLine.Match(line) {
+				setLineReady()
+			}
+		}
+	} else {
+		setLineReady()
+	}
+	if p.stdout != nil || match != nil {
+		cmd.Stdout = &lineWriter{w: p.stdout, match: match, done: lineReady}
+	}
+	if p.stderr != nil || match != nil {
+		cmd.Stderr = &lineWriter{w: p.stderr, match: match, done: lineReady}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&p.pid, int32(cmd.Process.Pid))
+	defer atomic.StoreInt32(&p.pid, 0)
+
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- cmd.Wait()
+	}()
+
+	probeStop := make(chan struct{})
+	defer close(probeStop)
+
+	ready := lineReady
+	if len(p.probes) > 0 {
+		probesReady := make(chan struct{})
+		go p.probe(ctx, probeStop, lineReady, probesReady)
+		ready = probesReady
+	}
+
+	select {
+	case <-ready:
+	case err := <-waitDone:
+		return p.exitError(err)
+	case <-ctx.Done():
+		return p.terminate(service.ClockFromContext(ctx), cmd, waitDone)
+	}
+
+	if err := ctx.Ready(); err != nil {
+		p.terminate(service.ClockFromContext(ctx), cmd, waitDone)
+		return err
+	}
+
+	select {
+	case err := <-waitDone:
+		return p.exitError(err)
+	case <-ctx.Done():
+		return p.terminate(service.ClockFromContext(ctx), cmd, waitDone)
+	}
+}
+
+// probe closes ready once all of the probes succeed. It doesn't start polling
+// until after start is closed.
+func (p *Process) probe(ctx context.Context, stop, start <-chan struct{}, ready chan<- struct{}) {
+	select {
+	case <-start:
+	case <-stop:
+		return
+	}
+
+	tick := service.ClockFromContext(ctx).NewTicker(p.probeInterval)
+	defer tick.Stop()
+
+	for {
+		ok := true
+		for _, probe := range p.probes {
+			if probe(ctx) != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			close(ready)
+			return
+		}
+
+		select {
+		case <-tick.C():
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *Process) exitError(err error) error {
+	if err == nil {
+		return service.ErrServiceEnded
+	}
+	if xerr, ok := err.(*exec.ExitError); ok {
+		return &ExitError{Code: xerr.ExitCode(), Err: xerr}
+	}
+	return err
+}
+
+// terminate stops the process and waits for it to exit. Exit errors caused
+// by the termination are discarded.
+func (p *Process) terminate(clock service.Clock, cmd *exec.Cmd, waitDone <-chan error) error {
+	exited := false
+	if p.grace > 0 {
+		if err := terminateProcessGroup(cmd); err == nil {
+			timer := clock.NewTimer(p.grace)
+			defer timer.Stop()
+			select {
+			case <-waitDone:
+				exited = true
+			case <-timer.C():
+			}
+		}
+	}
+
+	// The rest of the group is killed even if the process exited in time, as
+	// its children may have ignored SIGTERM. If they are all gone already,
+	// this fails harmlessly:
+	killProcessGroup(cmd)
+	if !exited {
+		<-waitDone
+	}
+	return nil
+}
+
+// lineWriter passes output through to w, calling match for every complete
+// line until done is closed.
+type lineWriter struct {
+	w     io.Writer
+	match func(line []byte)
+	done  <-chan struct{}
+	buf   []byte
+	long  bool // Discarding the rest of a line longer than processMaxLineLen
+}
+
+func (l *lineWriter) Write(b []byte) (n int, err error) {
+	n = len(b)
+	if l.w != nil {
+		if n, err = l.w.Write(b); err != nil {
+			return n, err
+		}
+	}
+	if l.match != nil {
+		l.scan(b)
+	}
+	return n, nil
+}
+
+func (l *lineWriter) scan(b []byte) {
+	for len(b) > 0 {
+		select {
+		case <-l.done:
+			l.match, l.buf = nil, nil
+			return
+		default:
+		}
+
+		idx := bytes.IndexByte(b, '\n')
+		if idx < 0 {
+			if !l.long {
+				l.buf = append(l.buf, b...)
+				if len(l.buf) > processMaxLineLen {
+					l.buf, l.long = nil, true
+				}
+			}
+			return
+		}
+
+		if !l.long {
+			l.buf = append(l.buf, b[:idx]...)
+			if len(l.buf) <= processMaxLineLen {
+				l.match(bytes.TrimRight(l.buf, "\r"))
+			}
+		}
+		l.buf, l.long = l.buf[:0], false
+		b = b[idx+1:]
+	}
+}
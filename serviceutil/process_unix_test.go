@@ -0,0 +1,226 @@
+// +build !windows
+
+package serviceutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(b)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestProcessReadyLine(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var out syncBuffer
+	p := NewProcess(ProcessCommand("sh", "-c", "echo starting; sleep 0.05; echo ready; exec sleep 10"),
+		ProcessReadyLine(regexp.MustCompile("^ready$")),
+		ProcessOutput(&out, nil))
+
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+	tt.MustEqual("starting\nready\n", out.String())
+	tt.MustAssert(p.Pid() > 0)
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, p.Pid())
+}
+
+func TestProcessExitError(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	failer := service.NewFailureListener(1)
+	p := NewProcess(ProcessCommand("sh", "-c", "sleep 0.01; exit 3"))
+
+	runner := service.NewRunner(failer.ForRunner())
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+
+	err := <-failer.Failures()
+	tt.MustAssert(IsExitError(err), err)
+	tt.MustEqual(3, err.(*ExitError).Code)
+}
+
+func TestProcessExitBeforeReady(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	p := NewProcess(ProcessCommand("sh", "-c", "exit 2"),
+		ProcessReadyFile("/nonexistent/go-service/ready"))
+
+	runner := service.NewRunner()
+	err := service.StartTimeout(1*time.Second, runner, service.New("", p))
+	tt.MustAssert(IsExitError(err), err)
+}
+
+func TestProcessKillAfterGrace(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	grace := 50 * time.Millisecond
+	p := NewProcess(ProcessCommand("sh", "-c", "trap '' TERM; echo ready; while true; do sleep 0.01; done"),
+		ProcessReadyLine(regexp.MustCompile("ready")),
+		ProcessGracePeriod(grace))
+
+	runner := service.NewRunner()
+	svc := service.New("", p)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, svc))
+
+	tm := time.Now()
+	tt.MustOK(service.HaltTimeout(1*time.Second, runner, svc))
+	tt.MustAssert(time.Since(tm) >= grace)
+}
+
+func TestProcessReadyLineAndProbe(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ready")
+
+	// The line matches first, but the process isn't ready until the probe
+	// succeeds too:
+	var out syncBuffer
+	p := NewProcess(ProcessCommand("sh", "-c", "echo ready; sleep 0.05; echo probing; touch "+file+"; exec sleep 10"),
+		ProcessReadyLine(regexp.MustCompile("^ready$")),
+		ProcessReadyFile(file),
+		ProcessProbeInterval(5*time.Millisecond),
+		ProcessOutput(&out, nil))
+
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+	tt.MustEqual("ready\nprobing\n", out.String())
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+}
+
+func TestProcessProbeWaitsForLine(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var out syncBuffer
+	p := NewProcess(ProcessCommand("sh", "-c", "echo starting; sleep 0.05; echo ready; exec sleep 10"),
+		ProcessReadyLine(regexp.MustCompile("^ready$")),
+		ProcessReadyProbe(func(ctx context.Context) error { return nil }),
+		ProcessOutput(&out, nil))
+
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+	tt.MustEqual("starting\nready\n", out.String())
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+}
+
+func TestLineWriter(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var out bytes.Buffer
+	var lines []string
+	done := make(chan struct{})
+	lw := &lineWriter{w: &out, done: done, match: func(line []byte) {
+		lines = append(lines, string(line))
+	}}
+
+	write := func(s string) {
+		n, err := lw.Write([]byte(s))
+		tt.MustOK(err)
+		tt.MustEqual(len(s), n)
+	}
+
+	write("a")
+	write("b\r\nc\n")
+	tt.MustEqual([]string{"ab", "c"}, lines)
+
+	// Lines that are too long are skipped, but the line after them matches:
+	write(strings.Repeat("x", processMaxLineLen))
+	write("xx\nd\n")
+	tt.MustEqual([]string{"ab", "c", "d"}, lines)
+
+	// Nothing is matched or buffered once done is closed:
+	write("e")
+	close(done)
+	write("f\n")
+	tt.MustEqual([]string{"ab", "c", "d"}, lines)
+	tt.MustEqual(0, len(lw.buf))
+
+	tt.MustAssert(strings.HasSuffix(out.String(), "d\nef\n"))
+}
+
+// exited reports whether pid is gone, or is a zombie waiting for a parent
+// that may never reap it.
+func exited(pid int) bool {
+	if !processAlive(pid) {
+		return true
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses:
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestProcessKillsChildrenAfterExit(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+
+	// The child ignores SIGTERM and doesn't hold the output pipes, so the
+	// process exits straight away when it is halted, leaving the child:
+	script := "(trap '' TERM; exec sleep 10) </dev/null >/dev/null 2>&1 & " +
+		"echo $! > " + pidFile + "; echo ready; wait"
+	p := NewProcess(ProcessCommand("sh", "-c", script),
+		ProcessReadyLine(regexp.MustCompile("^ready$")),
+		ProcessGracePeriod(10*time.Second))
+
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", p)))
+
+	bts, err := ioutil.ReadFile(pidFile)
+	tt.MustOK(err)
+	child, err := strconv.Atoi(strings.TrimSpace(string(bts)))
+	tt.MustOK(err)
+	tt.MustAssert(!exited(child))
+
+	tm := time.Now()
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustAssert(time.Since(tm) < time.Second)
+
+	deadline := time.Now().Add(1 * time.Second)
+	for !exited(child) {
+		if time.Now().After(deadline) {
+			syscall.Kill(child, syscall.SIGKILL)
+			tt.Fatal("child that ignored SIGTERM was not killed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,32 @@
+// +build windows
+
+package serviceutil
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errNoProcessGroups = errors.New("serviceutil: process groups not supported")
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return errNoProcessGroups
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// processAlive reports whether pid exists. On Windows, FindProcess fails if
+// it does not.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
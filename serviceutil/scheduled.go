@@ -0,0 +1,284 @@
+package serviceutil
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+// Overlap controls what a Scheduled service does when a job is due to run
+// while a previous run of the job has not yet finished.
+type Overlap int
+
+const (
+	// OverlapSkip drops the run if the previous run is still in progress.
+	// Runs that are due at the same time, for example because of
+	// ScheduledCatchUp, are run one after the other.
+	OverlapSkip Overlap = iota
+
+	// OverlapQueue runs the job again as soon as the previous run finishes.
+	// Every run that comes due while the job is in progress is queued.
+	OverlapQueue
+
+	// OverlapConcurrent starts the job in a new goroutine regardless of
+	// whether previous runs have finished.
+	OverlapConcurrent
+)
+
+// Job is run by a Scheduled service each time its Schedule comes due.
+//
+// The ctx passed to a Job will be Done() when the Scheduled service is halted,
+// or when the per-run timeout supplied by ScheduledTimeout elapses. Jobs MUST
+// NOT call ctx.Ready().
+//
+// If a Job returns an error, it is passed to ctx.OnError() and the Scheduled
+// service continues to run.
+type Job func(ctx service.Context) error
+
+// Scheduled is an experimental service.Runnable that runs a Job periodically
+// according to a Schedule.
+//
+// It is intended to replace the common pattern of a Runnable that loops
+// around a service.Sleep() call.
+type Scheduled struct {
+	schedule Schedule
+	job      Job
+
+	overlap Overlap
+	catchUp int
+	jitter  time.Duration
+	timeout time.Duration
+
+	// randInt63n is rand.Int63n; tests replace it to control the jitter.
+	randInt63n func(n int64) int64
+
+	runs    uint64
+	skipped uint64
+	running int32
+}
+
+var _ service.Runnable = &Scheduled{}
+
+type ScheduledOption func(s *Scheduled)
+
+// ScheduledOverlap sets the policy for runs that come due while a previous
+// run is still in progress. The default is OverlapSkip.
+func ScheduledOverlap(overlap Overlap) ScheduledOption {
+	return func(s *Scheduled) { s.overlap = overlap }
+}
+
+// ScheduledCatchUp sets the maximum number of missed runs that will be
+// executed if the scheduler wakes up late, for example if the host was
+// suspended. By default, any number of missed runs are coalesced into one.
+func ScheduledCatchUp(max int) ScheduledOption {
+	return func(s *Scheduled) { s.catchUp = max }
+}
+
+// ScheduledJitter delays every run by a random duration in [0, jitter).
+// Activations that pass while a run is delayed by jitter are not counted as
+// missed.
+func ScheduledJitter(jitter time.Duration) ScheduledOption {
+	return func(s *Scheduled) { s.jitter = jitter }
+}
+
+// ScheduledTimeout bounds every run of the job. The ctx passed to the job is
+// Done() when the timeout elapses.
+func ScheduledTimeout(timeout time.Duration) ScheduledOption {
+	return func(s *Scheduled) { s.timeout = timeout }
+}
+
+func NewScheduled(schedule Schedule, job Job, options ...ScheduledOption) *Scheduled {
+	if schedule == nil {
+		panic("schedule was nil")
+	}
+	if job == nil {
+		panic("job was nil")
+	}
+	s := &Scheduled{
+		schedule:   schedule,
+		job:        job,
+		randInt63n: rand.Int63n,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Runs returns the number of times the job has been started.
+func (s *Scheduled) Runs() uint64 { return atomic.LoadUint64(&s.runs) }
+
+// Skipped returns the number of runs that were dropped by OverlapSkip because
+// the job was still running.
+func (s *Scheduled) Skipped() uint64 { return atomic.LoadUint64(&s.skipped) }
+
+// Running returns the number of runs of the job currently in progress.
+func (s *Scheduled) Running() int { return int(atomic.LoadInt32(&s.running)) }
+
+func (s *Scheduled) Run(ctx service.Context) error {
+	if err := ctx.Ready(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	w := &scheduledWorker{
+		scheduled: s,
+		ctx:       ctx,
+		wake:      make(chan struct{}, 1),
+	}
+	if s.overlap != OverlapConcurrent {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run()
+		}()
+	}
+
+	dispatch := func(due int) {
+		if s.overlap == OverlapConcurrent {
+			for i := 0; i < due; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.runJob(ctx)
+				}()
+			}
+		} else {
+			w.dispatch(due)
+		}
+	}
+
+	clock := service.ClockFromContext(ctx)
+	next := s.schedule.Next(clock.Now())
+	for {
+		if next.IsZero() {
+			// The schedule will never come due again:
+			<-ctx.Done()
+			return nil
+		}
+
+		var jitter time.Duration
+		if s.jitter > 0 {
+			jitter = time.Duration(s.randInt63n(int64(s.jitter)))
+		}
+
+		timer := clock.NewTimer(next.Sub(clock.Now()) + jitter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C():
+		}
+
+		// Work out how many activations we slept through. The jitter delayed
+		// us on purpose, so activations that passed during it are not missed;
+		// they will each get their own jitter next time around:
+		woke := clock.Now().Add(-jitter)
+		due := 1
+		next = s.schedule.Next(next)
+		for !next.IsZero() && !next.After(woke) {
+			due++
+			next = s.schedule.Next(next)
+		}
+		if due-1 > s.catchUp {
+			due = 1 + s.catchUp
+		}
+
+		dispatch(due)
+	}
+}
+
+// scheduledWorker runs jobs one at a time for OverlapSkip and OverlapQueue.
+type scheduledWorker struct {
+	scheduled *Scheduled
+	ctx       service.Context
+	wake      chan struct{}
+
+	mu   sync.Mutex
+	busy bool // Set by dispatch() when it hands the worker runs to do
+	owed int  // Runs the worker has been handed but not yet started
+}
+
+// dispatch hands the worker due runs. If the worker is busy and the overlap
+// policy is OverlapSkip, they are all skipped.
+func (w *scheduledWorker) dispatch(due int) {
+	w.mu.Lock()
+	if w.busy && w.scheduled.overlap == OverlapSkip {
+		w.mu.Unlock()
+		atomic.AddUint64(&w.scheduled.skipped, uint64(due))
+		return
+	}
+	w.busy = true
+	w.owed += due
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *scheduledWorker) run() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.wake:
+		}
+
+		for {
+			w.mu.Lock()
+			if w.owed == 0 || w.ctx.ShouldHalt() {
+				w.owed = 0
+				w.busy = false
+				w.mu.Unlock()
+				break
+			}
+			w.owed--
+			w.mu.Unlock()
+
+			w.scheduled.runJob(w.ctx)
+		}
+	}
+}
+
+func (s *Scheduled) runJob(ctx service.Context) {
+	atomic.AddUint64(&s.runs, 1)
+	atomic.AddInt32(&s.running, 1)
+	defer atomic.AddInt32(&s.running, -1)
+
+	jctx := ctx
+	if s.timeout > 0 {
+		tctx, cancel := service.WithClockTimeout(ctx, service.ClockFromContext(ctx), s.timeout)
+		defer cancel()
+		jctx = &jobContext{Context: ctx, ctx: tctx}
+	}
+
+	if err := s.job(jctx); err != nil {
+		ctx.OnError(err)
+	}
+}
+
+// jobContext narrows a service.Context to a single run of a Job.
+type jobContext struct {
+	service.Context
+	ctx context.Context
+}
+
+func (j *jobContext) Ready() error                            { return nil }
+func (j *jobContext) Done() <-chan struct{}                   { return j.ctx.Done() }
+func (j *jobContext) Err() error                              { return j.ctx.Err() }
+func (j *jobContext) Deadline() (deadline time.Time, ok bool) { return j.ctx.Deadline() }
+func (j *jobContext) Value(key interface{}) interface{}       { return j.ctx.Value(key) }
+
+func (j *jobContext) Draining() <-chan struct{} { return service.DrainingFromContext(j.Context) }
+
+func (j *jobContext) ShouldHalt() bool {
+	return j.ctx.Err() != nil || j.Context.ShouldHalt()
+}
@@ -0,0 +1,283 @@
+package serviceutil
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+	"github.com/shabbyrobe/go-service/servicetest"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2019, 1, 1, 12, 30, 15, 0, time.UTC) // Tuesday
+
+	for _, tc := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, 1, 1, 12, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, 1, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2019, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2019, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2019, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2019, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2019, 1, 4, 0, 0, 0, 0, time.UTC)}, // dom OR dow
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		t.Run(tc.expr, func(t *testing.T) {
+			tt := assert.WrapTB(t)
+			s, err := ParseCron(tc.expr)
+			tt.MustOK(err)
+			tt.MustEqual(tc.next, s.Next(base))
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		t.Run(expr, func(t *testing.T) {
+			tt := assert.WrapTB(t)
+			_, err := ParseCron(expr)
+			tt.MustAssert(err != nil)
+		})
+	}
+}
+
+func TestScheduledReportsErrors(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	jobErr := errors.New("job failed")
+	errs := make(chan error, 10)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	s := NewScheduled(Every(time.Millisecond), func(ctx service.Context) error {
+		return jobErr
+	})
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	tt.MustEqual(jobErr, <-errs)
+	tt.MustEqual(jobErr, <-errs)
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+}
+
+func TestScheduledOverlapSkip(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	var concurrent, maxConcurrent int32
+	s := NewScheduled(Every(time.Millisecond), func(ctx service.Context) error {
+		n := atomic.AddInt32(&concurrent, 1)
+		defer atomic.AddInt32(&concurrent, -1)
+		if n > atomic.LoadInt32(&maxConcurrent) {
+			atomic.StoreInt32(&maxConcurrent, n)
+		}
+		service.Sleep(ctx, 10*time.Millisecond)
+		return nil
+	}, ScheduledOverlap(OverlapSkip))
+
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+	time.Sleep(50 * time.Millisecond)
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+
+	tt.MustEqual(int32(1), atomic.LoadInt32(&maxConcurrent))
+	tt.MustAssert(s.Skipped() > 0)
+	tt.MustEqual(0, s.Running())
+}
+
+func TestScheduledTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	errs := make(chan error, 1)
+	runner := service.NewRunner(service.RunnerOnError(func(stage service.Stage, svc *service.Service, err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	s := NewScheduled(Every(time.Millisecond), func(ctx service.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, ScheduledTimeout(5*time.Millisecond))
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	select {
+	case err := <-errs:
+		tt.MustEqual("context deadline exceeded", err.Error())
+	case <-time.After(1 * time.Second):
+		tt.Fatal("timeout was not reported")
+	}
+}
+
+// fakeScheduled starts s in a Runner that uses a FakeClock. The returned
+// advance function waits for s to be waiting on its next activation before
+// moving the clock forward.
+func fakeScheduled(tt assert.T, s *Scheduled) (runner service.Runner, advance func(d time.Duration)) {
+	fc := servicetest.NewFakeClock(time.Time{})
+	runner = service.NewRunner(service.RunnerClock(fc))
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	advance = func(d time.Duration) {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+		tt.MustOK(fc.BlockUntilSleepers(ctx, 1))
+		fc.Advance(d)
+	}
+	return runner, advance
+}
+
+func awaitRuns(tt assert.T, ran <-chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-ran:
+		case <-time.After(1 * time.Second):
+			tt.Fatalf("job ran %d times, expected %d", i, n)
+		}
+	}
+}
+
+// blockingJob returns a Job that signals ran when it starts, then waits for
+// release.
+func blockingJob(ran chan<- struct{}, release <-chan struct{}) Job {
+	return func(ctx service.Context) error {
+		ran <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
+func TestScheduledOverlapSkipWhileRunning(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ran, release := make(chan struct{}, 10), make(chan struct{})
+	s := NewScheduled(Every(time.Minute), blockingJob(ran, release))
+	runner, advance := fakeScheduled(tt, s)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	advance(time.Minute)
+	awaitRuns(tt, ran, 1)
+	advance(time.Minute)
+	advance(time.Minute)
+	advance(0) // Wait for the last activation to be dispatched
+
+	tt.MustEqual(uint64(2), s.Skipped())
+	tt.MustEqual(uint64(1), s.Runs())
+
+	// Once the job finishes, an activation runs it again. The worker may not
+	// have noticed the job finished by the time the first one arrives, so
+	// keep trying:
+	close(release)
+	for i := 0; ; i++ {
+		advance(time.Minute)
+		select {
+		case <-ran:
+			tt.MustEqual(uint64(2+i), s.Skipped())
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		if i >= 10 {
+			tt.Fatal("job did not run again after it finished")
+		}
+	}
+}
+
+func TestScheduledOverlapQueue(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ran, release := make(chan struct{}, 10), make(chan struct{})
+	s := NewScheduled(Every(time.Minute), blockingJob(ran, release), ScheduledOverlap(OverlapQueue))
+	runner, advance := fakeScheduled(tt, s)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	advance(time.Minute)
+	awaitRuns(tt, ran, 1)
+	advance(time.Minute)
+	advance(time.Minute)
+	advance(0)
+	tt.MustEqual(1, s.Running())
+	tt.MustEqual(uint64(1), s.Runs())
+
+	// The queued activations run one after the other:
+	close(release)
+	awaitRuns(tt, ran, 2)
+	tt.MustEqual(uint64(3), s.Runs())
+	tt.MustEqual(uint64(0), s.Skipped())
+}
+
+func TestScheduledOverlapConcurrent(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ran, release := make(chan struct{}, 10), make(chan struct{})
+	s := NewScheduled(Every(time.Minute), blockingJob(ran, release), ScheduledOverlap(OverlapConcurrent))
+	runner, advance := fakeScheduled(tt, s)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	for i := 0; i < 3; i++ {
+		advance(time.Minute)
+	}
+	awaitRuns(tt, ran, 3)
+	tt.MustEqual(3, s.Running())
+
+	close(release)
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, s.Running())
+	tt.MustEqual(uint64(3), s.Runs())
+}
+
+func TestScheduledCatchUp(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ran := make(chan struct{}, 10)
+	s := NewScheduled(Every(time.Minute), func(ctx service.Context) error {
+		ran <- struct{}{}
+		return nil
+	}, ScheduledCatchUp(2))
+	runner, advance := fakeScheduled(tt, s)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	// Sleeping through 5 activations runs the job for the one that woke us
+	// and 2 of the 4 we missed:
+	advance(5 * time.Minute)
+	awaitRuns(tt, ran, 3)
+	advance(0)
+	tt.MustEqual(uint64(3), s.Runs())
+	tt.MustEqual(uint64(0), s.Skipped())
+}
+
+func TestScheduledJitter(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ran := make(chan struct{}, 10)
+	s := NewScheduled(Every(time.Minute), func(ctx service.Context) error {
+		ran <- struct{}{}
+		return nil
+	}, ScheduledJitter(3*time.Minute), ScheduledCatchUp(10))
+	s.randInt63n = func(n int64) int64 { return int64(150 * time.Second) }
+	runner, advance := fakeScheduled(tt, s)
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+
+	// Each activation is delayed by the jitter, but the activations that
+	// pass during the jitter are not counted as missed:
+	advance(time.Minute + 150*time.Second)
+	awaitRuns(tt, ran, 1)
+	advance(time.Minute)
+	awaitRuns(tt, ran, 1)
+	advance(0)
+	tt.MustEqual(uint64(2), s.Runs())
+}
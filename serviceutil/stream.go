@@ -0,0 +1,262 @@
+package serviceutil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+)
+
+// StreamHandler handles a single connection accepted by a StreamServer. The
+// connection is closed by the StreamServer when the handler returns.
+//
+// ctx is the StreamServer's service.Context; handlers should return promptly
+// once <-ctx.Done() yields.
+type StreamHandler func(ctx service.Context, conn net.Conn)
+
+// StreamServer is an experimental service.Runnable that accepts connections
+// on a stream-oriented network ("tcp", "tcp4", "tcp6" or "unix") and passes
+// each one to a StreamHandler in its own goroutine.
+//
+// When the service is halted, the listener is closed and handlers are given
+// the halt timeout supplied by StreamServerHaltTimeout to finish before all
+// remaining connections are forcibly closed.
+type StreamServer struct {
+	network string
+	address string
+	handler StreamHandler
+
+	keepAlive   time.Duration
+	maxConns    int
+	haltTimeout time.Duration
+
+	addr   net.Addr
+	conns  map[net.Conn]struct{}
+	active int32
+	mu     sync.Mutex
+}
+
+var _ service.Runnable = &StreamServer{}
+
+type StreamServerOption func(s *StreamServer)
+
+// StreamServerKeepAlive sets the keep-alive period for accepted TCP
+// connections. If zero, the net package's default is used. If negative,
+// keep-alives are disabled.
+func StreamServerKeepAlive(d time.Duration) StreamServerOption {
+	return func(s *StreamServer) { s.keepAlive = d }
+}
+
+// StreamServerMaxConns limits the number of connections that are handled
+// concurrently. Once the limit is reached, no new connections are accepted
+// until an existing handler returns. If n <= 0, there is no limit.
+func StreamServerMaxConns(n int) StreamServerOption {
+	return func(s *StreamServer) { s.maxConns = n }
+}
+
+// StreamServerHaltTimeout sets how long handlers have to finish after the
+// service is halted before their connections are forcibly closed. By default,
+// connections are closed immediately.
+func StreamServerHaltTimeout(d time.Duration) StreamServerOption {
+	return func(s *StreamServer) { s.haltTimeout = d }
+}
+
+func NewStreamServer(network, address string, handler StreamHandler, options ...StreamServerOption) *StreamServer {
+	if handler == nil {
+		panic("handler was nil")
+	}
+	s := &StreamServer{
+		network: network,
+		address: address,
+		handler: handler,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Addr returns the address the server is bound to, or nil if it is not
+// running.
+func (s *StreamServer) Addr() (addr net.Addr) {
+	s.mu.Lock()
+	addr = s.addr
+	s.mu.Unlock()
+	return addr
+}
+
+// ActiveConns returns the number of connections currently being handled.
+func (s *StreamServer) ActiveConns() int { return int(atomic.LoadInt32(&s.active)) }
+
+func (s *StreamServer) Run(ctx service.Context) (rerr error) {
+	if s.network == "unix" {
+		if err := removeStaleSocket(s.address); err != nil {
+			return err
+		}
+	}
+
+	lc := net.ListenConfig{KeepAlive: s.keepAlive}
+	ln, err := lc.Listen(ctx, s.network, s.address)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.addr = ln.Addr()
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.addr = nil
+		s.mu.Unlock()
+	}()
+
+	if err := ctx.Ready(); err != nil {
+		ln.Close()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	acceptDone := make(chan error, 1)
+	go func() {
+		acceptDone <- s.accept(ctx, ln, &wg)
+	}()
+
+	select {
+	case rerr = <-acceptDone:
+		ln.Close()
+	case <-ctx.Done():
+		ln.Close()
+		<-acceptDone
+	}
+
+	s.drain(service.ClockFromContext(ctx), &wg)
+	return rerr
+}
+
+func (s *StreamServer) accept(ctx service.Context, ln net.Listener, wg *sync.WaitGroup) error {
+	var sem chan struct{}
+	if s.maxConns > 0 {
+		sem = make(chan struct{}, s.maxConns)
+	}
+
+	var tempDelay time.Duration
+	for {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.ShouldHalt() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// Same approach as net/http.Server.Serve:
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				ctx.OnError(err)
+				if sem != nil {
+					<-sem
+				}
+				if service.Sleep(ctx, tempDelay) {
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+
+		s.track(conn, true)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				s.track(conn, false)
+				conn.Close()
+				if sem != nil {
+					<-sem
+				}
+			}()
+			s.handler(ctx, conn)
+		}()
+	}
+}
+
+func (s *StreamServer) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	if add {
+		s.conns[conn] = struct{}{}
+		atomic.AddInt32(&s.active, 1)
+	} else {
+		delete(s.conns, conn)
+		atomic.AddInt32(&s.active, -1)
+	}
+	s.mu.Unlock()
+}
+
+// drain waits for handlers to finish for up to haltTimeout, then closes any
+// remaining connections and waits for their handlers to return.
+func (s *StreamServer) drain(clock service.Clock, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if s.haltTimeout > 0 {
+		timer := clock.NewTimer(s.haltTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return
+		case <-timer.C():
+		}
+	}
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	<-done
+}
+
+// removeStaleSocket removes the unix socket at path if nothing is listening
+// on it. It is an error for path to exist if it is not a socket, or if
+// another process is listening on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("serviceutil: %q exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, 100*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("serviceutil: socket %q is in use", path)
+	}
+	return os.Remove(path)
+}
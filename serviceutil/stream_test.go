@@ -0,0 +1,134 @@
+package serviceutil
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func echoHandler(ctx service.Context, conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+func TestStreamServerEcho(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := NewStreamServer("tcp", "127.0.0.1:0", echoHandler)
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	tt.MustOK(err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello\n"))
+	tt.MustOK(err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	tt.MustOK(err)
+	tt.MustEqual("hello\n", line)
+	tt.MustEqual(1, s.ActiveConns())
+
+	// Halting must close the connection even though the handler is blocked
+	// reading from it:
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	tt.MustEqual(0, s.ActiveConns())
+	tt.MustAssert(s.Addr() == nil)
+}
+
+func TestStreamServerMaxConns(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := NewStreamServer("tcp", "127.0.0.1:0", echoHandler, StreamServerMaxConns(1))
+	runner := service.NewRunner()
+	defer service.MustShutdownTimeout(1*time.Second, runner)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	c1, err := net.Dial("tcp", s.Addr().String())
+	tt.MustOK(err)
+	defer c1.Close()
+	c2, err := net.Dial("tcp", s.Addr().String())
+	tt.MustOK(err)
+	defer c2.Close()
+
+	// The second connection sits in the listen backlog until the first
+	// handler returns:
+	c2.Write([]byte("2\n"))
+	c2.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = bufio.NewReader(c2).ReadString('\n')
+	tt.MustAssert(err != nil)
+	tt.MustEqual(1, s.ActiveConns())
+
+	c1.Close()
+	c2.SetReadDeadline(time.Now().Add(1 * time.Second))
+	line, err := bufio.NewReader(c2).ReadString('\n')
+	tt.MustOK(err)
+	tt.MustEqual("2\n", line)
+}
+
+func TestStreamServerHaltTimeoutWaits(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	finished := make(chan struct{})
+	handler := func(ctx service.Context, conn net.Conn) {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	}
+
+	s := NewStreamServer("tcp", "127.0.0.1:0", handler, StreamServerHaltTimeout(1*time.Second))
+	runner := service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, service.New("", s)))
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	tt.MustOK(err)
+	defer conn.Close()
+	for s.ActiveConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	select {
+	case <-finished:
+	default:
+		tt.Fatal("handler did not finish before halt returned")
+	}
+}
+
+func TestStreamServerUnixStaleSocket(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+
+	// Leave a stale socket file lying around:
+	ln, err := net.Listen("unix", path)
+	tt.MustOK(err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	tt.MustOK(ln.Close())
+	_, err = os.Stat(path)
+	tt.MustOK(err)
+
+	s := NewStreamServer("unix", path, echoHandler)
+	runner := service.NewRunner()
+	svc := service.New("", s)
+	tt.MustOK(service.StartTimeout(1*time.Second, runner, svc))
+
+	// A second server must refuse to steal a live socket:
+	s2 := NewStreamServer("unix", path, echoHandler)
+	tt.MustAssert(service.StartTimeout(1*time.Second, runner, service.New("", s2)) != nil)
+
+	tt.MustOK(service.ShutdownTimeout(1*time.Second, runner))
+	_, err = os.Stat(path)
+	tt.MustAssert(os.IsNotExist(err))
+}
@@ -101,7 +101,7 @@ func (t *TimedRestart) Suspend(suspended bool) (changed bool) {
 
 func (t *TimedRestart) Run(ctx service.Context) error {
 	failer := service.NewFailureListener(1)
-	runner := service.NewRunner(failer.ForRunner())
+	runner := service.NewRunner(failer.ForRunner(), service.RunnerClock(service.ClockFromContext(ctx)))
 
 	// This is an interesting one. If the service fails to start first go,
 	// we can't exactly say we're "ready", but we can't hold everything
@@ -0,0 +1,129 @@
+package serviceutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOption configures a tls.Config. See NewTLSConfig and HTTP.SetTLS.
+type TLSOption func(config *tls.Config) error
+
+// NewTLSConfig creates a tls.Config from the supplied options. It is useful
+// for building the TLSConfig for an HTTPListener.
+func NewTLSConfig(options ...TLSOption) (*tls.Config, error) {
+	config := &tls.Config{}
+	if err := applyTLSOptions(config, options); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func applyTLSOptions(config *tls.Config, options []TLSOption) error {
+	for _, o := range options {
+		if err := o(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TLSCertFile loads a PEM encoded certificate and key from files. The files
+// are loaded when the option is applied.
+func TLSCertFile(certFile, keyFile string) TLSOption {
+	return func(config *tls.Config) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+		return nil
+	}
+}
+
+// TLSCertPEM adds a PEM encoded certificate and key.
+func TLSCertPEM(certPEM, keyPEM []byte) TLSOption {
+	return func(config *tls.Config) error {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+		return nil
+	}
+}
+
+// TLSClientCAFile requires clients to present a certificate signed by one of
+// the PEM encoded CAs in caFile (mutual TLS). Use TLSClientAuth after this
+// option if you need a policy other than tls.RequireAndVerifyClientCert.
+func TLSClientCAFile(caFile string) TLSOption {
+	return func(config *tls.Config) error {
+		bts, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		return TLSClientCAPEM(bts)(config)
+	}
+}
+
+// TLSClientCAPEM requires clients to present a certificate signed by one of
+// the PEM encoded CAs in caPEM. See TLSClientCAFile.
+//
+// If config.ClientCAs is already set, the CAs are added to a copy of it, as
+// the pool may be shared with other configs.
+func TLSClientCAPEM(caPEM []byte) TLSOption {
+	return func(config *tls.Config) error {
+		pool := x509.NewCertPool()
+		if config.ClientCAs != nil {
+			var err error
+			if pool, err = cloneCertPool(config.ClientCAs); err != nil {
+				return err
+			}
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("serviceutil: no certificates found in client CA PEM")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		return nil
+	}
+}
+
+// TLSClientAuth sets the policy for client certificates.
+func TLSClientAuth(auth tls.ClientAuthType) TLSOption {
+	return func(config *tls.Config) error {
+		config.ClientAuth = auth
+		return nil
+	}
+}
+
+// TLSMinVersion sets the minimum TLS version that will be negotiated, i.e.
+// tls.VersionTLS12.
+func TLSMinVersion(version uint16) TLSOption {
+	return func(config *tls.Config) error {
+		config.MinVersion = version
+		return nil
+	}
+}
+
+// TLSCipherSuites restricts the cipher suites that will be negotiated for TLS
+// 1.2 and below. TLS 1.3 suites are not configurable.
+//
+// If HTTP/2 is enabled, the list must include an AES_128_GCM_SHA256 suite or
+// the HTTP service will fail to start.
+func TLSCipherSuites(suites ...uint16) TLSOption {
+	return func(config *tls.Config) error {
+		config.CipherSuites = suites
+		return nil
+	}
+}
+
+// TLSNextProtos sets the protocols offered via ALPN, in order of preference.
+// If not set, the HTTP service offers "h2" and "http/1.1".
+func TLSNextProtos(protos ...string) TLSOption {
+	return func(config *tls.Config) error {
+		config.NextProtos = protos
+		return nil
+	}
+}
@@ -0,0 +1,9 @@
+// +build go1.19
+
+package serviceutil
+
+import "crypto/x509"
+
+func cloneCertPool(pool *x509.CertPool) (*x509.CertPool, error) {
+	return pool.Clone(), nil
+}
@@ -0,0 +1,14 @@
+// +build !go1.19
+
+package serviceutil
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+var errCertPoolClone = errors.New("serviceutil: adding to an existing ClientCAs pool requires go1.19 or later")
+
+func cloneCertPool(pool *x509.CertPool) (*x509.CertPool, error) {
+	return nil, errCertPoolClone
+}
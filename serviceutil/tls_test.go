@@ -0,0 +1,321 @@
+package serviceutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	service "github.com/shabbyrobe/go-service"
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+type tlsTestServer struct {
+	*HTTP
+	CA     *testCert
+	Server *testCert
+	runner service.Runner
+}
+
+func newTLSTestServer(tt assert.T) *tlsTestServer {
+	tt.Helper()
+	ca := newTestCert(tt, "ca", nil)
+	return &tlsTestServer{
+		HTTP: NewHTTP(&http.Server{
+			Addr:     "127.0.0.1:0",
+			ErrorLog: log.New(ioutil.Discard, "", 0),
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.Proto))
+			}),
+		}),
+		CA:     ca,
+		Server: newTestCert(tt, "server", ca),
+	}
+}
+
+func (s *tlsTestServer) Start(tt assert.T) {
+	tt.Helper()
+	s.runner = service.NewRunner()
+	tt.MustOK(service.StartTimeout(1*time.Second, s.runner, service.New("http", s.HTTP)))
+}
+
+func (s *tlsTestServer) Stop(tt assert.T) {
+	tt.Helper()
+	if s.runner != nil {
+		tt.MustOK(service.ShutdownTimeout(1*time.Second, s.runner))
+	}
+}
+
+// Get makes a request to the server using a real TLS client that trusts the
+// test CA. The client config may be modified by configure.
+func (s *tlsTestServer) Get(configure func(c *tls.Config)) (string, *tls.ConnectionState, error) {
+	config := &tls.Config{RootCAs: s.CA.Pool()}
+	if configure != nil {
+		configure(config)
+	}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{
+		TLSClientConfig:   config,
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}}
+	rs, err := client.Get("https://" + s.Port()[0].String() + "/")
+	if err != nil {
+		return "", nil, err
+	}
+	defer rs.Body.Close()
+	b, err := ioutil.ReadAll(rs.Body)
+	return string(b), rs.TLS, err
+}
+
+func TestHTTPSetTLSCertFile(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	s := newTLSTestServer(tt)
+	s.Server.Write(tt, certFile, keyFile)
+
+	// Used to panic: Server.TLSConfig was dereferenced before the nil check.
+	s.SetTLSCertFile(certFile, keyFile)
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	body, _, err := s.Get(nil)
+	tt.MustOK(err)
+	tt.MustEqual("HTTP/2.0", body)
+}
+
+func TestHTTPSetTLSCert(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	tt.MustOK(s.SetTLSCert(s.Server.CertPEM, s.Server.KeyPEM))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	_, state, err := s.Get(nil)
+	tt.MustOK(err)
+	tt.MustEqual("server", state.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestHTTPSetTLSClientCA(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	client := newTestCert(tt, "client", s.CA)
+	stranger := newTestCert(tt, "stranger", newTestCert(tt, "otherca", nil))
+
+	tt.MustOK(s.SetTLS(
+		TLSCertPEM(s.Server.CertPEM, s.Server.KeyPEM),
+		TLSClientCAPEM(s.CA.CertPEM),
+	))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	_, _, err := s.Get(nil)
+	tt.MustAssert(err != nil, "expected handshake failure without a client cert")
+
+	_, _, err = s.Get(func(c *tls.Config) {
+		c.Certificates = []tls.Certificate{stranger.TLSCertificate(tt)}
+	})
+	tt.MustAssert(err != nil, "expected handshake failure with an untrusted client cert")
+
+	_, _, err = s.Get(func(c *tls.Config) {
+		c.Certificates = []tls.Certificate{client.TLSCertificate(tt)}
+	})
+	tt.MustOK(err)
+}
+
+func TestHTTPSetTLSMinVersion(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	tt.MustOK(s.SetTLS(
+		TLSCertPEM(s.Server.CertPEM, s.Server.KeyPEM),
+		TLSMinVersion(tls.VersionTLS13),
+	))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	_, _, err := s.Get(func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+	tt.MustAssert(err != nil, "expected handshake failure below the minimum version")
+
+	_, state, err := s.Get(nil)
+	tt.MustOK(err)
+	tt.MustEqual(uint16(tls.VersionTLS13), state.Version)
+}
+
+func TestHTTPSetTLSCipherSuites(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	tt.MustOK(s.SetTLS(
+		TLSCertPEM(s.Server.CertPEM, s.Server.KeyPEM),
+		TLSCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+	))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	_, _, err := s.Get(func(c *tls.Config) {
+		c.MaxVersion = tls.VersionTLS12
+		c.CipherSuites = []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	})
+	tt.MustAssert(err != nil, "expected handshake failure with no common cipher suite")
+
+	_, state, err := s.Get(func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+	tt.MustOK(err)
+	tt.MustEqual(uint16(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), state.CipherSuite)
+}
+
+func TestTLSClientCAPEMSharedPool(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	ca1, ca2 := newTestCert(tt, "ca1", nil), newTestCert(tt, "ca2", nil)
+	client1, client2 := newTestCert(tt, "client1", ca1), newTestCert(tt, "client2", ca2)
+	verify := func(pool *x509.CertPool, cert *testCert) error {
+		_, err := cert.Cert.Verify(x509.VerifyOptions{
+			Roots:     pool,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return err
+	}
+
+	// The pool may be used by other configs, so it must not be modified:
+	shared := ca1.Pool()
+	config := &tls.Config{ClientCAs: shared}
+	tt.MustOK(TLSClientCAPEM(ca2.CertPEM)(config))
+
+	tt.MustOK(verify(config.ClientCAs, client1))
+	tt.MustOK(verify(config.ClientCAs, client2))
+	tt.MustOK(verify(shared, client1))
+	tt.MustAssert(verify(shared, client2) != nil, "shared pool was modified")
+}
+
+func TestHTTPListenerTLSNextProtos(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	s := newTLSTestServer(tt)
+	config, err := NewTLSConfig(
+		TLSCertPEM(s.Server.CertPEM, s.Server.KeyPEM),
+		TLSNextProtos("http/1.1"),
+	)
+	tt.MustOK(err)
+	s.AddListener("tcp", "127.0.0.1:0", config)
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	body, state, err := s.Get(nil)
+	tt.MustOK(err)
+	tt.MustEqual("http/1.1", state.NegotiatedProtocol)
+	tt.MustEqual("HTTP/1.1", body)
+}
+
+func TestHTTPSetTLSCertReloader(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	s := newTLSTestServer(tt)
+	s.Server.Write(tt, certFile, keyFile)
+	tt.MustOK(ioutil.WriteFile(caFile, s.CA.CertPEM, 0600))
+
+	reloader, err := NewCertReloader(certFile, keyFile, CertReloaderClientCA(caFile))
+	tt.MustOK(err)
+	s.SetTLSCertReloader(reloader)
+	tt.MustOK(s.SetTLS(TLSMinVersion(tls.VersionTLS12)))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	client := newTestCert(tt, "client", s.CA)
+	useClient := func(c *tls.Config) { c.Certificates = []tls.Certificate{client.TLSCertificate(tt)} }
+
+	body, state, err := s.Get(useClient)
+	tt.MustOK(err)
+	tt.MustEqual("server", state.PeerCertificates[0].Subject.CommonName)
+	tt.MustEqual("h2", state.NegotiatedProtocol)
+	tt.MustEqual("HTTP/2.0", body)
+
+	// Rotate both the server cert and the client CA:
+	ca2 := newTestCert(tt, "ca2", nil)
+	newTestCert(tt, "server2", ca2).Write(tt, certFile, keyFile)
+	tt.MustOK(ioutil.WriteFile(caFile, ca2.CertPEM, 0600))
+	tt.MustOK(reloader.Reload())
+	s.CA = ca2
+
+	_, _, err = s.Get(useClient)
+	tt.MustAssert(err != nil, "expected old client cert to be rejected")
+
+	client = newTestCert(tt, "client2", ca2)
+	_, state, err = s.Get(useClient)
+	tt.MustOK(err)
+	tt.MustEqual("server2", state.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestHTTPSetTLSCertReloaderHTTP2Disabled(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	s := newTLSTestServer(tt)
+	s.Server.Write(tt, certFile, keyFile)
+	tt.MustOK(ioutil.WriteFile(caFile, s.CA.CertPEM, 0600))
+
+	// A non-nil, empty TLSNextProto disables HTTP/2, so h2 must not be
+	// offered to clients that support it:
+	s.HTTP.Server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	reloader, err := NewCertReloader(certFile, keyFile, CertReloaderClientCA(caFile))
+	tt.MustOK(err)
+	s.SetTLSCertReloader(reloader)
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	client := newTestCert(tt, "client", s.CA)
+	body, state, err := s.Get(func(c *tls.Config) { c.Certificates = []tls.Certificate{client.TLSCertificate(tt)} })
+	tt.MustOK(err)
+	tt.MustEqual("http/1.1", state.NegotiatedProtocol)
+	tt.MustEqual("HTTP/1.1", body)
+}
+
+func TestHTTPSetTLSCertReloaderWithClientCA(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	dir, err := ioutil.TempDir("", "")
+	tt.MustOK(err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	s := newTLSTestServer(tt)
+	s.Server.Write(tt, certFile, keyFile)
+
+	// The reloader has no client CA file, so the pool set by SetTLS is used:
+	reloader, err := NewCertReloader(certFile, keyFile)
+	tt.MustOK(err)
+	s.SetTLSCertReloader(reloader)
+	tt.MustOK(s.SetTLS(TLSClientCAPEM(s.CA.CertPEM)))
+	s.Start(tt)
+	defer s.Stop(tt)
+
+	_, _, err = s.Get(nil)
+	tt.MustAssert(err != nil, "expected request without a client cert to be rejected")
+
+	client := newTestCert(tt, "client", s.CA)
+	_, state, err := s.Get(func(c *tls.Config) { c.Certificates = []tls.Certificate{client.TLSCertificate(tt)} })
+	tt.MustOK(err)
+	tt.MustEqual("h2", state.NegotiatedProtocol)
+}
@@ -0,0 +1,123 @@
+package signal
+
+import (
+	"context"
+	"sync"
+)
+
+// Barrier is a cyclic barrier that lets a fixed number of parties, for
+// example several services, rendezvous before moving on to the next phase of
+// their work.
+//
+// Each party calls Arrive() and waits for the channel it returns to yield.
+// When the last party arrives, every waiting party yields nil together and
+// the Barrier is reset for the next phase, so it can be reused as many times
+// as necessary.
+//
+//	b := signal.NewBarrier(2)
+//	// In each of two services:
+//	if err := <-b.ArriveContext(ctx); err != nil {
+//		return err
+//	}
+//
+type Barrier struct {
+	parties int
+	phase   uint64
+	waiters []chan error
+	mu      sync.Mutex
+}
+
+func NewBarrier(parties int) *Barrier {
+	if parties <= 0 {
+		panic("service: Barrier parties must be > 0")
+	}
+	return &Barrier{parties: parties}
+}
+
+// Parties returns the number of parties required to trip the Barrier.
+func (b *Barrier) Parties() int { return b.parties }
+
+// Waiting returns the number of parties currently waiting at the Barrier.
+func (b *Barrier) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiters)
+}
+
+// Phase returns the number of times the Barrier has tripped or been broken.
+func (b *Barrier) Phase() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.phase
+}
+
+// Arrive registers a party at the Barrier. The returned channel yields nil
+// when the last party arrives, or the error passed to Break() if the Barrier
+// is broken first.
+func (b *Barrier) Arrive() <-chan error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan error, 1)
+	b.waiters = append(b.waiters, c)
+	if len(b.waiters) >= b.parties {
+		b.release(nil)
+	}
+	return c
+}
+
+// ArriveContext is the same as Arrive(), except that if ctx is done before
+// the Barrier trips, the party withdraws from the Barrier and the channel
+// yields ctx.Err(). The withdrawn party no longer counts towards the parties
+// required to trip the Barrier. ctx may be nil, in which case it is the same
+// as Arrive().
+func (b *Barrier) ArriveContext(ctx context.Context) <-chan error {
+	w := b.Arrive()
+	if ctx == nil || ctx.Done() == nil {
+		return w
+	}
+
+	out := make(chan error, 1)
+	go func() {
+		select {
+		case err := <-w:
+			out <- err
+		case <-ctx.Done():
+			b.mu.Lock()
+			b.waiters = removeWaiter(b.waiters, w)
+			b.mu.Unlock()
+
+			// The Barrier may have tripped while we were withdrawing; if so,
+			// that result should not be lost:
+			select {
+			case err := <-w:
+				out <- err
+			default:
+				out <- ctx.Err()
+			}
+		}
+	}()
+	return out
+}
+
+// Break releases every party currently waiting at the Barrier with err, which
+// must not be nil, and resets the Barrier for the next phase. Use it when one
+// of the parties has failed and will never arrive.
+func (b *Barrier) Break(err error) {
+	if err == nil {
+		panic("service: Barrier broken with nil error")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release(err)
+}
+
+// release yields err to every waiting party and starts the next phase.
+// b.mu must be held.
+func (b *Barrier) release(err error) {
+	for _, c := range b.waiters {
+		c <- err
+	}
+	b.waiters = nil
+	b.phase++
+}
@@ -0,0 +1,226 @@
+package signal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestBarrierTrips(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBarrier(3)
+	w1, w2 := b.Arrive(), b.Arrive()
+	tt.MustEqual(2, b.Waiting())
+	assertWaiterEmpty(tt, w1)
+
+	w3 := b.Arrive()
+	tt.MustOK(<-w1)
+	tt.MustOK(<-w2)
+	tt.MustOK(<-w3)
+	tt.MustEqual(0, b.Waiting())
+	tt.MustEqual(uint64(1), b.Phase())
+
+	// The next phase starts from scratch:
+	w1 = b.Arrive()
+	assertWaiterEmpty(tt, w1)
+}
+
+func TestBarrierBreak(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBarrier(3)
+	w1, w2 := b.Arrive(), b.Arrive()
+	err := errors.New("yep")
+	b.Break(err)
+	tt.MustEqual(err, <-w1)
+	tt.MustEqual(err, <-w2)
+	tt.MustEqual(0, b.Waiting())
+	tt.MustEqual(uint64(1), b.Phase())
+}
+
+func TestBarrierArriveContext(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBarrier(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := b.ArriveContext(ctx)
+	cancel()
+	tt.MustEqual(context.Canceled, <-w)
+
+	// The cancelled party no longer counts towards the barrier:
+	tt.MustEqual(0, b.Waiting())
+	w1 := b.Arrive()
+	assertWaiterEmpty(tt, w1)
+	w2 := b.ArriveContext(context.Background())
+	tt.MustOK(<-w1)
+	tt.MustOK(<-w2)
+}
+
+func TestBarrierArriveContextNil(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	// A nil context waits forever, like AwaitSignal:
+	b := NewBarrier(2)
+	w1 := b.ArriveContext(nil)
+	assertWaiterEmpty(tt, w1)
+	tt.MustEqual(1, b.Waiting())
+
+	w2 := b.Arrive()
+	tt.MustOK(<-w1)
+	tt.MustOK(<-w2)
+}
+
+// TestBarrierFuzz applies a random sequence of operations to a Barrier from a
+// single goroutine and checks every party against a simple model after each
+// one.
+func TestBarrierFuzz(t *testing.T) {
+	rng := rand.New(rand.NewSource(fuzzSeed))
+
+	type party struct {
+		c        <-chan error
+		expected error
+		released bool
+		received bool
+	}
+
+	for iter := 0; iter < fuzzIters; iter++ {
+		parties := rng.Intn(5) + 1
+		b := NewBarrier(parties)
+		var waiting, all []*party
+		var phase uint64
+		var ops []string
+
+		fail := func(msg string, args ...interface{}) {
+			t.Helper()
+			t.Fatalf("seed %d, iter %d, parties %d, ops %v: %s", fuzzSeed, iter, parties, ops, fmt.Sprintf(msg, args...))
+		}
+
+		release := func(err error) {
+			for _, p := range waiting {
+				p.released, p.expected = true, err
+			}
+			waiting = nil
+			phase++
+		}
+
+		for i, n := 0, rng.Intn(50); i < n; i++ {
+			switch rng.Intn(5) {
+			case 0, 1, 2:
+				ops = append(ops, "arrive")
+				p := &party{c: b.Arrive()}
+				waiting = append(waiting, p)
+				all = append(all, p)
+				if len(waiting) == parties {
+					release(nil)
+				}
+
+			case 3:
+				err := fmt.Errorf("err %d", i)
+				ops = append(ops, "break")
+				b.Break(err)
+				release(err)
+
+			case 4:
+				// Arrive and withdraw. If this party trips the barrier, it is
+				// released rather than withdrawn:
+				ops = append(ops, "arrive-cancel")
+				ctx, cancel := context.WithCancel(context.Background())
+				w := b.ArriveContext(ctx)
+				cancel()
+				err := <-w
+				if len(waiting)+1 == parties {
+					if err != nil {
+						fail("tripping party yielded %v", err)
+					}
+					release(nil)
+				} else if err != context.Canceled {
+					fail("withdrawn party yielded %v", err)
+				}
+			}
+
+			if b.Waiting() != len(waiting) {
+				fail("Waiting() was %d, expected %d", b.Waiting(), len(waiting))
+			}
+			if b.Phase() != phase {
+				fail("Phase() was %d, expected %d", b.Phase(), phase)
+			}
+			for idx, p := range all {
+				select {
+				case err := <-p.c:
+					if !p.released || p.received {
+						fail("party %d yielded unexpectedly", idx)
+					} else if err != p.expected {
+						fail("party %d yielded %v, expected %v", idx, err, p.expected)
+					}
+					p.received = true
+				default:
+					if p.released && !p.received {
+						fail("party %d did not yield", idx)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestBarrierFuzzConcurrent runs several parties through several phases with
+// random delays, and checks that no party leaves a phase before every party
+// has arrived at it.
+func TestBarrierFuzzConcurrent(t *testing.T) {
+	rng := rand.New(rand.NewSource(fuzzSeed))
+
+	iters := fuzzIters / 10
+	if iters < 1 {
+		iters = 1
+	}
+	for iter := 0; iter < iters; iter++ {
+		parties, phases := rng.Intn(8)+1, rng.Intn(5)+1
+		b := NewBarrier(parties)
+		arrived := make([]int32, phases)
+
+		delays := make([][]time.Duration, parties)
+		for i := range delays {
+			for j := 0; j < phases; j++ {
+				delays[i] = append(delays[i], time.Duration(rng.Int63n(int64(100*time.Microsecond))))
+			}
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, parties*phases)
+		for i := 0; i < parties; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for phase := 0; phase < phases; phase++ {
+					time.Sleep(delays[i][phase])
+					atomic.AddInt32(&arrived[phase], 1)
+					if err := <-b.Arrive(); err != nil {
+						errs <- err
+						return
+					}
+					if n := atomic.LoadInt32(&arrived[phase]); int(n) != parties {
+						errs <- fmt.Errorf("left phase %d with %d of %d parties arrived", phase, n, parties)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Fatalf("seed %d, iter %d, parties %d, phases %d: %v", fuzzSeed, iter, parties, phases, err)
+		}
+		if b.Phase() != uint64(phases) {
+			t.Fatalf("seed %d, iter %d: phase %d, expected %d", fuzzSeed, iter, b.Phase(), phases)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package signal
+
+import "context"
+
+// Broadcast is a resettable Signal that releases all of its waiters together.
+//
+// Every channel returned by Waiter() before Done() is called yields the error
+// passed to Done(). Until Reset() is called, any channel returned by Waiter()
+// after Done() yields that error immediately. After Reset(), new waiters wait
+// for the next call to Done().
+//
+// Reset() does not affect channels that have already been returned by
+// Waiter(); a waiter registered before Reset() is released by the next call
+// to Done(), along with the waiters registered after it.
+type Broadcast struct {
+	ev event
+}
+
+var _ Signal = &Broadcast{}
+
+func NewBroadcast() *Broadcast {
+	return &Broadcast{}
+}
+
+// Done releases all waiters with err. It returns false if Done() has already
+// been called since the Broadcast was created or last reset.
+func (b *Broadcast) Done(err error) (ok bool) { return b.ev.fire(err) }
+
+// Reset re-arms the Broadcast so new waiters wait for the next call to
+// Done(). It is safe to call Reset() on a Broadcast that has not been done.
+func (b *Broadcast) Reset() { b.ev.reset() }
+
+// IsDone reports whether Done() has been called since the Broadcast was
+// created or last reset.
+func (b *Broadcast) IsDone() bool {
+	fired, _ := b.ev.state()
+	return fired
+}
+
+func (b *Broadcast) Waiter() <-chan error { return b.ev.waiter() }
+
+// WaiterContext is the same as Waiter(), except that if ctx is done before
+// the Broadcast is, the channel yields ctx.Err() and the waiter is discarded.
+// ctx may be nil, in which case it is the same as Waiter().
+func (b *Broadcast) WaiterContext(ctx context.Context) <-chan error {
+	return b.ev.waiterContext(ctx)
+}
+
+func (b *Broadcast) Progress() Progress { return b.ev.progress() }
@@ -0,0 +1,184 @@
+package signal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestBroadcastReleasesAllWaiters(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBroadcast()
+	w1, w2 := b.Waiter(), b.Waiter()
+	assertWaiterEmpty(tt, w1)
+
+	err := errors.New("yep")
+	tt.MustAssert(b.Done(err))
+	tt.MustAssert(!b.Done(nil))
+	tt.MustEqual(err, <-w1)
+	tt.MustEqual(err, <-w2)
+
+	// Waiters after Done yield immediately:
+	tt.MustEqual(err, <-b.Waiter())
+	tt.MustAssert(b.IsDone())
+	tt.MustEqual(1, b.Progress().Completed)
+}
+
+func TestBroadcastReset(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBroadcast()
+	tt.MustAssert(b.Done(nil))
+	tt.MustOK(<-b.Waiter())
+
+	b.Reset()
+	tt.MustAssert(!b.IsDone())
+	w := b.Waiter()
+	assertWaiterEmpty(tt, w)
+	tt.MustAssert(b.Done(nil))
+	tt.MustOK(<-w)
+}
+
+func TestBroadcastWaiterContext(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	b := NewBroadcast()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := b.WaiterContext(ctx)
+	cancel()
+	tt.MustEqual(context.Canceled, <-w)
+	tt.MustEqual(0, len(b.ev.waiters))
+
+	w = b.WaiterContext(nil)
+	tt.MustEqual(context.DeadlineExceeded, AwaitSignalTimeout(tscale, b))
+	tt.MustAssert(b.Done(nil))
+	tt.MustOK(AwaitSignalTimeout(dto, b))
+	tt.MustOK(<-w)
+}
+
+// TestBroadcastFuzz applies a random sequence of operations to a Broadcast
+// and checks every waiter against a simple model after each one.
+func TestBroadcastFuzz(t *testing.T) {
+	rng := rand.New(rand.NewSource(fuzzSeed))
+
+	type waiter struct {
+		c        <-chan error
+		expected error
+		released bool
+		received bool
+	}
+
+	for iter := 0; iter < fuzzIters; iter++ {
+		b := NewBroadcast()
+		var waiters []*waiter
+		var fired bool
+		var firedErr error
+		var ops []string
+
+		fail := func(msg string, args ...interface{}) {
+			t.Helper()
+			t.Fatalf("seed %d, iter %d, ops %v: %s", fuzzSeed, iter, ops, fmt.Sprintf(msg, args...))
+		}
+
+		for i, n := 0, rng.Intn(50); i < n; i++ {
+			switch rng.Intn(4) {
+			case 0, 1:
+				ops = append(ops, "wait")
+				w := &waiter{c: b.Waiter()}
+				if fired {
+					w.released, w.expected = true, firedErr
+				}
+				waiters = append(waiters, w)
+
+			case 2:
+				var err error
+				if rng.Intn(2) == 0 {
+					err = fmt.Errorf("err %d", i)
+				}
+				ops = append(ops, fmt.Sprintf("done(%v)", err))
+				if ok := b.Done(err); ok == fired {
+					fail("Done() returned %v when fired was %v", ok, fired)
+				}
+				if !fired {
+					fired, firedErr = true, err
+					for _, w := range waiters {
+						if !w.released {
+							w.released, w.expected = true, err
+						}
+					}
+				}
+
+			case 3:
+				ops = append(ops, "reset")
+				b.Reset()
+				fired, firedErr = false, nil
+			}
+
+			if b.IsDone() != fired {
+				fail("IsDone() was %v, expected %v", b.IsDone(), fired)
+			}
+			for idx, w := range waiters {
+				select {
+				case err := <-w.c:
+					if !w.released || w.received {
+						fail("waiter %d yielded unexpectedly", idx)
+					} else if err != w.expected {
+						fail("waiter %d yielded %v, expected %v", idx, err, w.expected)
+					}
+					w.received = true
+				default:
+					if w.released && !w.received {
+						fail("waiter %d did not yield", idx)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestBroadcastFuzzConcurrent races waiters against Done and Reset, and
+// checks that every waiter registered before a Done is released by it.
+func TestBroadcastFuzzConcurrent(t *testing.T) {
+	rng := rand.New(rand.NewSource(fuzzSeed))
+
+	for iter := 0; iter < fuzzIters; iter++ {
+		b := NewBroadcast()
+		n := rng.Intn(10) + 1
+
+		var wg sync.WaitGroup
+		registered := make(chan (<-chan error), n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				registered <- b.Waiter()
+			}()
+		}
+		go func() {
+			for i := 0; i < 3; i++ {
+				b.Reset()
+			}
+		}()
+		wg.Wait()
+		close(registered)
+
+		b.Done(nil)
+		for w := range registered {
+			select {
+			case err := <-w:
+				if err != nil {
+					t.Fatalf("seed %d, iter %d: waiter yielded %v", fuzzSeed, iter, err)
+				}
+			case <-time.After(dto):
+				t.Fatalf("seed %d, iter %d: waiter did not yield", fuzzSeed, iter)
+			}
+		}
+	}
+}
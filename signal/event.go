@@ -0,0 +1,113 @@
+package signal
+
+import (
+	"context"
+	"sync"
+)
+
+// event is the waiter list shared by Broadcast and Latch. It fires once,
+// yielding the same error to every current and future waiter, until it is
+// reset.
+type event struct {
+	fired   bool
+	err     error
+	waiters []chan error
+	mu      sync.Mutex
+}
+
+// fire yields err to every waiter. It returns false if the event has already
+// fired.
+func (e *event) fire(err error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.fired {
+		return false
+	}
+	e.fired, e.err = true, err
+	for _, c := range e.waiters {
+		c <- err
+	}
+	e.waiters = nil
+	return true
+}
+
+func (e *event) reset() {
+	e.mu.Lock()
+	e.fired, e.err = false, nil
+	e.mu.Unlock()
+}
+
+func (e *event) state() (fired bool, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.fired, e.err
+}
+
+func (e *event) progress() (p Progress) {
+	fired, err := e.state()
+	p.Expected = 1
+	if fired {
+		p.Completed = 1
+		if err != nil {
+			p.Errors = []error{err}
+		}
+	}
+	return p
+}
+
+func (e *event) waiter() <-chan error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c := make(chan error, 1)
+	if e.fired {
+		c <- e.err
+	} else {
+		e.waiters = append(e.waiters, c)
+	}
+	return c
+}
+
+func (e *event) waiterContext(ctx context.Context) <-chan error {
+	w := e.waiter()
+	if ctx == nil || ctx.Done() == nil {
+		return w
+	}
+
+	out := make(chan error, 1)
+	go func() {
+		select {
+		case err := <-w:
+			out <- err
+		case <-ctx.Done():
+			e.unwait(w)
+
+			// The event may have fired while we were unwaiting; if so, that
+			// result should not be lost:
+			select {
+			case err := <-w:
+				out <- err
+			default:
+				out <- ctx.Err()
+			}
+		}
+	}()
+	return out
+}
+
+func (e *event) unwait(w <-chan error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.waiters = removeWaiter(e.waiters, w)
+}
+
+func removeWaiter(waiters []chan error, w <-chan error) []chan error {
+	for i, c := range waiters {
+		if c == w {
+			last := len(waiters) - 1
+			waiters[i] = waiters[last]
+			waiters[last] = nil
+			return waiters[:last]
+		}
+	}
+	return waiters
+}
@@ -1,6 +1,11 @@
 package signal
 
-import "time"
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
 
 const (
 	// HACK FEST: This needs to be high enough so that tests that rely on
@@ -9,3 +14,21 @@ const (
 
 	dto = 100 * tscale
 )
+
+var (
+	fuzzSeed  int64
+	fuzzIters int
+)
+
+func TestMain(m *testing.M) {
+	flag.Int64Var(&fuzzSeed, "signal.fuzzseed", -1,
+		"Randomise the fuzz tests with this non-negative seed")
+	flag.IntVar(&fuzzIters, "signal.fuzziters", 200,
+		"Number of iterations to run for each fuzz test")
+	flag.Parse()
+
+	if fuzzSeed < 0 {
+		fuzzSeed = time.Now().UnixNano()
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,83 @@
+package signal
+
+import (
+	"context"
+	"sync"
+)
+
+// Latch is a one-shot Signal that carries a value to its waiters, for example
+// an address a service has started listening on.
+//
+// The first call to Set() or Done() closes the Latch; subsequent calls have
+// no effect. Once closed, every current and future waiter yields, and the
+// value can be retrieved with Value() or Wait().
+type Latch struct {
+	ev    event
+	value interface{}
+	mu    sync.Mutex
+}
+
+var _ Signal = &Latch{}
+
+func NewLatch() *Latch {
+	return &Latch{}
+}
+
+// Set closes the Latch with value v, releasing all waiters with a nil error.
+// It returns false if the Latch has already been closed.
+func (l *Latch) Set(v interface{}) (ok bool) {
+	// The value must be visible before any waiter is released:
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fired, _ := l.ev.state(); fired {
+		return false
+	}
+	l.value = v
+	return l.ev.fire(nil)
+}
+
+// Done closes the Latch without a value, releasing all waiters with err. It
+// returns false if the Latch has already been closed.
+func (l *Latch) Done(err error) (ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ev.fire(err)
+}
+
+// Value returns the value passed to Set(), and whether the Latch has been
+// closed without an error. It does not block.
+func (l *Latch) Value() (v interface{}, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fired, err := l.ev.state()
+	return l.value, fired && err == nil
+}
+
+// Wait blocks until the Latch is closed and returns its value and error, or
+// until ctx is done and returns ctx.Err(). ctx may be nil, in which case Wait
+// waits forever.
+func (l *Latch) Wait(ctx context.Context) (v interface{}, err error) {
+	if ctx == nil {
+		err = <-l.Waiter()
+	} else {
+		err = <-l.WaiterContext(ctx)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fired, lerr := l.ev.state(); fired {
+		return l.value, lerr
+	}
+	return nil, err
+}
+
+func (l *Latch) Waiter() <-chan error { return l.ev.waiter() }
+
+// WaiterContext is the same as Waiter(), except that if ctx is done before
+// the Latch is closed, the channel yields ctx.Err() and the waiter is
+// discarded. ctx may be nil, in which case it is the same as Waiter().
+func (l *Latch) WaiterContext(ctx context.Context) <-chan error {
+	return l.ev.waiterContext(ctx)
+}
+
+func (l *Latch) Progress() Progress { return l.ev.progress() }
@@ -0,0 +1,115 @@
+package signal
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/shabbyrobe/go-service/internal/assert"
+)
+
+func TestLatchSet(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	l := NewLatch()
+	w := l.Waiter()
+	_, ok := l.Value()
+	tt.MustAssert(!ok)
+	assertWaiterEmpty(tt, w)
+
+	tt.MustAssert(l.Set("addr"))
+	tt.MustAssert(!l.Set("other"))
+	tt.MustAssert(!l.Done(errors.New("yep")))
+	tt.MustOK(<-w)
+
+	v, ok := l.Value()
+	tt.MustAssert(ok)
+	tt.MustEqual("addr", v)
+
+	v, err := l.Wait(nil)
+	tt.MustOK(err)
+	tt.MustEqual("addr", v)
+}
+
+func TestLatchDone(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	l := NewLatch()
+	err := errors.New("yep")
+	tt.MustAssert(l.Done(err))
+	tt.MustAssert(!l.Set("addr"))
+
+	v, ok := l.Value()
+	tt.MustAssert(!ok)
+	tt.MustEqual(nil, v)
+
+	v, rerr := l.Wait(context.Background())
+	tt.MustEqual(err, rerr)
+	tt.MustEqual(nil, v)
+	tt.MustEqual([]error{err}, l.Progress().Errors)
+}
+
+func TestLatchWaitContext(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	l := NewLatch()
+	ctx, cancel := context.WithTimeout(context.Background(), tscale)
+	defer cancel()
+	v, err := l.Wait(ctx)
+	tt.MustEqual(context.DeadlineExceeded, err)
+	tt.MustEqual(nil, v)
+	tt.MustEqual(0, len(l.ev.waiters))
+}
+
+// TestLatchFuzz races several setters against several waiters and checks that
+// every waiter sees the one value that won.
+func TestLatchFuzz(t *testing.T) {
+	rng := rand.New(rand.NewSource(fuzzSeed))
+
+	for iter := 0; iter < fuzzIters; iter++ {
+		l := NewLatch()
+		setters, waiters := rng.Intn(5)+1, rng.Intn(10)+1
+
+		var wg sync.WaitGroup
+		var won int
+		var wonLock sync.Mutex
+		for i := 0; i < setters; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if l.Set(i) {
+					wonLock.Lock()
+					won++
+					wonLock.Unlock()
+				}
+			}(i)
+		}
+
+		seen := make(chan interface{}, waiters)
+		for i := 0; i < waiters; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v, err := l.Wait(nil)
+				if err != nil {
+					t.Errorf("seed %d, iter %d: unexpected error %v", fuzzSeed, iter, err)
+				}
+				seen <- v
+			}()
+		}
+		wg.Wait()
+		close(seen)
+
+		if won != 1 {
+			t.Fatalf("seed %d, iter %d: %d setters won", fuzzSeed, iter, won)
+		}
+		expected, _ := l.Value()
+		for v := range seen {
+			if v != expected {
+				t.Fatalf("seed %d, iter %d: waiter saw %v, expected %v", fuzzSeed, iter, v, expected)
+			}
+		}
+	}
+}
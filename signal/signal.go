@@ -3,6 +3,7 @@ package signal
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,6 +18,10 @@ func IsErrUsedDoneSignal(err error) bool  { return err == errUsedDoneSignal }
 type Signal interface {
 	Done(err error) (ok bool)
 	Waiter() <-chan error
+
+	// Progress reports how many of the expected calls to Done() have been
+	// made so far.
+	Progress() Progress
 }
 
 // MultiSignal coalesces multiple signals into a single error yielded to
@@ -24,37 +29,156 @@ type Signal interface {
 //
 // Much like a sync.WaitGroup, every call to Done() must be preceded by a call
 // to Add(1). Done() decrements the internal counter, which must not go below
-// zero. If the counter is at zero or goes down to zero after Ready() is called,
-// the channel returned by Ready() will yield.
+// zero. If the counter is at zero or goes down to zero after Waiter() is
+// called, the channel returned by Waiter() will yield.
 //
 // The counter may go down to zero and be incremented again, but only if this
-// happens before Ready() is called.
+// happens before Waiter() is called.
+//
+// Be careful calling Add() after calling Waiter(). Probably just don't.
 //
-// Be careful calling Add() after calling Ready(). Probably just don't.
+// Waiter() does not start a goroutine, so a MultiSignal that never completes
+// does not leak one. WaiterContext() starts a goroutine that exits when the
+// signal yields or ctx is done, whichever happens first.
 //
 type MultiSignal interface {
 	Signal
 
-	// You may not call Add() on a MultiSignal that has yielded to its Ready()
-	// channel. This will cause a panic.
+	// You may not call Add() on a MultiSignal that has yielded to its
+	// Waiter() channel. This will cause a panic.
 	Add(int)
+
+	// Cancel causes any current or future waiter to yield an error that
+	// satisfies IsErrSignalCancelled(), unless the signal has already
+	// yielded.
+	Cancel()
+
+	// WaiterContext is the same as Waiter(), except that if ctx is done
+	// before the signal yields, the channel yields ctx.Err() and the waiter
+	// is discarded. ctx may be nil, in which case it is the same as
+	// Waiter().
+	WaiterContext(ctx context.Context) <-chan error
 }
 
-func AwaitSignal(context context.Context, r Signal) error {
+// Progress is a snapshot of a Signal's state.
+type Progress struct {
+	// Expected is the total number of calls to Done() the signal expects,
+	// including those that have already been made.
+	Expected int
+
+	// Completed is the number of calls to Done() made so far.
+	Completed int
+
+	// Errors contains the non-nil errors passed to Done() so far, in the
+	// order they were received.
+	Errors []error
+
+	// Pending contains the keys of any Named signals that have not called
+	// Done() yet, in the order they were named.
+	Pending []interface{}
+
+	// Results contains the keys of any Named signals that have called Done(),
+	// with their errors, in the order they were received.
+	Results []Result
+}
+
+type Result struct {
+	Key interface{}
+	Err error
+}
+
+func (p Progress) Remaining() int { return p.Expected - p.Completed }
+
+func (p Progress) String() string {
+	if len(p.Errors) > 0 {
+		return fmt.Sprintf("%d of %d done, %d failed", p.Completed, p.Expected, len(p.Errors))
+	}
+	return fmt.Sprintf("%d of %d done", p.Completed, p.Expected)
+}
+
+// AwaitSignal waits for the signal to yield, or for ctx to be done. ctx may be
+// nil, in which case AwaitSignal waits forever.
+func AwaitSignal(ctx context.Context, r Signal) error {
+	if ctx == nil {
+		return <-r.Waiter()
+	}
+	if cw, ok := r.(contextWaiter); ok {
+		return <-cw.WaiterContext(ctx)
+	}
 	select {
 	case err := <-r.Waiter():
 		return err
-	case <-context.Done():
-		return context.Err()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+type contextWaiter interface {
+	WaiterContext(ctx context.Context) <-chan error
+}
+
 func AwaitSignalTimeout(timeout time.Duration, signal Signal) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	return AwaitSignal(ctx, signal)
 }
 
+// Named returns a Signal that attributes its call to Done() to key in the
+// parent signal's Progress(). key is listed in Progress().Pending until
+// Done() is called. Waiter() and Progress() are the same as the parent's.
+//
+// Each Named signal counts as one of the calls to Done() expected by the
+// parent; it does not add to them.
+func Named(parent Signal, key interface{}) Signal {
+	if k, ok := parent.(keyer); ok {
+		k.expectKey(key)
+	}
+	return &named{parent: parent, key: key}
+}
+
+type keyer interface {
+	expectKey(key interface{})
+	doneKey(key interface{}, err error) bool
+}
+
+type named struct {
+	parent Signal
+	key    interface{}
+}
+
+func (n *named) Waiter() <-chan error { return n.parent.Waiter() }
+func (n *named) Progress() Progress   { return n.parent.Progress() }
+
+func (n *named) Done(err error) (ok bool) {
+	if k, ok := n.parent.(keyer); ok {
+		return k.doneKey(n.key, err)
+	}
+	return n.parent.Done(err)
+}
+
+// keys tracks the keys of Named signals. It is not safe for concurrent use.
+type keys struct {
+	pending []interface{}
+	results []Result
+}
+
+func (k *keys) expect(key interface{}) { k.pending = append(k.pending, key) }
+
+func (k *keys) done(key interface{}, err error) {
+	for i, p := range k.pending {
+		if p == key {
+			k.pending = append(k.pending[:i], k.pending[i+1:]...)
+			break
+		}
+	}
+	k.results = append(k.results, Result{Key: key, Err: err})
+}
+
+func (k *keys) progress(p *Progress) {
+	p.Pending = append([]interface{}(nil), k.pending...)
+	p.Results = append([]Result(nil), k.results...)
+}
+
 func NewSignal(expected int) Signal {
 	switch expected {
 	case 0:
@@ -72,15 +196,40 @@ func NewSignal(expected int) Signal {
 type signal struct {
 	c         chan error
 	signalled int32
+	err       error
+	keys      keys
+	mu        sync.Mutex
 }
 
 func (ss *signal) Done(err error) (ok bool) {
+	return ss.doneKey(nil, err)
+}
+
+func (ss *signal) expectKey(key interface{}) {
+	if ss == nil {
+		return
+	}
+	ss.mu.Lock()
+	ss.keys.expect(key)
+	ss.mu.Unlock()
+}
+
+func (ss *signal) doneKey(key interface{}, err error) (ok bool) {
 	if ss == nil {
 		return false
 	}
-	if !atomic.CompareAndSwapInt32(&ss.signalled, 0, 1) {
+	ss.mu.Lock()
+	if ss.signalled == 1 {
+		ss.mu.Unlock()
 		return false
 	}
+	atomic.StoreInt32(&ss.signalled, 1)
+	ss.err = err
+	if key != nil {
+		ss.keys.done(key, err)
+	}
+	ss.mu.Unlock()
+
 	ss.c <- err
 	return true
 }
@@ -89,32 +238,50 @@ func (ss *signal) Waiter() <-chan error {
 	return ss.c
 }
 
+func (ss *signal) Progress() (p Progress) {
+	if ss == nil {
+		return p
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	p.Expected = 1
+	if ss.signalled == 1 {
+		p.Completed = 1
+		if ss.err != nil {
+			p.Errors = []error{ss.err}
+		}
+	}
+	ss.keys.progress(&p)
+	return p
+}
+
 type multiSignal struct {
-	expected int
-	done     bool
-	buffer   []error
-	lock     sync.Mutex
-	cond     *sync.Cond
+	expected  int
+	total     int
+	completed int
+	errs      []error
+	cancelled bool
+	done      bool
+	waiters   []chan error
+	keys      keys
+	lock      sync.Mutex
 }
 
 func NewMultiSignal(expected int) MultiSignal {
-	ms := &multiSignal{
+	return &multiSignal{
 		expected: expected,
+		total:    expected,
 	}
-	ms.cond = sync.NewCond(&ms.lock)
-	return ms
 }
 
 func (ms *multiSignal) Cancel() {
 	ms.lock.Lock()
+	defer ms.lock.Unlock()
 	if ms.done {
-		ms.lock.Unlock()
 		return
 	}
-	ms.expected = 0
-	ms.buffer = []error{errSignalCancelled}
-	ms.cond.Broadcast()
-	ms.lock.Unlock()
+	ms.cancelled = true
+	ms.tryYield()
 }
 
 func (ms *multiSignal) Add(n int) {
@@ -128,28 +295,55 @@ func (ms *multiSignal) Add(n int) {
 		panic("service: Add must be > 0")
 	}
 	ms.expected += n
+	ms.total += n
 	ms.lock.Unlock()
 }
 
 func (ms *multiSignal) Done(err error) (ok bool) {
+	return ms.doneKey(nil, err)
+}
+
+func (ms *multiSignal) expectKey(key interface{}) {
 	ms.lock.Lock()
+	ms.keys.expect(key)
+	ms.lock.Unlock()
+}
+
+func (ms *multiSignal) doneKey(key interface{}, err error) (ok bool) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
 	if ms.done {
-		ms.lock.Unlock()
 		return false
 	}
+	if key != nil {
+		ms.keys.done(key, err)
+	}
 
 	ms.expected--
 	if ms.expected < 0 {
-		ms.lock.Unlock()
 		panic("negative expected count")
 	}
 
-	ms.buffer = append(ms.buffer, err)
-	ms.cond.Broadcast()
-	ms.lock.Unlock()
+	ms.completed++
+	if err != nil {
+		ms.errs = append(ms.errs, err)
+	}
+	ms.tryYield()
 	return true
 }
 
+func (ms *multiSignal) Progress() Progress {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	p := Progress{
+		Expected:  ms.total,
+		Completed: ms.completed,
+		Errors:    append([]error(nil), ms.errs...),
+	}
+	ms.keys.progress(&p)
+	return p
+}
+
 func (ms *multiSignal) Waiter() <-chan error {
 	ms.lock.Lock()
 	defer ms.lock.Unlock()
@@ -159,33 +353,73 @@ func (ms *multiSignal) Waiter() <-chan error {
 	}
 
 	c := make(chan error, 1)
+	ms.waiters = append(ms.waiters, c)
+	ms.tryYield()
+	return c
+}
+
+func (ms *multiSignal) WaiterContext(ctx context.Context) <-chan error {
+	w := ms.Waiter()
+	if ctx == nil || ctx.Done() == nil {
+		return w
+	}
+
+	out := make(chan error, 1)
 	go func() {
-		ms.lock.Lock()
-		for ms.expected > 0 {
-			ms.cond.Wait()
-		}
-		ms.done = true
-		out := ms.buffer
-		ms.buffer = nil
-		ms.lock.Unlock()
+		select {
+		case err := <-w:
+			out <- err
+		case <-ctx.Done():
+			ms.unwait(w)
 
-		errs := out[:0]
-		for _, v := range out {
-			if v != nil {
-				errs = append(errs, v)
+			// The signal may have yielded while we were unwaiting; if so,
+			// that result should not be lost:
+			select {
+			case err := <-w:
+				out <- err
+			default:
+				out <- ctx.Err()
 			}
 		}
+	}()
+	return out
+}
 
-		var ret error
-		if len(errs) == 1 {
-			ret = errs[0]
-		} else if len(errs) > 1 {
-			ret = &errGroup{errors: errs}
+func (ms *multiSignal) unwait(w <-chan error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for i, c := range ms.waiters {
+		if c == w {
+			last := len(ms.waiters) - 1
+			ms.waiters[i] = ms.waiters[last]
+			ms.waiters[last] = nil
+			ms.waiters = ms.waiters[:last]
+			return
 		}
-		c <- ret
-	}()
+	}
+}
 
-	return c
+// tryYield yields to all waiters if there are any and the signal is complete.
+// ms.lock must be held.
+func (ms *multiSignal) tryYield() {
+	if len(ms.waiters) == 0 || (ms.expected > 0 && !ms.cancelled) {
+		return
+	}
+	ms.done = true
+
+	var ret error
+	if ms.cancelled {
+		ret = errSignalCancelled
+	} else if len(ms.errs) == 1 {
+		ret = ms.errs[0]
+	} else if len(ms.errs) > 1 {
+		ret = &errGroup{errors: append([]error(nil), ms.errs...)}
+	}
+
+	for _, c := range ms.waiters {
+		c <- ret
+	}
+	ms.waiters = nil
 }
 
 type errGroup struct {
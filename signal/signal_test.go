@@ -126,7 +126,112 @@ func TestMultiWaiterSignalCancelUnblocks(t *testing.T) {
 		out <- <-mrs.Waiter()
 	}()
 
-	mrs.(*multiSignal).Cancel()
+	mrs.Cancel()
 	tt.MustAssert(IsErrSignalCancelled(<-out))
 	tt.MustOK(<-mrs.Waiter())
 }
+
+func TestMultiSignalAllWaitersYield(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mrs := NewMultiSignal(2)
+	w1, w2 := mrs.Waiter(), mrs.Waiter()
+
+	err := errors.New("yep")
+	mrs.Done(nil)
+	assertWaiterEmpty(tt, w1)
+	mrs.Done(err)
+
+	tt.MustEqual(err, <-w1)
+	tt.MustEqual(err, <-w2)
+}
+
+func TestMultiSignalProgress(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mrs := NewMultiSignal(3)
+	mrs.Add(2)
+	tt.MustEqual(Progress{Expected: 5}, mrs.Progress())
+
+	err := errors.New("yep")
+	mrs.Done(nil)
+	mrs.Done(err)
+	mrs.Done(nil)
+
+	p := mrs.Progress()
+	tt.MustEqual(Progress{Expected: 5, Completed: 3, Errors: []error{err}}, p)
+	tt.MustEqual(2, p.Remaining())
+	tt.MustEqual("3 of 5 done, 1 failed", p.String())
+}
+
+func TestSignalProgress(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	tt.MustEqual(Progress{}, NewSignal(0).Progress())
+
+	srs := NewSignal(1)
+	tt.MustEqual(Progress{Expected: 1}, srs.Progress())
+	err := errors.New("yep")
+	srs.Done(err)
+	tt.MustEqual(Progress{Expected: 1, Completed: 1, Errors: []error{err}}, srs.Progress())
+}
+
+func TestMultiSignalWaiterContext(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mrs := NewMultiSignal(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := mrs.WaiterContext(ctx)
+	assertWaiterEmpty(tt, w)
+
+	cancel()
+	tt.MustEqual(context.Canceled, <-w)
+	tt.MustEqual(0, len(mrs.(*multiSignal).waiters))
+
+	// A nil context waits forever, like AwaitSignal:
+	w = mrs.WaiterContext(nil)
+	assertWaiterEmpty(tt, w)
+
+	// The signal can still be waited on once the context is gone:
+	mrs.Done(nil)
+	tt.MustOK(<-w)
+	tt.MustOK(<-mrs.WaiterContext(context.Background()))
+}
+
+func TestMultiSignalAwaitTimeout(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	mrs := NewMultiSignal(1)
+	tt.MustEqual(context.DeadlineExceeded, AwaitSignalTimeout(tscale, mrs))
+
+	mrs.Done(nil)
+	tt.MustOK(AwaitSignalTimeout(tscale, mrs))
+}
+
+func TestNamedSignalProgress(t *testing.T) {
+	tt := assert.WrapTB(t)
+
+	for _, sg := range []Signal{NewSignal(1), NewMultiSignal(3)} {
+		expected := sg.Progress().Expected
+		keys := []string{"a", "b", "c"}[:expected]
+
+		var named []Signal
+		for _, k := range keys {
+			named = append(named, Named(sg, k))
+		}
+		p := sg.Progress()
+		tt.MustEqual(len(keys), len(p.Pending))
+
+		err := errors.New("yep")
+		tt.MustAssert(named[0].Done(err))
+		p = sg.Progress()
+		tt.MustEqual([]Result{{Key: "a", Err: err}}, p.Results)
+		tt.MustEqual(len(keys)-1, len(p.Pending))
+
+		for _, n := range named[1:] {
+			tt.MustAssert(n.Done(nil))
+		}
+		tt.MustEqual(err, <-named[0].Waiter())
+		tt.MustEqual(0, len(sg.Progress().Pending))
+	}
+}
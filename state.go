@@ -26,11 +26,12 @@ const (
 	Started
 	Halting
 	Ended
+	Draining
 )
 
-var States = []State{Halting, Halted, Starting, Started, Ended}
+var States = []State{Halting, Halted, Starting, Started, Draining, Ended}
 
-func (s State) IsRunning() bool { return s == Starting || s == Started }
+func (s State) IsRunning() bool { return s == Starting || s == Started || s == Draining }
 
 func (s State) name() string {
 	switch s {
@@ -44,6 +45,8 @@ func (s State) name() string {
 		return "halting"
 	case Ended:
 		return "ended"
+	case Draining:
+		return "draining"
 	case NoState:
 		return "<none>"
 	}
@@ -55,7 +58,7 @@ func (s State) String() string {
 	if out == "" {
 		out = "("
 		first := true
-		for i := Ended; i > 0; i >>= 1 {
+		for i := Draining; i > 0; i >>= 1 {
 			if i&s != i {
 				continue
 			}
@@ -6,7 +6,7 @@ import (
 )
 
 func StartTimeout(timeout time.Duration, runner Runner, services ...*Service) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := WithClockTimeout(context.Background(), clockOf(runner), timeout)
 	defer cancel()
 	return runner.Start(ctx, services...)
 }
@@ -24,7 +24,7 @@ func MustStartTimeout(timeout time.Duration, runner Runner, services ...*Service
 }
 
 func HaltTimeout(timeout time.Duration, runner Runner, services ...*Service) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := WithClockTimeout(context.Background(), clockOf(runner), timeout)
 	defer cancel()
 	return runner.Halt(ctx, services...)
 }
@@ -42,18 +42,19 @@ func MustHalt(ctx context.Context, r Runner, services ...*Service) {
 	}
 }
 
-// MustHaltTimeout calls MustHalt using context.WithTimeout()
+// MustHaltTimeout calls MustHalt using WithClockTimeout() and the Runner's
+// Clock.
 func MustHaltTimeout(timeout time.Duration, r Runner, services ...*Service) {
 	if r == nil {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := WithClockTimeout(context.Background(), clockOf(r), timeout)
 	defer cancel()
 	MustHalt(ctx, r, services...)
 }
 
 func ShutdownTimeout(timeout time.Duration, runner Runner) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := WithClockTimeout(context.Background(), clockOf(runner), timeout)
 	defer cancel()
 	return runner.Shutdown(ctx)
 }
@@ -72,12 +73,13 @@ func MustShutdown(ctx context.Context, r Runner) {
 	}
 }
 
-// MustShutdownTimeout calls MustShutdown using context.WithTimeout()
+// MustShutdownTimeout calls MustShutdown using WithClockTimeout() and the
+// Runner's Clock.
 func MustShutdownTimeout(timeout time.Duration, r Runner) {
 	if r == nil {
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := WithClockTimeout(context.Background(), clockOf(r), timeout)
 	defer cancel()
 	MustShutdown(ctx, r)
 }